@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// providerMarkerFile names the provider or profile for a directory tree
+const providerMarkerFile = ".claude-provider"
+
+// autoTarget is the provider or profile selected for a directory
+type autoTarget struct {
+	Provider string // provider name, empty when Profile is set
+	Profile  string // profile name
+	Dir      string // directory the selection applies to
+	Source   string // marker file or providers.json mapping
+}
+
+// parseAutoTarget parses a selection such as "z_ai" or "profile:work"
+func parseAutoTarget(value string) (*autoTarget, error) {
+	value = strings.TrimSpace(value)
+	if name, ok := strings.CutPrefix(value, "profile:"); ok {
+		name = strings.TrimSpace(name)
+		if err := validateProfileName(name); err != nil {
+			return nil, err
+		}
+		return &autoTarget{Profile: name}, nil
+	}
+	if value == "" {
+		return nil, fmt.Errorf("no provider named")
+	}
+	return &autoTarget{Provider: value}, nil
+}
+
+// readProviderMarker reads the first non-comment line of a marker file
+func readProviderMarker(path string) (*autoTarget, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		target, err := parseAutoTarget(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", path, err)
+		}
+		target.Dir = filepath.Dir(path)
+		target.Source = path
+		return target, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("invalid %s: file is empty", path)
+}
+
+// findAutoTarget returns the selection of the nearest directory at or above
+// dir, from a marker file or the directories mapping of providers.json.
+// A marker wins over a mapping for the same directory.
+func (app *Application) findAutoTarget(dir string) (*autoTarget, error) {
+	mappings := make(map[string]string, len(app.directories))
+	for path, value := range app.directories {
+		mappings[filepath.Clean(app.expandHome(path))] = value
+	}
+
+	for {
+		marker := filepath.Join(dir, providerMarkerFile)
+		if _, err := os.Stat(marker); err == nil {
+			return readProviderMarker(marker)
+		}
+		if value, ok := mappings[dir]; ok {
+			target, err := parseAutoTarget(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid directory mapping %q in %s: %w", dir, app.providersFile, err)
+			}
+			target.Dir = dir
+			target.Source = app.providersFile
+			return target, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// runAutoCommand applies the provider or profile selected for the working
+// directory. It is silent when nothing is selected or the selection is
+// already active, and never prompts, so it can run from a shell hook.
+func (app *Application) runAutoCommand(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("auto takes no arguments")
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	target, err := app.findAutoTarget(cwd)
+	if err != nil || target == nil {
+		return err
+	}
+
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	current := app.currentProvider(config)
+
+	// Runs from a shell hook with no one to prompt or confirm
+	app.nonInteractive = true
+	app.assumeYes = true
+
+	if target.Profile != "" {
+		profiles, err := app.loadProfiles()
+		if err != nil {
+			return err
+		}
+		profile := profiles.find(target.Profile)
+		if profile == nil {
+			return fmt.Errorf("profile %q from %s not found", target.Profile, target.Source)
+		}
+		if profiles.Active == profile.Name && profile.Provider == current {
+			return nil
+		}
+
+		app.cyan.Printf("📂 %s selects profile %q\n", target.Source, profile.Name)
+		app.silence()
+		return app.useProfile(profile.Name)
+	}
+
+	provider, err := app.resolveProvider(target.Provider)
+	if err != nil {
+		return fmt.Errorf("%s: %w", target.Source, err)
+	}
+	if provider.Name() == current || (current == ProviderUnknown && provider.Name() == ProviderAnthropic) {
+		return nil
+	}
+
+	app.cyan.Printf("📂 %s selects %s\n", target.Source, provider.DisplayName())
+	app.silence()
+	return app.switchTo(provider)
+}
+
+// autoHook returns the shell code that runs "claude-switch auto" whenever
+// the working directory changes
+func autoHook(shell, execPath string) string {
+	switch shell {
+	case "zsh":
+		return fmt.Sprintf(`
+# Claude Code API Switcher auto hook
+_claude_switch_auto() { '%s' auto; }
+autoload -U add-zsh-hook
+add-zsh-hook chpwd _claude_switch_auto
+_claude_switch_auto
+`, execPath)
+	case "fish":
+		return fmt.Sprintf(`
+# Claude Code API Switcher auto hook
+function _claude_switch_auto --on-variable PWD
+    '%s' auto
+end
+`, execPath)
+	default:
+		return fmt.Sprintf(`
+# Claude Code API Switcher auto hook
+_claude_switch_auto() {
+  if [ "$PWD" != "$_CLAUDE_SWITCH_PWD" ]; then
+    _CLAUDE_SWITCH_PWD="$PWD"
+    '%s' auto
+  fi
+}
+case ";$PROMPT_COMMAND;" in
+  *";_claude_switch_auto;"*) ;;
+  *) PROMPT_COMMAND="_claude_switch_auto${PROMPT_COMMAND:+;$PROMPT_COMMAND}" ;;
+esac
+`, execPath)
+	}
+}
+
+// shellOfConfig returns the shell a configuration file belongs to
+func shellOfConfig(path string) string {
+	switch {
+	case strings.Contains(path, "fish"):
+		return "fish"
+	case strings.HasSuffix(path, ".zshrc"):
+		return "zsh"
+	default:
+		return "bash"
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFindAutoTarget(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "client", providerMarkerFile), "# billed to the client\n\nprofile:client-zai\n")
+	writeTestFile(t, filepath.Join(root, "client", "both", providerMarkerFile), "anthropic\n")
+	writeTestFile(t, filepath.Join(root, "empty", providerMarkerFile), "# nothing yet\n")
+	writeTestFile(t, filepath.Join(root, "bad", providerMarkerFile), "profile:../x\n")
+	for _, dir := range []string{"client/api/internal", "mapped/sub", "plain"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := newTestApp(t)
+	app.directories = map[string]string{
+		filepath.Join(root, "mapped"):         "z_ai",
+		filepath.Join(root, "client", "both"): "z_ai",
+	}
+
+	tests := []struct {
+		name     string
+		dir      string
+		provider string
+		profile  string
+		from     string // directory the selection applies to
+		wantErr  bool
+	}{
+		{name: "marker", dir: "client", profile: "client-zai", from: "client"},
+		{name: "marker above", dir: "client/api/internal", profile: "client-zai", from: "client"},
+		{name: "marker wins over mapping", dir: "client/both", provider: ProviderAnthropic, from: "client/both"},
+		{name: "mapping", dir: "mapped/sub", provider: ProviderZAI, from: "mapped"},
+		{name: "nothing selected", dir: "plain"},
+		{name: "empty marker", dir: "empty", wantErr: true},
+		{name: "invalid profile", dir: "bad", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := app.findAutoTarget(filepath.Join(root, tt.dir))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findAutoTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.from == "" {
+				if target != nil {
+					t.Errorf("findAutoTarget() = %+v, want nothing", target)
+				}
+				return
+			}
+			if target == nil {
+				t.Fatal("findAutoTarget() found nothing")
+			}
+			if target.Provider != tt.provider || target.Profile != tt.profile || target.Dir != filepath.Join(root, tt.from) {
+				t.Errorf("findAutoTarget() = %+v, want provider %q, profile %q from %s", target, tt.provider, tt.profile, tt.from)
+			}
+		})
+	}
+}
+
+func TestRunAutoCommand(t *testing.T) {
+	app := newTestApp(t)
+	t.Setenv("Z_AI_AUTH_TOKEN", "sk-zai")
+	writeTestFile(t, app.settingsFile, `{"model":"opus"}`)
+	project := filepath.Join(t.TempDir(), "project")
+	writeTestFile(t, filepath.Join(project, providerMarkerFile), "z_ai\n")
+	chdirTest(t, project)
+
+	if err := app.runAutoCommand(nil); err != nil {
+		t.Fatalf("auto error = %v", err)
+	}
+	first, err := os.ReadFile(app.settingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(first), "api.z.ai") {
+		t.Fatalf("auto did not switch to z_ai: %s", first)
+	}
+
+	// The selection is active now; a second run changes nothing
+	if err := app.runAutoCommand(nil); err != nil {
+		t.Fatalf("second auto error = %v", err)
+	}
+	if second, _ := os.ReadFile(app.settingsFile); string(second) != string(first) {
+		t.Errorf("second auto rewrote the settings:\n%s", second)
+	}
+}
+
+func TestShellOfConfig(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/home/me/.zshrc", "zsh"},
+		{"/home/me/.bashrc", "bash"},
+		{"/home/me/.bash_profile", "bash"},
+		{"/home/me/.config/fish/config.fish", "fish"},
+	}
+	for _, tt := range tests {
+		if got := shellOfConfig(tt.path); got != tt.want {
+			t.Errorf("shellOfConfig(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAutoHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks are for Unix shells")
+	}
+
+	triggers := map[string]string{
+		"bash": "PROMPT_COMMAND=",
+		"zsh":  "add-zsh-hook chpwd",
+		"fish": "--on-variable PWD",
+	}
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		t.Run(shell, func(t *testing.T) {
+			text := autoHook(shell, "/opt/claude switch")
+			if !strings.Contains(text, "'/opt/claude switch' auto") || !strings.Contains(text, triggers[shell]) {
+				t.Fatalf("%s hook does not run auto on %s:\n%s", shell, triggers[shell], text)
+			}
+
+			path, err := exec.LookPath(shell)
+			if err != nil {
+				t.Skipf("%s is not installed", shell)
+			}
+
+			// The hook calls a fake claude-switch logging each run
+			dir := t.TempDir()
+			log := filepath.Join(dir, "runs")
+			fake := filepath.Join(dir, "claude switch")
+			writeTestFile(t, fake, "#!/bin/sh\necho \"$1 $PWD\" >> '"+log+"'\n")
+			if err := os.Chmod(fake, 0700); err != nil {
+				t.Fatal(err)
+			}
+			hook := filepath.Join(dir, "hook")
+			writeTestFile(t, hook, autoHook(shell, fake))
+
+			// Start in one directory, then change to another; the prompt
+			// runs twice there but the hook only once
+			script := map[string]string{
+				"bash": `cd /; source "$1"; eval "$PROMPT_COMMAND"; cd "$2"; eval "$PROMPT_COMMAND"; eval "$PROMPT_COMMAND"`,
+				"zsh":  `cd /; source "$1"; cd "$2"`,
+				"fish": `cd /; source $argv[1]; cd $argv[2]`,
+			}[shell]
+			cmd := exec.Command(path, "-c", script, shell, hook, dir)
+			if shell == "fish" {
+				cmd = exec.Command(path, "-c", script, hook, dir)
+			}
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%s hook failed: %v\n%s", shell, err, out)
+			}
+
+			runs, err := os.ReadFile(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(runs)), "\n")
+			if want := "auto " + dir; lines[len(lines)-1] != want || len(lines) > 2 {
+				t.Errorf("hook runs = %q, want one run of %q after the cd", lines, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,721 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Budget actions once a limit is used up
+const (
+	BudgetBlock     = "block"     // the proxy rejects requests
+	BudgetDowngrade = "downgrade" // opus and sonnet requests use the haiku tier
+	BudgetSwitch    = "switch"    // the proxy switches to the fallback profile
+)
+
+const (
+	// defaultHaikuModel serves downgraded requests when the upstream maps
+	// no haiku tier
+	defaultHaikuModel = "claude-haiku-4-5"
+	// hookTimeout bounds how long a hook command may run
+	hookTimeout = 30 * time.Second
+	// eventBudgetAlert is the hook event of a crossed alert threshold
+	eventBudgetAlert = "budget_alert"
+)
+
+// defaultBudgetAlerts are the alert thresholds in percent of a limit
+var defaultBudgetAlerts = []int{50, 80, 100}
+
+// Budget limits the spending of a profile through the proxy per day and
+// per calendar month, in local time
+type Budget struct {
+	DailyUSD      float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD    float64 `json:"monthly_usd,omitempty"`
+	DailyTokens   int64   `json:"daily_tokens,omitempty"`
+	MonthlyTokens int64   `json:"monthly_tokens,omitempty"`
+	Action        string  `json:"action,omitempty"`   // block (default), downgrade or switch
+	Fallback      string  `json:"fallback,omitempty"` // profile the switch action selects
+	Alerts        []int   `json:"alerts,omitempty"`   // thresholds in percent
+}
+
+// action returns the action of the budget, block by default
+func (b *Budget) action() string {
+	if b.Action == "" {
+		return BudgetBlock
+	}
+	return b.Action
+}
+
+// alerts returns the alert thresholds of the budget in ascending order
+func (b *Budget) alerts() []int {
+	if len(b.Alerts) == 0 {
+		return defaultBudgetAlerts
+	}
+	return b.Alerts
+}
+
+// BudgetLimit is one limit of a budget and its use in the current period
+type BudgetLimit struct {
+	Period  string    `json:"period"` // daily or monthly
+	Metric  string    `json:"metric"` // usd or tokens
+	Limit   float64   `json:"limit"`
+	Used    float64   `json:"used"`
+	Percent float64   `json:"percent"`
+	Resets  time.Time `json:"resets"`
+}
+
+// String describes the use of the limit, e.g. "$4.20 of $10.00 today"
+func (l *BudgetLimit) String() string {
+	period := "today"
+	if l.Period == "monthly" {
+		period = "this month"
+	}
+	if l.Metric == "usd" {
+		return fmt.Sprintf("%s of %s %s", formatCost(l.Used), formatCost(l.Limit), period)
+	}
+	return fmt.Sprintf("%s of %s tokens %s", formatTokens(int(l.Used)), formatTokens(int(l.Limit)), period)
+}
+
+// budgetSpend is the spending of one upstream in the current day and month
+type budgetSpend struct {
+	day, month             time.Time // start of the periods
+	dayUSD, monthUSD       float64
+	dayTokens, monthTokens int64
+}
+
+// periodStarts returns the start of the day and the month of a time
+func periodStarts(now time.Time) (time.Time, time.Time) {
+	y, m, d := now.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+}
+
+// roll starts new periods once the day or month is over
+func (s *budgetSpend) roll(now time.Time) {
+	day, month := periodStarts(now)
+	if !day.Equal(s.day) {
+		s.day, s.dayUSD, s.dayTokens = day, 0, 0
+	}
+	if !month.Equal(s.month) {
+		s.month, s.monthUSD, s.monthTokens = month, 0, 0
+	}
+}
+
+// add counts a request of the current periods
+func (s *budgetSpend) add(app *Application, r *UsageRecord) {
+	cost := 0.0
+	if price, ok := app.modelPrice(r.Provider, r.Model); ok {
+		cost = price.cost(r)
+	}
+	tokens := int64(r.InputTokens + r.OutputTokens + r.CacheCreationTokens + r.CacheReadTokens)
+	t := r.Time.In(s.month.Location())
+	if !t.Before(s.month) {
+		s.monthUSD += cost
+		s.monthTokens += tokens
+	}
+	if !t.Before(s.day) {
+		s.dayUSD += cost
+		s.dayTokens += tokens
+	}
+}
+
+// limits returns the limits of a budget with their use
+func (b *Budget) limits(s *budgetSpend) []*BudgetLimit {
+	var limits []*BudgetLimit
+	add := func(period, metric string, limit, used float64, resets time.Time) {
+		if limit > 0 {
+			limits = append(limits, &BudgetLimit{Period: period, Metric: metric, Limit: limit, Used: used, Percent: used / limit * 100, Resets: resets})
+		}
+	}
+	nextDay, nextMonth := s.day.AddDate(0, 0, 1), s.month.AddDate(0, 1, 0)
+	add("daily", "usd", b.DailyUSD, s.dayUSD, nextDay)
+	add("monthly", "usd", b.MonthlyUSD, s.monthUSD, nextMonth)
+	add("daily", "tokens", float64(b.DailyTokens), float64(s.dayTokens), nextDay)
+	add("monthly", "tokens", float64(b.MonthlyTokens), float64(s.monthTokens), nextMonth)
+	return limits
+}
+
+// exhausted returns the first used up limit of a budget
+func (b *Budget) exhausted(s *budgetSpend) *BudgetLimit {
+	for _, limit := range b.limits(s) {
+		if limit.Used >= limit.Limit {
+			return limit
+		}
+	}
+	return nil
+}
+
+// loadSpend totals the ledger of the current month per upstream
+func (app *Application) loadSpend(now time.Time) (map[string]*budgetSpend, error) {
+	_, month := periodStarts(now)
+	records, err := app.loadUsage(month)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", app.usageFile, err)
+	}
+	spend := map[string]*budgetSpend{}
+	for _, r := range records {
+		s := spend[r.Upstream]
+		if s == nil {
+			s = &budgetSpend{}
+			s.roll(now)
+			spend[r.Upstream] = s
+		}
+		s.add(app, r)
+	}
+	return spend, nil
+}
+
+// budgetStatus returns the use of a profile's budget limits
+func (app *Application) budgetStatus(profile *Profile, spend map[string]*budgetSpend, now time.Time) []*BudgetLimit {
+	s := spend["profile:"+profile.Name]
+	if s == nil {
+		s = &budgetSpend{}
+		s.roll(now)
+	}
+	return profile.Budget.limits(s)
+}
+
+// printBudget prints the limits of a profile's budget, highlighting those
+// past an alert threshold
+func (app *Application) printBudget(profile *Profile, limits []*BudgetLimit, indent string) {
+	alerts := profile.Budget.alerts()
+	for _, limit := range limits {
+		line := fmt.Sprintf("%s%s (%.0f%%)", indent, limit.String(), limit.Percent)
+		switch {
+		case limit.Percent >= 100:
+			app.red.Printf("%s; %s\n", line, budgetActionText(profile.Budget))
+		case limit.Percent >= float64(alerts[0]):
+			app.yellow.Println(line)
+		default:
+			fmt.Println(line)
+		}
+	}
+}
+
+// budgetActionText describes what happens to a profile past its budget
+func budgetActionText(b *Budget) string {
+	switch b.action() {
+	case BudgetDowngrade:
+		return "downgraded to haiku"
+	case BudgetSwitch:
+		return "falls back to " + b.Fallback
+	default:
+		return "requests blocked"
+	}
+}
+
+// runProfileBudget shows or sets the budget of a profile
+func (app *Application) runProfileBudget(args []string) error {
+	const usage = "usage: claude-switch profile budget <name> [--daily-usd <usd>] [--monthly-usd <usd>] [--daily-tokens <n>] [--monthly-tokens <n>] [--action block|downgrade|switch] [--fallback <profile>] [--alerts <50,80,100>] [--clear]"
+	if len(args) == 0 {
+		return usageErrorf(usage)
+	}
+	name := args[0]
+
+	fs := flag.NewFlagSet("profile budget", flag.ContinueOnError)
+	dailyUSD := fs.Float64("daily-usd", -1, "Daily spending limit in USD, 0 to remove")
+	monthlyUSD := fs.Float64("monthly-usd", -1, "Monthly spending limit in USD, 0 to remove")
+	dailyTokens := fs.Int64("daily-tokens", -1, "Daily token limit, 0 to remove")
+	monthlyTokens := fs.Int64("monthly-tokens", -1, "Monthly token limit, 0 to remove")
+	action := fs.String("action", "", "block, downgrade or switch once a limit is used up")
+	fallback := fs.String("fallback", "", "Profile the switch action selects")
+	alerts := fs.String("alerts", "", "Alert thresholds in percent, e.g. 50,80,100")
+	clear := fs.Bool("clear", false, "Remove the budget")
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf(usage)
+	}
+
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return err
+	}
+	profile := profiles.find(name)
+	if profile == nil {
+		return fmt.Errorf("profile %q not found", name)
+	}
+
+	if fs.NFlag() == 0 {
+		return app.showProfileBudget(profile)
+	}
+	if *clear {
+		if fs.NFlag() > 1 {
+			return usageErrorf("--clear cannot be combined with other flags")
+		}
+		if profile.Budget == nil {
+			app.yellow.Printf("⚠️  Profile %q has no budget\n", profile.Name)
+			return nil
+		}
+		profile.Budget = nil
+		if err := app.saveProfiles(profiles); err != nil {
+			return err
+		}
+		app.green.Printf("✅ Removed the budget of profile %q\n", profile.Name)
+		return nil
+	}
+
+	budget := &Budget{}
+	if profile.Budget != nil {
+		copied := *profile.Budget
+		budget = &copied
+	}
+	if *dailyUSD >= 0 {
+		budget.DailyUSD = *dailyUSD
+	}
+	if *monthlyUSD >= 0 {
+		budget.MonthlyUSD = *monthlyUSD
+	}
+	if *dailyTokens >= 0 {
+		budget.DailyTokens = *dailyTokens
+	}
+	if *monthlyTokens >= 0 {
+		budget.MonthlyTokens = *monthlyTokens
+	}
+	if *action != "" {
+		budget.Action = *action
+	}
+	if *fallback != "" {
+		budget.Fallback = *fallback
+	}
+	if *alerts != "" {
+		if budget.Alerts, err = parseAlerts(*alerts); err != nil {
+			return &usageError{msg: err.Error()}
+		}
+	}
+
+	switch budget.action() {
+	case BudgetBlock, BudgetDowngrade:
+	case BudgetSwitch:
+		if budget.Fallback == "" {
+			return usageErrorf("--action switch needs --fallback <profile>")
+		}
+		if budget.Fallback == profile.Name {
+			return usageErrorf("a profile cannot fall back to itself")
+		}
+		if profiles.find(budget.Fallback) == nil {
+			return fmt.Errorf("fallback profile %q not found", budget.Fallback)
+		}
+	default:
+		return usageErrorf("unknown --action %q (available: block, downgrade, switch)", budget.Action)
+	}
+	if len(budget.limits(&budgetSpend{})) == 0 {
+		return usageErrorf("set at least one of --daily-usd, --monthly-usd, --daily-tokens and --monthly-tokens")
+	}
+
+	profile.Budget = budget
+	if err := app.saveProfiles(profiles); err != nil {
+		return err
+	}
+	app.green.Printf("✅ Budget of profile %q saved\n", profile.Name)
+	return app.showProfileBudget(profile)
+}
+
+// parseAlerts parses comma-separated alert thresholds
+func parseAlerts(value string) ([]int, error) {
+	var alerts []int
+	for _, field := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(field), "%")))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid alert threshold %q", field)
+		}
+		alerts = append(alerts, n)
+	}
+	sort.Ints(alerts)
+	return alerts, nil
+}
+
+// showProfileBudget prints a profile's budget and its use
+func (app *Application) showProfileBudget(profile *Profile) error {
+	if profile.Budget == nil {
+		app.yellow.Printf("⚠️  Profile %q has no budget\n", profile.Name)
+		app.cyan.Printf("   Set one with 'claude-switch profile budget %s --daily-usd 10'\n", profile.Name)
+		return nil
+	}
+
+	now := time.Now()
+	spend, err := app.loadSpend(now)
+	if err != nil {
+		return err
+	}
+	app.cyan.Printf("💰 Budget of profile %q\n", profile.Name)
+	fmt.Println()
+	app.printBudget(profile, app.budgetStatus(profile, spend, now), "  ")
+	fmt.Println()
+	app.cyan.Printf("  When used up: %s\n", budgetActionText(profile.Budget))
+	alerts := make([]string, len(profile.Budget.alerts()))
+	for i, threshold := range profile.Budget.alerts() {
+		alerts[i] = strconv.Itoa(threshold) + "%"
+	}
+	app.cyan.Printf("  Alerts: %s\n", strings.Join(alerts, ", "))
+	return nil
+}
+
+// budgetTracker keeps the spending of every upstream while the proxy runs
+// and remembers the alert thresholds already reported
+type budgetTracker struct {
+	mu      sync.Mutex
+	spend   map[string]*budgetSpend
+	alerted map[string]bool
+}
+
+// newBudgetTracker totals the ledger of the current month. Thresholds
+// crossed before the proxy started are not reported again.
+func (app *Application) newBudgetTracker() (*budgetTracker, error) {
+	now := time.Now()
+	spend, err := app.loadSpend(now)
+	if err != nil {
+		return nil, err
+	}
+	t := &budgetTracker{spend: spend, alerted: map[string]bool{}}
+
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, profile := range profiles.Profiles {
+		if profile.Budget != nil {
+			t.crossed(profile, now)
+		}
+	}
+	return t, nil
+}
+
+// current returns the rolled spending of an upstream
+func (t *budgetTracker) current(upstream string, now time.Time) *budgetSpend {
+	s := t.spend[upstream]
+	if s == nil {
+		s = &budgetSpend{}
+		t.spend[upstream] = s
+	}
+	s.roll(now)
+	return s
+}
+
+// exhausted returns the used up limit of a profile's budget, if any
+func (t *budgetTracker) exhausted(profile *Profile, now time.Time) *BudgetLimit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return profile.Budget.exhausted(t.current("profile:"+profile.Name, now))
+}
+
+// add counts a recorded request
+func (t *budgetTracker) add(app *Application, r *UsageRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current(r.Upstream, r.Time).add(app, r)
+}
+
+// budgetAlert is a crossed alert threshold
+type budgetAlert struct {
+	Event     string       `json:"event"`
+	Time      time.Time    `json:"time"`
+	Profile   string       `json:"profile"`
+	Threshold int          `json:"threshold"`
+	Action    string       `json:"action,omitempty"` // set once a limit is used up
+	Fallback  string       `json:"fallback,omitempty"`
+	Limit     *BudgetLimit `json:"limit"`
+}
+
+// crossed returns the alert thresholds a profile crossed since the last
+// call, the highest per limit
+func (t *budgetTracker) crossed(profile *Profile, now time.Time) []*budgetAlert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var alerts []*budgetAlert
+	for _, limit := range profile.Budget.limits(t.current("profile:"+profile.Name, now)) {
+		var alert *budgetAlert
+		for _, threshold := range profile.Budget.alerts() {
+			key := fmt.Sprintf("%s|%s|%s|%d|%s", profile.Name, limit.Period, limit.Metric, threshold, limit.Resets.Format(time.RFC3339))
+			if limit.Percent < float64(threshold) || t.alerted[key] {
+				continue
+			}
+			t.alerted[key] = true
+			alert = &budgetAlert{Event: eventBudgetAlert, Time: now, Profile: profile.Name, Threshold: threshold, Limit: limit}
+			if limit.Used >= limit.Limit {
+				alert.Action = profile.Budget.action()
+				alert.Fallback = profile.Budget.Fallback
+			}
+		}
+		if alert != nil {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// budgetProfile returns the profile and its budget an upstream belongs to,
+// or nil when it has no budget
+func (app *Application) budgetProfile(upstream string) (*Profile, error) {
+	name, ok := strings.CutPrefix(upstream, "profile:")
+	if !ok {
+		return nil, nil
+	}
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if profile := profiles.find(name); profile != nil && profile.Budget != nil {
+		return profile, nil
+	}
+	return nil, nil
+}
+
+// switchOverBudget replaces a profile whose budget is used up with its
+// fallback: as the upstream, which also becomes the active profile, and as
+// the target of tier routes. Runs inside the proxy, so it prints nothing.
+func (app *Application) switchOverBudget(profile *Profile) error {
+	lock, err := app.acquireLock()
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	state, err := app.loadProxyState()
+	if err != nil || state == nil {
+		return err
+	}
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return err
+	}
+	if profiles.find(profile.Budget.Fallback) == nil {
+		return fmt.Errorf("fallback profile %q not found", profile.Budget.Fallback)
+	}
+
+	target, fallback := "profile:"+profile.Name, "profile:"+profile.Budget.Fallback
+	changed := false
+	if state.Upstream == target {
+		state.Upstream = fallback
+		changed = true
+	}
+	for tier, route := range state.Routes {
+		if route == target {
+			state.Routes[tier] = fallback
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := app.saveProxyState(state); err != nil {
+		return err
+	}
+
+	if state.Upstream == fallback {
+		profiles.Active = profile.Budget.Fallback
+		return app.saveProfiles(profiles)
+	}
+	return nil
+}
+
+// runHook runs the command configured for an event in providers.json with
+// the event as JSON on stdin
+func (app *Application) runHook(event string, payload interface{}) error {
+	command := app.hooks[event]
+	if command == "" {
+		return nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "CLAUDE_SWITCH_EVENT="+event)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s hook timed out after %s", event, hookTimeout)
+		}
+		return fmt.Errorf("%s hook failed: %w", event, err)
+	}
+	return nil
+}
+
+// downgradeModel maps opus and sonnet requests to the upstream's haiku tier
+func (u *proxyUpstream) downgradeModel(model string) string {
+	if tier := modelTier(model); tier == "opus" || tier == "sonnet" {
+		if haiku := u.env["ANTHROPIC_DEFAULT_HAIKU_MODEL"]; haiku != "" {
+			return haiku
+		}
+		return defaultHaikuModel
+	}
+	return u.mapModel(model)
+}
+
+// applyBudget picks the upstream of a request and enforces its profile's
+// budget. It reports whether to downgrade the model, and returns nil after
+// rejecting a request the budget blocks.
+func (s *proxyServer) applyBudget(w http.ResponseWriter, r *http.Request, routes *proxyRoutes, body []byte) (*proxyUpstream, bool) {
+	var request struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(body, &request)
+	upstream := routes.forModel(request.Model)
+
+	profile, err := s.app.budgetProfile(upstream.name)
+	if err != nil {
+		s.app.logf(s.app.yellow, "⚠️  Failed to read budgets: %v", err)
+		return upstream, false
+	}
+	if profile == nil {
+		return upstream, false
+	}
+	limit := s.budgets.exhausted(profile, time.Now())
+	if limit == nil {
+		return upstream, false
+	}
+
+	switch profile.Budget.action() {
+	case BudgetDowngrade:
+		return upstream, true
+	case BudgetSwitch:
+		// The switch is usually done when the limit was reached; it is
+		// repeated after a restart or a manual switch back. The request does
+		// not wait for it and goes to the fallback at once.
+		s.switchFallback(profile)
+		next, err := s.app.resolveProxyUpstream("profile:" + profile.Budget.Fallback)
+		if err == nil {
+			s.app.logf(s.app.yellow, "💸 %s is over budget (%s); using %s", upstream.name, limit, next.name)
+			return next, false
+		}
+		s.app.logf(s.app.red, "❌ Failed to use %s instead of %s: %v", profile.Budget.Fallback, profile.Name, err)
+	}
+
+	message := fmt.Sprintf("The claude-switch budget of profile %q is used up: %s. Requests are blocked until %s; raise the limit with 'claude-switch profile budget %s'.",
+		profile.Name, limit, limit.Resets.Format("2006-01-02 15:04"), profile.Name)
+	// A quota, not a malformed request; Retry-After tells clients when it resets
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(limit.Resets).Seconds())+1))
+	writeAPIError(w, http.StatusTooManyRequests, "rate_limit_error", message)
+	s.app.logf(s.app.red, "%s %s %s → %s blocked: budget used up (%s)", r.Method, r.URL.Path, request.Model, upstream.name, limit)
+	return nil, false
+}
+
+// checkBudget counts a recorded request against its profile's budget,
+// reports newly crossed alert thresholds and switches to the fallback once
+// a switch budget is used up
+func (s *proxyServer) checkBudget(record *UsageRecord) {
+	s.budgets.add(s.app, record)
+
+	profile, err := s.app.budgetProfile(record.Upstream)
+	if err != nil || profile == nil {
+		return
+	}
+	for _, alert := range s.budgets.crossed(profile, time.Now()) {
+		c := s.app.yellow
+		if alert.Action != "" {
+			c = s.app.red
+		}
+		s.app.logf(c, "💸 Budget of %s at %d%%: %s", profile.Name, alert.Threshold, alert.Limit)
+
+		if alert.Action == BudgetSwitch {
+			s.switchFallback(profile)
+		}
+		alert := alert
+		s.budgetAction(func() {
+			if err := s.app.runHook(eventBudgetAlert, alert); err != nil {
+				s.app.logf(s.app.yellow, "⚠️  %v", err)
+			}
+		})
+	}
+}
+
+// switchFallback switches a profile over to its budget fallback in the
+// background, so that neither requests nor hooks wait for the settings lock.
+// A switch already running for the profile is not started again.
+func (s *proxyServer) switchFallback(profile *Profile) {
+	s.switchMu.Lock()
+	if s.switching[profile.Name] {
+		s.switchMu.Unlock()
+		return
+	}
+	if s.switching == nil {
+		s.switching = map[string]bool{}
+	}
+	s.switching[profile.Name] = true
+	s.switchMu.Unlock()
+
+	s.switches.Add(1)
+	go func() {
+		defer s.switches.Done()
+		err := s.app.switchOverBudget(profile)
+		s.switchMu.Lock()
+		delete(s.switching, profile.Name)
+		s.switchMu.Unlock()
+
+		if err != nil {
+			s.app.logf(s.app.red, "❌ Failed to switch %s to %s: %v", profile.Name, profile.Budget.Fallback, err)
+		} else {
+			s.app.logf(s.app.yellow, "🔀 Switched from %s to %s", profile.Name, profile.Budget.Fallback)
+		}
+	}()
+}
+
+// budgetAction runs a hook on the budget goroutine, one at a time and in the
+// order they were queued. Without the goroutine, e.g. in tests, it runs at
+// once.
+func (s *proxyServer) budgetAction(action func()) {
+	if s.actions == nil {
+		action()
+		return
+	}
+	s.actions <- action
+}
+
+// runBudgetActions runs queued budget actions until the proxy stops
+func (s *proxyServer) runBudgetActions() {
+	for action := range s.actions {
+		action()
+	}
+}
+
+// drainBudgetActions waits for the fallback switches and the actions queued
+// so far
+func (s *proxyServer) drainBudgetActions() {
+	s.switches.Wait()
+	if s.actions == nil {
+		return
+	}
+	done := make(chan struct{})
+	s.actions <- func() { close(done) }
+	<-done
+}
+
+// showBudgets prints the budgets of all profiles for status
+func (app *Application) showBudgets() {
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	var spend map[string]*budgetSpend
+	for _, profile := range profiles.Profiles {
+		if profile.Budget == nil {
+			continue
+		}
+		if spend == nil {
+			if spend, err = app.loadSpend(now); err != nil {
+				app.yellow.Printf("  ⚠️  Budgets: %v\n", err)
+				return
+			}
+			fmt.Println()
+		}
+		app.cyan.Printf("  💰 Budget of %s:\n", profile.Name)
+		app.printBudget(profile, app.budgetStatus(profile, spend, now), "     ")
+	}
+}
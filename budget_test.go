@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyBudgetBlocks(t *testing.T) {
+	app := newTestApp(t)
+	profiles := &ProfilesFile{Profiles: []*Profile{
+		{Name: "work", Provider: ProviderZAI, Budget: &Budget{DailyTokens: 100}},
+		{Name: "open", Provider: ProviderZAI},
+	}}
+	if err := app.saveProfiles(profiles); err != nil {
+		t.Fatal(err)
+	}
+
+	budgets, err := app.newBudgetTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	budgets.add(app, &UsageRecord{Time: time.Now(), Upstream: "profile:work", InputTokens: 80, OutputTokens: 20})
+	s := &proxyServer{app: app, budgets: budgets}
+
+	tests := []struct {
+		upstream string
+		blocked  bool
+	}{
+		{upstream: "profile:work", blocked: true},
+		{upstream: "profile:open"},
+		{upstream: ProviderZAI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.upstream, func(t *testing.T) {
+			routes := &proxyRoutes{upstream: &proxyUpstream{name: tt.upstream}}
+			body := []byte(`{"model":"claude-sonnet-4-5","max_tokens":1}`)
+			rec := httptest.NewRecorder()
+			upstream, _ := s.applyBudget(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(string(body))), routes, body)
+
+			if !tt.blocked {
+				if upstream == nil {
+					t.Fatalf("request blocked: %s", rec.Body)
+				}
+				return
+			}
+			if upstream != nil {
+				t.Fatal("request passed an exhausted budget")
+			}
+			if rec.Code != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+			}
+			var apiErr struct {
+				Error struct {
+					Type string `json:"type"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil || apiErr.Error.Type != "rate_limit_error" {
+				t.Errorf("error = %s, want a rate_limit_error", rec.Body)
+			}
+			if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry <= 0 || retry > 24*60*60+1 {
+				t.Errorf("Retry-After = %q, want the seconds until midnight", rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestApplyBudgetSwitches(t *testing.T) {
+	app := newTestApp(t)
+	profiles := &ProfilesFile{Active: "capped", Profiles: []*Profile{
+		{Name: "capped", Provider: ProviderZAI, Env: map[string]string{"ANTHROPIC_BASE_URL": "https://api.z.ai/api/anthropic", "ANTHROPIC_AUTH_TOKEN": "sk-capped"},
+			Budget: &Budget{DailyTokens: 100, Action: BudgetSwitch, Fallback: "spare"}},
+		{Name: "spare", Provider: ProviderZAI, Env: map[string]string{"ANTHROPIC_BASE_URL": "https://spare.example.com", "ANTHROPIC_AUTH_TOKEN": "sk-spare"}},
+	}}
+	if err := app.saveProfiles(profiles); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.saveProxyState(&ProxyState{Port: 8787, Upstream: "profile:capped", Token: "proxy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	budgets, err := app.newBudgetTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	budgets.add(app, &UsageRecord{Time: time.Now(), Upstream: "profile:capped", InputTokens: 100})
+	s := &proxyServer{app: app, budgets: budgets}
+
+	// Another process holds the settings lock; the request must not wait
+	lock, err := app.acquireLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := &proxyRoutes{upstream: &proxyUpstream{name: "profile:capped"}}
+	body := []byte(`{"model":"claude-sonnet-4-5","max_tokens":1}`)
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	upstream, _ := s.applyBudget(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(string(body))), routes, body)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("applyBudget() waited %s for the switch", elapsed)
+	}
+	if upstream == nil || upstream.name != "profile:spare" || upstream.base.Host != "spare.example.com" {
+		t.Fatalf("upstream = %+v, body %s; want the spare profile", upstream, rec.Body)
+	}
+
+	lock.release()
+	s.drainBudgetActions()
+	state, err := app.loadProxyState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Upstream != "profile:spare" {
+		t.Errorf("proxy upstream = %q, want profile:spare", state.Upstream)
+	}
+	profiles, err = app.loadProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profiles.Active != "spare" {
+		t.Errorf("active profile = %q, want spare", profiles.Active)
+	}
+}
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Exit codes
+const (
+	ExitOK            = 0
+	ExitError         = 1
+	ExitUsage         = 2
+	ExitAlreadyActive = 3
+	ExitNoBackup      = 4
+	ExitBadConfig     = 5
+	ExitLocked        = 6
+	ExitConflict      = 7
+	ExitVerifyFailed  = 8
+)
+
+var (
+	// errAlreadyActive is returned when the requested provider or profile is
+	// already applied
+	errAlreadyActive = errors.New("already active")
+	// errNoBackup is returned when a required backup does not exist
+	errNoBackup = errors.New("backup not found")
+	// errBadConfig is returned when configuration files have problems
+	errBadConfig = errors.New("configuration problems found")
+)
+
+// usageError reports a malformed command line
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+// usageErrorf creates a usageError
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// configError reports a configuration file that cannot be parsed
+type configError struct {
+	file string
+	err  error
+}
+
+func (e *configError) Error() string { return fmt.Sprintf("failed to parse %s: %v", e.file, e.err) }
+func (e *configError) Unwrap() error { return e.err }
+
+// exitCode maps an error to the process exit code
+func exitCode(err error) int {
+	var usage *usageError
+	var config *configError
+	var status *exitStatusError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &status):
+		return status.code
+	case errors.As(err, &usage):
+		return ExitUsage
+	case errors.Is(err, errAlreadyActive):
+		return ExitAlreadyActive
+	case errors.Is(err, errNoBackup):
+		return ExitNoBackup
+	case errors.Is(err, errBadConfig), errors.As(err, &config):
+		return ExitBadConfig
+	case errors.Is(err, errLocked):
+		return ExitLocked
+	case errors.Is(err, errConflict):
+		return ExitConflict
+	case errors.Is(err, errVerifyFailed):
+		return ExitVerifyFailed
+	default:
+		return ExitError
+	}
+}
+
+// command is a claude-switch subcommand
+type command struct {
+	name    string
+	args    string // argument synopsis shown in help
+	summary string
+	help    string // detailed help, including subcommands and flags
+	locks   bool   // holds the settings lock while running
+	// unlocked reports subcommands of a locking command that run without the
+	// lock, such as long-running servers
+	unlocked func(args []string) bool
+	run      func(app *Application, args []string) error
+}
+
+// cliCommands returns all subcommands in the order shown in the help
+func cliCommands() []*command {
+	return []*command{
+		{
+			name:    "use",
+			args:    "<provider> [--scope user|project|local] [--verify]",
+			summary: "Switch to a provider",
+			help: `Switches settings.json to the provider, stashing the settings of the
+provider being left so they can be restored when switching back.
+
+Flags:
+  --scope user     ~/.claude/settings.json (default)
+  --scope project  .claude/settings.json of the current project
+  --scope local    .claude/settings.local.json of the current project
+  --verify         Test the new credentials and model tiers first; nothing is
+                   written if the endpoint rejects them (exit code 8)
+  --allow-project-token
+                   Store the token in the project scope, which is refused
+                   by default since .claude/settings.json is usually committed
+
+Project and local scopes only override the provider keys; switching them
+to anthropic removes the override.
+
+Providers: ` + strings.Join(providerNames(), ", ") + `, custom`,
+			locks: true,
+			run:   (*Application).runUseCommand,
+		},
+		{
+			name:    "status",
+			args:    "[--merged] [--json | --format <template> | --short]",
+			summary: "Show the current configuration",
+			help: `Flags:
+  --merged             Show the effective user+project+local configuration
+                       and which scope set each key
+  --json               Print the status as JSON
+  --format <template>  Format the status with a Go template, e.g. '{{.Provider}}'
+  --short              Print only the provider name`,
+			run: (*Application).runStatusCommand,
+		},
+		{
+			name:    "backup",
+			args:    "<list|show|restore|prune>",
+			summary: "Manage backup history",
+			help: `Commands:
+  list                 List backups, newest first
+  show <id>            Show a backup with tokens masked
+  restore <id>         Restore a backup (the current file is backed up first)
+  prune [--keep N]     Delete all but the newest N backups (default 10)`,
+			locks: true,
+			run:   (*Application).runBackupCommand,
+		},
+		{
+			name:    "token",
+			args:    "<set|show|clear> [provider]",
+			summary: "Manage saved API tokens",
+			help: `Commands:
+  set [provider]       Save a token in the secret store
+  show [provider]      Show where the token comes from (masked)
+  clear [provider]     Remove the saved token
+
+The provider defaults to z_ai.`,
+			locks: true,
+			run:   (*Application).runTokenCommand,
+		},
+		{
+			name:    "profile",
+			args:    "<list|add|remove|use|rename|show|store|budget>",
+			summary: "Manage named profiles",
+			help: `Commands:
+  list                              List profiles (* marks the active one)
+  add <name> [--provider <name>] [--secret-store <store>]
+                                    Snapshot the current settings or a provider template
+  remove <name>                     Delete a profile
+  use <name>                        Apply a profile
+  rename <old> <new>                Rename a profile
+  show <name>                       Show a profile with its token masked
+  store <name> <inline|` + strings.Join(secretStoreNames, "|") + `>
+                                    Move a profile's token to another store
+  budget <name> [flags]             Show or set the profile's spending budget, enforced while
+                                    the proxy forwards to the profile:
+    --daily-usd, --monthly-usd      Spending limits in USD (0 removes one)
+    --daily-tokens, --monthly-tokens
+                                    Token limits (0 removes one)
+    --action <block|downgrade|switch>
+                                    Once a limit is used up: reject requests with 429 (default),
+                                    send opus and sonnet requests to the haiku tier, or
+                                    switch to the --fallback profile
+    --fallback <profile>            Profile the switch action selects
+    --alerts <50,80,100>            Thresholds in percent that are logged and sent to the
+                                    "budget_alert" hook
+    --clear                         Remove the budget`,
+			locks: true,
+			run:   (*Application).runProfileCommand,
+		},
+		{
+			name:    "auto",
+			summary: "Apply the provider selected for the current directory",
+			help: `Looks for a ` + providerMarkerFile + ` file in the current directory and its
+parents, or a "directories" mapping in providers.json, and switches to the
+provider or profile it names. Does nothing when it is already active.
+
+Marker file contents:
+  z_ai            a provider name
+  profile:work    a profile name
+
+The shell hook added by install runs this command on every directory change.`,
+			locks: true,
+			run:   (*Application).runAutoCommand,
+		},
+		{
+			name:    "exec",
+			args:    "<profile|provider> -- <command> [args...]",
+			summary: "Run a command with a provider's env, leaving settings.json untouched",
+			help: `Starts the command with ANTHROPIC_BASE_URL, ANTHROPIC_AUTH_TOKEN, the model
+tiers and the other provider keys of the profile or provider in its
+environment. Provider variables inherited from the shell are replaced.
+When the command is claude and the settings set other provider keys, it
+also gets --settings with a temporary file overriding them. The exit status
+of the command is passed through and SIGTERM is forwarded to it.
+
+Example:
+  claude-switch exec work-zai -- claude`,
+			run: (*Application).runExecCommand,
+		},
+		{
+			name:    "test",
+			args:    "[--timeout <duration>] [profile|provider]",
+			summary: "Check that the endpoint accepts the token and knows the models",
+			help: `Sends a one-token Messages API request for every model tier to the
+ANTHROPIC_BASE_URL of the current settings, or of a profile or provider, and
+reports HTTP status, latency and the outcome:
+
+  ok               the model answered
+  auth_failed      the token was rejected (401/403)
+  model_not_found  the endpoint does not know the model
+  rate_limited     the token was accepted but the request was throttled
+  timeout          no answer within API_TIMEOUT_MS, at most 30s (or --timeout)
+  unreachable      the connection failed
+
+  --timeout    Timeout per request, e.g. 10s (default: API_TIMEOUT_MS, at most 30s)`,
+			run: (*Application).runTestCommand,
+		},
+		{
+			name:    "watch",
+			args:    "[--interval <d>] [--failures <n>] [--recover <n>] [--fallback <target>]",
+			summary: "Fail over to a fallback while the active provider is unhealthy",
+			help: `Probes the active provider or profile every interval, like 'test' with a
+single model tier. After --failures failed or rate-limited probes in a row it
+switches to the fallback, and after --recover healthy probes of the original
+it switches back. Each transition is logged with a timestamp. Stop with Ctrl-C.
+
+The fallback is --fallback, or the "fallbacks" entry of providers.json:
+  "fallbacks": {"z_ai": "anthropic", "profile:work": "profile:backup"}
+
+Flags:
+  --interval   Time between probes (default 1m)
+  --failures   Failed probes before failing over (default 3)
+  --recover    Healthy probes before switching back (default 3)
+  --fallback   Provider or profile:<name> to fail over to
+  --timeout    Timeout per probe (default: API_TIMEOUT_MS, at most 30s)`,
+			run: (*Application).runWatchCommand,
+		},
+		{
+			name:    "proxy",
+			args:    "<enable|disable|serve|route|unroute>",
+			summary: "Forward Claude Code through a local proxy to switch without restarts",
+			help: `Runs a local Anthropic Messages API endpoint, including streaming, that
+forwards every request to the selected provider or profile with its token.
+Model names are mapped to the upstream's model tiers, and requests to
+providers with "protocol": "openai" are translated to Chat Completions.
+
+Commands:
+  enable [--port <port>]   Point settings.json at http://127.0.0.1:<port> (default ` + strconv.Itoa(defaultProxyPort) + `)
+                           with the active provider as upstream
+  serve [--port <port>]    Run the proxy until interrupted
+  disable                  Switch settings.json back to the upstream
+  route [<tier> <target>]  Send a model tier (opus, sonnet, haiku) to another
+                           provider or profile:<name>; lists routes without arguments
+  unroute <tier>           Send a model tier to the upstream again
+
+While the proxy is enabled, 'use' and 'profile use' only change the upstream;
+running Claude Code sessions pick it up with their next request.`,
+			locks:    true,
+			unlocked: func(args []string) bool { return len(args) > 0 && args[0] == "serve" },
+			run:      (*Application).runProxyCommand,
+		},
+		{
+			name:    "usage",
+			args:    "[--since <7d|12h|date>] [--by provider|upstream|model|project] [--json]",
+			summary: "Report tokens and estimated cost of requests through the proxy",
+			help: `The proxy records every Messages request with its provider, model, status,
+latency and input, output and cache tokens in ~/.claude/usage.jsonl, moved
+to usage-YYYY-MM.jsonl once the month is over. Costs are estimated from
+list prices per million tokens, which "prices" in providers.json overrides.
+
+Requests with an ` + projectHeader + ` header, e.g. set through
+ANTHROPIC_CUSTOM_HEADERS in a project's settings, are grouped by project.
+
+  --since   Report requests since a duration ago or a date (default 30d)
+  --by      Group by provider, upstream (provider or profile), model or
+            project (default provider)
+  --json    Print the report as JSON`,
+			run: (*Application).runUsageCommand,
+		},
+		{
+			name:    "install",
+			summary: "Install the binary and shell aliases",
+			run: func(app *Application, args []string) error {
+				if len(args) > 0 {
+					return usageErrorf("install takes no arguments")
+				}
+				return app.install()
+			},
+		},
+		{
+			name:    "doctor",
+			args:    "[--fix]",
+			summary: "Check the configuration setup for problems",
+			help: `Checks that the configuration files parse, that they are not readable by
+other users, that backups are readable and in the current format, that the
+token matches the active provider, that the base URL is https, that no temp
+files were left behind, and that the installed binary and shell aliases run
+this version. Each problem comes with a hint.
+
+  --fix    Repair permissions, legacy backups and leftover temp files`,
+			locks: true,
+			run:   (*Application).runDoctorCommand,
+		},
+		{
+			name:    "version",
+			summary: "Show version",
+			run: func(app *Application, args []string) error {
+				fmt.Printf("claude-switch v%s (%s/%s)\n", Version, runtime.GOOS, runtime.GOARCH)
+				return nil
+			},
+		},
+		{
+			name:    "help",
+			args:    "[command]",
+			summary: "Show help for a command",
+			run:     (*Application).runHelpCommand,
+		},
+	}
+}
+
+// findCommand returns the subcommand with the given name
+func findCommand(name string) *command {
+	for _, cmd := range cliCommands() {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// legacyFlags maps the flags of earlier versions to subcommands
+var legacyFlags = map[string][]string{
+	"a":           {"use", ProviderAnthropic},
+	"anthropic":   {"use", ProviderAnthropic},
+	"z":           {"use", ProviderZAI},
+	"z_ai":        {"use", ProviderZAI},
+	"use":         {"use"},
+	"s":           {"status"},
+	"status":      {"status"},
+	"clear-token": {"token", "clear", ProviderZAI},
+	"install":     {"install"},
+	"v":           {"version"},
+	"version":     {"version"},
+	"h":           {"help"},
+	"help":        {"help"},
+}
+
+// translateLegacyArgs rewrites a leading legacy flag such as --z_ai or
+// --use=name into the equivalent subcommand
+func translateLegacyArgs(args []string) []string {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "-") {
+		return args
+	}
+
+	name, value, hasValue := strings.Cut(strings.TrimLeft(args[0], "-"), "=")
+	translated, ok := legacyFlags[name]
+	if !ok {
+		return args
+	}
+
+	out := append([]string(nil), translated...)
+	if hasValue {
+		out = append(out, value)
+	}
+	return append(out, args[1:]...)
+}
+
+// parseGlobalFlags applies and removes the global flags. Arguments after
+// "--" are left untouched.
+func (app *Application) parseGlobalFlags(args []string) []string {
+	var rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		switch arg {
+		case "-q", "--quiet", "-quiet":
+			app.quiet = true
+		case "--no-color", "-no-color":
+			color.NoColor = true
+		case "--dry-run", "-dry-run":
+			app.dryRun = true
+		case "--confirm", "-confirm":
+			app.confirm = true
+		case "-y", "--yes", "-yes":
+			app.assumeYes = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest
+}
+
+// silence discards everything written to stdout from now on. Child
+// processes keep the original stdout.
+func (app *Application) silence() {
+	if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+	}
+	color.Output = io.Discard
+}
+
+// parseFlags parses subcommand flags, reporting bad flags as usage errors
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{msg: err.Error()}
+	}
+	return nil
+}
+
+// run executes the command line and returns the process exit code
+func (app *Application) run(args []string) int {
+	args = app.parseGlobalFlags(args)
+
+	if app.quiet {
+		app.silence()
+	}
+
+	providersErr := app.loadUserProviders()
+
+	if len(args) == 0 {
+		if providersErr != nil {
+			app.yellow.Fprintf(os.Stderr, "⚠️  Failed to load custom providers: %v\n", providersErr)
+		}
+		app.printUsage()
+		return ExitOK
+	}
+
+	args = translateLegacyArgs(args)
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		app.red.Fprintf(os.Stderr, "Error: unknown command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, "Run 'claude-switch help' for usage.")
+		return ExitUsage
+	}
+
+	// A broken providers.json would turn its providers into unknown ones;
+	// only doctor, which reports it, and help and version run without it
+	if providersErr != nil {
+		if cmd.name != "doctor" && cmd.name != "help" && cmd.name != "version" {
+			app.red.Fprintf(os.Stderr, "Error: %v\n", providersErr)
+			return exitCode(providersErr)
+		}
+		app.yellow.Fprintf(os.Stderr, "⚠️  Failed to load custom providers: %v\n", providersErr)
+	}
+
+	cmdArgs := args[1:]
+	if len(cmdArgs) > 0 && (cmdArgs[0] == "-h" || cmdArgs[0] == "--help") {
+		app.printCommandHelp(cmd)
+		return ExitOK
+	}
+
+	err := app.runLocked(cmd, cmdArgs)
+	var usage *usageError
+	var status *exitStatusError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &status):
+		// A child process exited with an error it has reported itself
+	case errors.Is(err, flag.ErrHelp):
+		app.printCommandHelp(cmd)
+		return ExitOK
+	case err == errAlreadyActive || err == errNoBackup:
+		// Bare sentinels have already been explained to the user
+	case errors.As(err, &usage):
+		app.red.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Run 'claude-switch help %s' for usage.\n", cmd.name)
+	default:
+		app.red.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	return exitCode(err)
+}
+
+// runLocked runs a command, holding the settings lock for commands that
+// read and write settings
+func (app *Application) runLocked(cmd *command, args []string) error {
+	if !cmd.locks || (cmd.unlocked != nil && cmd.unlocked(args)) {
+		if app.dryRun || app.confirm {
+			return usageErrorf("%s does not support --dry-run or --confirm", cmd.name)
+		}
+		return cmd.run(app, args)
+	}
+
+	// A dry run only reads, and must not create the lock file
+	if !app.dryRun {
+		lock, err := app.acquireLock()
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+	}
+
+	if os.Getenv("CLAUDE_SWITCH_CONFIRM") == "1" {
+		app.confirm = true
+	}
+
+	// Stage every write so it can be shown before anything touches disk.
+	// Commands may also start a plan themselves, e.g. use --verify.
+	if app.dryRun || app.confirm {
+		app.plan = &writePlan{dryRun: app.dryRun, confirm: app.confirm}
+	}
+	if app.dryRun {
+		app.cyan.Println("🧪 Dry run: no files will be changed")
+		fmt.Println()
+	}
+	err := cmd.run(app, args)
+	plan := app.plan
+	if plan == nil {
+		return err
+	}
+
+	// Commands reporting a missing backup or the problems doctor found have
+	// still completed their changes
+	if err != nil && err != errNoBackup && !errors.Is(err, errBadConfig) {
+		return err
+	}
+	if planErr := app.finishPlan(plan); planErr != nil {
+		return planErr
+	}
+	return err
+}
+
+// runHelpCommand prints the general usage or the help of one command
+func (app *Application) runHelpCommand(args []string) error {
+	switch len(args) {
+	case 0:
+		app.printUsage()
+		return nil
+	case 1:
+		cmd := findCommand(args[0])
+		if cmd == nil {
+			return usageErrorf("unknown command %q", args[0])
+		}
+		app.printCommandHelp(cmd)
+		return nil
+	default:
+		return usageErrorf("usage: claude-switch help [command]")
+	}
+}
+
+// printCommandHelp prints the help of a subcommand
+func (app *Application) printCommandHelp(cmd *command) {
+	app.cyan.Println("Usage:")
+	fmt.Printf("  claude-switch %s %s\n", cmd.name, cmd.args)
+	fmt.Println()
+	fmt.Println(cmd.summary)
+	if cmd.help != "" {
+		fmt.Println()
+		fmt.Println(cmd.help)
+	}
+}
+
+// runUseCommand switches to a provider
+func (app *Application) runUseCommand(args []string) error {
+	fs := flag.NewFlagSet("use", flag.ContinueOnError)
+	scope := fs.String("scope", ScopeUser, "Settings file to write: "+strings.Join(scopeNames, ", "))
+	verify := fs.Bool("verify", false, "Test the provider's credentials before saving")
+	allowProjectToken := fs.Bool("allow-project-token", false, "Allow storing the token in the project scope")
+	name, rest := splitNameArgs(args)
+	if err := parseFlags(fs, rest); err != nil {
+		return err
+	}
+	positional := fs.Args()
+	if name == "" && len(positional) > 0 {
+		name, positional = positional[0], positional[1:]
+	}
+	if name == "" || len(positional) > 0 {
+		return usageErrorf("usage: claude-switch use <provider> [--scope user|project|local] [--verify]")
+	}
+
+	provider, err := app.resolveProvider(name)
+	if err != nil {
+		return &usageError{msg: err.Error()}
+	}
+	if *verify {
+		// Stage the writes; finishPlan probes them before saving
+		if app.plan == nil {
+			app.plan = &writePlan{}
+		}
+		app.plan.verify, app.plan.verifyScope = provider, *scope
+	}
+	if *scope != ScopeUser {
+		return app.switchScopeTo(provider, *scope, *allowProjectToken)
+	}
+	return app.switchTo(provider)
+}
+
+// runTokenCommand dispatches the token subcommands
+func (app *Application) runTokenCommand(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return usageErrorf("usage: claude-switch token <set|show|clear> [provider]")
+	}
+
+	name := ProviderZAI
+	if len(args) == 2 {
+		name = args[1]
+	}
+	provider, ok := lookupProvider(name)
+	if !ok {
+		return usageErrorf("unknown provider %q (available: %s)", name, strings.Join(providerNames(), ", "))
+	}
+
+	switch args[0] {
+	case "set":
+		return app.setToken(provider)
+	case "show":
+		return app.showToken(provider)
+	case "clear":
+		return app.clearToken(provider)
+	default:
+		return usageErrorf("unknown token command %q (available: set, show, clear)", args[0])
+	}
+}
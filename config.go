@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Config represents the Claude configuration structure.
+//
+// Only the env block is modelled as typed data. Every other top-level key
+// (permissions, hooks, model, statusLine, ...) and any env value that is not
+// a string is kept verbatim, in its original order, so that switching
+// providers never drops settings this tool does not know about.
+type Config struct {
+	Env map[string]string `json:"env"`
+
+	keys     []string                   // top-level key order as read from disk
+	fields   map[string]json.RawMessage // raw top-level values, excluding env
+	envKeys  []string                   // env key order as read from disk
+	envExtra map[string]json.RawMessage // env values that are not strings
+	origin   *configOrigin              // file state at load, for conflict checks
+}
+
+// newConfig creates an empty configuration
+func newConfig() *Config {
+	return &Config{Env: make(map[string]string)}
+}
+
+// deleteEnv removes an env key regardless of its value type
+func (c *Config) deleteEnv(key string) {
+	delete(c.Env, key)
+	delete(c.envExtra, key)
+}
+
+// UnmarshalJSON decodes settings.json while remembering key order and
+// keeping unknown values untouched
+func (c *Config) UnmarshalJSON(data []byte) error {
+	keys, fields, err := decodeOrderedObject(data)
+	if err != nil {
+		return err
+	}
+
+	c.keys = keys
+	c.fields = fields
+	c.Env = make(map[string]string)
+	c.envKeys = nil
+	c.envExtra = nil
+
+	rawEnv, ok := fields["env"]
+	delete(c.fields, "env")
+	if !ok || string(bytes.TrimSpace(rawEnv)) == "null" {
+		return nil
+	}
+
+	envKeys, envValues, err := decodeOrderedObject(rawEnv)
+	if err != nil {
+		return fmt.Errorf("invalid env block: %w", err)
+	}
+
+	c.envKeys = envKeys
+	for _, key := range envKeys {
+		var value string
+		if json.Unmarshal(envValues[key], &value) == nil {
+			c.Env[key] = value
+			continue
+		}
+		if c.envExtra == nil {
+			c.envExtra = make(map[string]json.RawMessage)
+		}
+		c.envExtra[key] = envValues[key]
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes the configuration, writing keys back in their original
+// order and appending new env keys in sorted order
+func (c Config) MarshalJSON() ([]byte, error) {
+	env, err := c.marshalEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	wroteEnv := false
+	first := true
+	writeField := func(key string, value []byte) {
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	for _, key := range c.keys {
+		if key == "env" {
+			writeField(key, env)
+			wroteEnv = true
+			continue
+		}
+		if value, ok := c.fields[key]; ok {
+			writeField(key, value)
+		}
+	}
+	if !wroteEnv {
+		writeField("env", env)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshalEnv encodes the env block in its original key order
+func (c *Config) marshalEnv() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	seen := make(map[string]bool, len(c.envKeys))
+	first := true
+	writeEntry := func(key string) error {
+		var value []byte
+		if s, ok := c.Env[key]; ok {
+			encoded, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			value = encoded
+		} else if raw, ok := c.envExtra[key]; ok {
+			value = raw
+		} else {
+			return nil
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+		return nil
+	}
+
+	for _, key := range c.envKeys {
+		seen[key] = true
+		if err := writeEntry(key); err != nil {
+			return nil, err
+		}
+	}
+
+	var added []string
+	for key := range c.Env {
+		if !seen[key] {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+	for _, key := range added {
+		if err := writeEntry(key); err != nil {
+			return nil, err
+		}
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodeOrderedObject decodes a JSON object into its key order and raw values
+func decodeOrderedObject(data []byte) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, nil, fmt.Errorf("expected JSON object")
+	}
+
+	var keys []string
+	values := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("expected object key")
+		}
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+
+		if _, dup := values[key]; !dup {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+
+	return keys, values, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		edit  func(c *Config)
+		want  string
+	}{
+		{
+			name:  "unchanged",
+			input: `{"permissions":{"allow":["Bash(ls:*)"]},"env":{"B":"2","A":"1"},"model":"opus"}`,
+			want:  `{"permissions":{"allow":["Bash(ls:*)"]},"env":{"B":"2","A":"1"},"model":"opus"}`,
+		},
+		{
+			name:  "non-string env values are kept",
+			input: `{"env":{"DEBUG":true,"LIMIT":3,"NAME":"x"}}`,
+			edit:  func(c *Config) { c.Env["NAME"] = "y" },
+			want:  `{"env":{"DEBUG":true,"LIMIT":3,"NAME":"y"}}`,
+		},
+		{
+			name:  "new env keys are appended sorted",
+			input: `{"env":{"Z":"1"},"hooks":{}}`,
+			edit: func(c *Config) {
+				c.Env["B"] = "2"
+				c.Env["A"] = "3"
+			},
+			want: `{"env":{"Z":"1","A":"3","B":"2"},"hooks":{}}`,
+		},
+		{
+			name:  "deleted env keys are dropped",
+			input: `{"env":{"ANTHROPIC_BASE_URL":"https://api.z.ai/api/anthropic","DEBUG":false,"KEEP":"1"}}`,
+			edit: func(c *Config) {
+				c.deleteEnv("ANTHROPIC_BASE_URL")
+				c.deleteEnv("DEBUG")
+			},
+			want: `{"env":{"KEEP":"1"}}`,
+		},
+		{
+			name:  "missing env is added last",
+			input: `{"model":"sonnet","statusLine":{"type":"command"}}`,
+			edit:  func(c *Config) { c.Env["A"] = "1" },
+			want:  `{"model":"sonnet","statusLine":{"type":"command"},"env":{"A":"1"}}`,
+		},
+		{
+			name:  "null env",
+			input: `{"env":null,"model":"opus"}`,
+			want:  `{"env":{},"model":"opus"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			if err := json.Unmarshal([]byte(tt.input), &config); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if tt.edit != nil {
+				tt.edit(&config)
+			}
+
+			got, err := json.Marshal(config)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("round trip =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "not an object", input: `["env"]`},
+		{name: "env not an object", input: `{"env":"x"}`},
+		{name: "truncated", input: `{"env":{"A":"1"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			if err := json.Unmarshal([]byte(tt.input), &config); err == nil {
+				t.Errorf("Unmarshal(%s) error = nil, want an error", tt.input)
+			}
+		})
+	}
+}
+
+func TestSaveConfigKeepsUnknownSettings(t *testing.T) {
+	app := newTestApp(t)
+	original := `{
+  "permissions": {"deny": ["Read(.env)"]},
+  "env": {"ANTHROPIC_AUTH_TOKEN": "sk-old", "DISABLE_TELEMETRY": 1},
+  "model": "opus"
+}`
+	writeTestFile(t, app.settingsFile, original)
+
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Env["ANTHROPIC_AUTH_TOKEN"] = "sk-new"
+	if err := app.saveConfigAtomic(app.settingsFile, config); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := app.readFile(app.settingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want bytes.Buffer
+	if err := json.Compact(&got, saved); err != nil {
+		t.Fatal(err)
+	}
+	want.WriteString(`{"permissions":{"deny":["Read(.env)"]},"env":{"ANTHROPIC_AUTH_TOKEN":"sk-new","DISABLE_TELEMETRY":1},"model":"opus"}`)
+	if got.String() != want.String() {
+		t.Errorf("saved settings =\n%s\nwant\n%s", got.String(), want.String())
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// installPath is where install puts the binary
+const installPath = "/usr/local/bin/claude-switch"
+
+// Outcomes of a doctor check
+const (
+	checkPass = iota
+	checkWarn
+	checkFail
+)
+
+// doctorCheck is the result of one doctor check
+type doctorCheck struct {
+	name    string
+	status  int
+	detail  string
+	hint    string       // how to fix the problem by hand
+	fixDesc string       // what --fix does
+	fix     func() error // applied by --fix through the write plan, nil when there is no automatic fix
+}
+
+// runDoctorCommand checks the configuration and optionally repairs it
+func (app *Application) runDoctorCommand(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "Repair the problems that can be fixed automatically")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("doctor takes no arguments")
+	}
+
+	app.cyan.Println("🩺 Checking configuration")
+	fmt.Println()
+
+	failures, warnings, fixed := 0, 0, 0
+	for _, check := range app.doctorChecks() {
+		switch check.status {
+		case checkPass:
+			if check.detail != "" {
+				app.green.Printf("  ✅ %s: %s\n", check.name, check.detail)
+			} else {
+				app.green.Printf("  ✅ %s\n", check.name)
+			}
+			continue
+		case checkWarn:
+			app.yellow.Printf("  ⚠️  %s: %s\n", check.name, check.detail)
+		case checkFail:
+			app.red.Printf("  ❌ %s: %s\n", check.name, check.detail)
+		}
+
+		if *fix && check.fix != nil {
+			if err := check.fix(); err != nil {
+				app.red.Printf("     Failed to fix: %v\n", err)
+			} else {
+				app.green.Printf("     🔧 %s\n", check.fixDesc)
+				fixed++
+				continue
+			}
+		}
+
+		if check.hint != "" {
+			fmt.Printf("     💡 %s\n", check.hint)
+		}
+		if check.fix != nil && !*fix {
+			fmt.Println("     💡 Run 'claude-switch doctor --fix' to repair automatically")
+		}
+		if check.status == checkFail {
+			failures++
+		} else {
+			warnings++
+		}
+	}
+
+	fmt.Println()
+	if fixed > 0 {
+		app.green.Printf("🔧 Fixed %d problem(s)\n", fixed)
+	}
+	if failures > 0 {
+		return fmt.Errorf("%w (%d problem(s), %d warning(s))", errBadConfig, failures, warnings)
+	}
+	if warnings > 0 {
+		app.yellow.Printf("⚠️  %d warning(s)\n", warnings)
+		return nil
+	}
+	app.green.Println("✅ No problems found")
+	return nil
+}
+
+// doctorChecks runs every check
+func (app *Application) doctorChecks() []*doctorCheck {
+	var checks []*doctorCheck
+
+	config, err := app.loadConfig(app.settingsFile)
+	checks = append(checks, parseCheck("settings.json", err,
+		"Fix the JSON by hand or restore it with 'claude-switch backup restore'"))
+	checks = append(checks, parseCheck("providers.json", app.loadUserProviders(),
+		"Fix the JSON by hand; see docs/API.md for the format"))
+	_, err = app.loadProfiles()
+	checks = append(checks, parseCheck("profiles.json", err, "Fix the JSON by hand"))
+
+	checks = append(checks, app.permissionChecks()...)
+	checks = append(checks, app.backupChecks(config)...)
+	if config != nil {
+		checks = append(checks, app.tokenTypeCheck(config)...)
+		checks = append(checks, baseURLCheck(config.Env["ANTHROPIC_BASE_URL"]))
+	}
+	checks = append(checks, app.tempFileChecks()...)
+	checks = append(checks, app.installChecks()...)
+
+	return checks
+}
+
+// parseCheck reports whether a configuration file could be loaded
+func parseCheck(name string, err error, hint string) *doctorCheck {
+	if err != nil {
+		return &doctorCheck{name: name, status: checkFail, detail: err.Error(), hint: hint}
+	}
+	return &doctorCheck{name: name, status: checkPass}
+}
+
+// permissionChecks reports files readable by other users. Tokens live in
+// these files, so directories should be 0700 and files 0600.
+func (app *Application) permissionChecks() []*doctorCheck {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	want := map[string]os.FileMode{
+		app.configDir:                           0700,
+		app.backupDir:                           0700,
+		filepath.Join(app.configDir, "secrets"): 0700,
+		app.settingsFile:                        0600,
+		app.profilesFile:                        0600,
+		app.providersFile:                       0600,
+		app.vaultFile:                           0600,
+	}
+	backups, _ := filepath.Glob(app.backupFile + "*")
+	snapshots, _ := filepath.Glob(filepath.Join(app.backupDir, "*.json"))
+	secrets, _ := filepath.Glob(filepath.Join(app.configDir, "secrets", "*"))
+	for _, file := range append(append(backups, snapshots...), secrets...) {
+		want[file] = 0600
+	}
+	for _, name := range providerNames() {
+		if provider, ok := lookupProvider(name); ok {
+			if path := app.tokenFilePath(provider.TokenSource()); path != "" {
+				want[path] = 0600
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(want))
+	for path := range want {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	bad := 0
+	var checks []*doctorCheck
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		perm := want[path]
+		if info.Mode().Perm()&^perm == 0 {
+			continue
+		}
+		bad++
+		path := path
+		checks = append(checks, &doctorCheck{
+			name:    "Permissions",
+			status:  checkWarn,
+			detail:  fmt.Sprintf("%s is %04o, expected %04o", path, info.Mode().Perm(), perm),
+			hint:    fmt.Sprintf("chmod %o %s", perm, path),
+			fixDesc: fmt.Sprintf("chmod %o %s", perm, path),
+			fix: func() error {
+				return app.applyChange(fmt.Sprintf("chmod %o %s", perm, path), func() error { return os.Chmod(path, perm) })
+			},
+		})
+	}
+	if bad == 0 {
+		checks = append(checks, &doctorCheck{name: "Permissions", status: checkPass, detail: "files 0600, directories 0700"})
+	}
+	return checks
+}
+
+// backupChecks reports unreadable backups and backups in the legacy format
+// without metadata
+func (app *Application) backupChecks(config *Config) []*doctorCheck {
+	var checks []*doctorCheck
+
+	files, _ := filepath.Glob(app.backupFile + "*")
+	sort.Strings(files)
+	for _, file := range files {
+		if strings.HasSuffix(file, ".tmp") {
+			continue
+		}
+		name := "Backup " + filepath.Base(file)
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			checks = append(checks, &doctorCheck{name: name, status: checkFail, detail: err.Error(),
+				hint: "Check the file permissions"})
+			continue
+		}
+
+		var raw map[string]json.RawMessage
+		var backup BackupConfig
+		if json.Unmarshal(data, &raw) != nil || json.Unmarshal(data, &backup) != nil {
+			checks = append(checks, &doctorCheck{name: name, status: checkFail, detail: "not valid JSON",
+				hint: fmt.Sprintf("Remove %s; older copies are listed by 'claude-switch backup list'", file)})
+			continue
+		}
+
+		if _, ok := raw["_metadata"]; ok && backup.Metadata.Provider != "" {
+			checks = append(checks, &doctorCheck{name: name, status: checkPass,
+				detail: fmt.Sprintf("%s, created %s", app.providerLabel(backup.Metadata.Provider), backup.Metadata.CreatedAt)})
+			continue
+		}
+
+		// Legacy backups have no metadata and are always Anthropic
+		if file != app.backupFile {
+			checks = append(checks, &doctorCheck{name: name, status: checkWarn, detail: "no metadata; the backup is ignored",
+				hint: fmt.Sprintf("Remove %s", file)})
+			continue
+		}
+		created := time.Now()
+		if info, err := os.Stat(file); err == nil {
+			created = info.ModTime()
+		}
+		upgraded := BackupConfig{
+			Metadata: BackupMetadata{
+				Provider:  ProviderAnthropic,
+				CreatedAt: created.Format(time.RFC3339),
+				Version:   Version,
+			},
+			Env: backup.Env,
+		}
+		checks = append(checks, &doctorCheck{
+			name:    name,
+			status:  checkWarn,
+			detail:  "legacy format without metadata (Anthropic assumed)",
+			fixDesc: "Rewrote the backup in the current format",
+			fix: func() error {
+				out, err := json.MarshalIndent(upgraded, "", "  ")
+				if err != nil {
+					return err
+				}
+				return app.writeFile(file, out, 0600)
+			},
+		})
+	}
+
+	if config != nil && app.detectProvider(config) != ProviderAnthropic {
+		if ok, _, _ := app.readBackup(ProviderAnthropic); !ok {
+			checks = append(checks, &doctorCheck{name: "Anthropic backup", status: checkWarn,
+				detail: "missing; switching back will require a re-login",
+				hint:   "Run 'claude-switch use anthropic' and log in again; switching away then backs up the login"})
+		}
+	}
+
+	return checks
+}
+
+// tokenTypeCheck reports a token that does not look like the kind the
+// active provider uses
+func (app *Application) tokenTypeCheck(config *Config) []*doctorCheck {
+	provider, ok := lookupProvider(app.detectProvider(config))
+	if !ok {
+		return nil
+	}
+
+	name := provider.DisplayName() + " token"
+	token := config.Env["ANTHROPIC_AUTH_TOKEN"]
+	tokenType := detectTokenType(token)
+	hint := fmt.Sprintf("Save the right token with 'claude-switch token set %s' and switch again", provider.Name())
+
+	switch {
+	case provider.TokenSource().FromBackup && tokenType == TokenTypeZAI:
+		return []*doctorCheck{{name: name, status: checkWarn, detail: "looks like an API key, not a web login token",
+			hint: "Log in again with Anthropic"}}
+	case provider.TokenSource().FromBackup:
+		return []*doctorCheck{{name: name, status: checkPass}}
+	case token == "":
+		return []*doctorCheck{{name: name, status: checkFail, detail: "ANTHROPIC_AUTH_TOKEN is empty", hint: hint}}
+	case tokenType == TokenTypeAnthropic:
+		return []*doctorCheck{{name: name, status: checkWarn, detail: "looks like an Anthropic web login token, not an API key", hint: hint}}
+	default:
+		return []*doctorCheck{{name: name, status: checkPass, detail: fmt.Sprintf("%s (%s)", maskToken(token), tokenType)}}
+	}
+}
+
+// baseURLCheck reports a base URL that is not a well-formed https URL.
+// Plain http is accepted for loopback addresses such as a local proxy.
+func baseURLCheck(baseURL string) *doctorCheck {
+	const name = "Base URL"
+	if baseURL == "" {
+		return &doctorCheck{name: name, status: checkPass, detail: "not set (Anthropic default)"}
+	}
+
+	hint := "Set ANTHROPIC_BASE_URL to an https:// URL, e.g. by switching provider again"
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return &doctorCheck{name: name, status: checkFail, detail: err.Error(), hint: hint}
+	}
+	if u.Host == "" {
+		return &doctorCheck{name: name, status: checkFail, detail: fmt.Sprintf("%q has no host", baseURL), hint: hint}
+	}
+	switch u.Scheme {
+	case "https":
+		return &doctorCheck{name: name, status: checkPass, detail: baseURL}
+	case "http":
+		if ip := net.ParseIP(u.Hostname()); u.Hostname() == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return &doctorCheck{name: name, status: checkPass, detail: baseURL + " (local)"}
+		}
+		return &doctorCheck{name: name, status: checkFail, detail: fmt.Sprintf("%s sends the token unencrypted", baseURL), hint: hint}
+	default:
+		return &doctorCheck{name: name, status: checkFail, detail: fmt.Sprintf("%q is not an https URL", baseURL), hint: hint}
+	}
+}
+
+// tempFileChecks reports temp files left behind by interrupted writes. The
+// settings lock is held, so no other claude-switch is writing them.
+func (app *Application) tempFileChecks() []*doctorCheck {
+	var files []string
+	for _, dir := range []string{app.configDir, app.backupDir, filepath.Join(app.configDir, "secrets")} {
+		matches, _ := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return []*doctorCheck{{name: "Temp files", status: checkPass, detail: "none left behind"}}
+	}
+
+	var checks []*doctorCheck
+	for _, file := range files {
+		file := file
+		checks = append(checks, &doctorCheck{
+			name:    "Temp files",
+			status:  checkWarn,
+			detail:  fmt.Sprintf("%s was left behind by an interrupted write", file),
+			hint:    fmt.Sprintf("rm %s", file),
+			fixDesc: fmt.Sprintf("Removed %s", file),
+			fix:     func() error { return app.removeFile(file) },
+		})
+	}
+	return checks
+}
+
+// aliasPattern matches the claude-switch alias of bash, zsh and fish
+var aliasPattern = regexp.MustCompile(`(?m)^alias claude-switch[= ]'([^']+)'`)
+
+// installChecks reports an installed binary or shell alias that runs a
+// different version than this one
+func (app *Application) installChecks() []*doctorCheck {
+	var checks []*doctorCheck
+
+	if _, err := os.Stat(installPath); err != nil {
+		checks = append(checks, &doctorCheck{name: "Installed binary", status: checkWarn,
+			detail: fmt.Sprintf("%s not found", installPath), hint: "Run 'claude-switch install'"})
+	} else {
+		checks = append(checks, versionCheck("Installed binary", installPath))
+	}
+
+	for _, rc := range app.detectShellConfigs() {
+		content, err := os.ReadFile(rc)
+		if err != nil {
+			continue
+		}
+		match := aliasPattern.FindSubmatch(content)
+		if match == nil {
+			continue
+		}
+		check := versionCheck("Alias in "+rc, string(match[1]))
+		if check.status == checkPass && string(match[1]) != installPath {
+			check.status = checkWarn
+			check.detail = fmt.Sprintf("points at %s instead of %s", match[1], installPath)
+			check.hint = fmt.Sprintf("Remove the Claude Code API Switcher block from %s and run 'claude-switch install'", rc)
+		}
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+// versionCheck compares the version of another claude-switch binary with
+// this one
+func versionCheck(name, path string) *doctorCheck {
+	hint := "Run 'claude-switch install' with the binary you want to keep"
+	// Releases before subcommands only understand the flag form
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return &doctorCheck{name: name, status: checkFail, detail: fmt.Sprintf("%s does not run: %v", path, err), hint: hint}
+	}
+
+	fields := strings.Fields(string(out))
+	version := ""
+	if len(fields) >= 2 {
+		version = strings.TrimPrefix(fields[1], "v")
+	}
+	if version != Version {
+		return &doctorCheck{name: name, status: checkWarn,
+			detail: fmt.Sprintf("%s is version %q, this is %s", path, version, Version), hint: hint}
+	}
+	return &doctorCheck{name: name, status: checkPass, detail: fmt.Sprintf("%s (v%s)", path, version)}
+}
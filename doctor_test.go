@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVersionCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binaries are shell scripts")
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		want   int
+	}{
+		{
+			name:   "same version",
+			script: `[ "$1" = --version ] && echo "claude-switch v` + Version + ` (linux/amd64)"`,
+			want:   checkPass,
+		},
+		{
+			// 2.2.0 prints usage and exits 1 for a positional "version"
+			name:   "older release",
+			script: `[ "$1" = --version ] || { echo "Usage: claude-switch [options]" >&2; exit 1; }; echo "claude-switch v2.2.0 (linux/amd64)"`,
+			want:   checkWarn,
+		},
+		{
+			name:   "broken binary",
+			script: `exit 2`,
+			want:   checkFail,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "claude-switch")
+			writeTestFile(t, path, "#!/bin/sh\n"+tt.script+"\n")
+			if err := os.Chmod(path, 0700); err != nil {
+				t.Fatal(err)
+			}
+
+			if check := versionCheck("Installed binary", path); check.status != tt.want {
+				t.Errorf("versionCheck() status = %d (%s), want %d", check.status, check.detail, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoctorFix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not checked on Windows")
+	}
+
+	// setup leaves a settings file readable by others, a temp file of an
+	// interrupted write and a backup in the legacy format
+	setup := func(t *testing.T) (*Application, string) {
+		app := newTestApp(t)
+		writeTestFile(t, app.settingsFile, `{"model":"opus"}`)
+		if err := os.Chmod(app.settingsFile, 0644); err != nil {
+			t.Fatal(err)
+		}
+		tmp := filepath.Join(app.configDir, ".settings.json.tmp")
+		writeTestFile(t, tmp, `{"mod`)
+		writeTestFile(t, app.backupFile, `{"env":{"ANTHROPIC_AUTH_TOKEN":"sk-ant-oat01-login"}}`)
+		return app, tmp
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		app, _ := setup(t)
+		before := readTree(t, app.configDir)
+		app.run([]string{"--dry-run", "doctor", "--fix"})
+		if after := readTree(t, app.configDir); !reflect.DeepEqual(after, before) {
+			t.Errorf("dry run changed the files:\n%v\nwant\n%v", after, before)
+		}
+		if info, _ := os.Stat(app.settingsFile); info.Mode().Perm() != 0644 {
+			t.Errorf("dry run changed the permissions to %04o", info.Mode().Perm())
+		}
+	})
+
+	t.Run("fix", func(t *testing.T) {
+		app, tmp := setup(t)
+		app.run([]string{"doctor", "--fix"})
+
+		if info, err := os.Stat(app.settingsFile); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("settings.json permissions not fixed: %v %v", info, err)
+		}
+		if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+			t.Errorf("temp file not removed: %v", err)
+		}
+		if ok, backup, err := app.readBackup(ProviderAnthropic); !ok || err != nil || backup.Env["ANTHROPIC_AUTH_TOKEN"] != "sk-ant-oat01-login" {
+			t.Errorf("legacy backup not upgraded: ok %v, %+v, %v", ok, backup, err)
+		}
+		if data, _ := os.ReadFile(app.backupFile); !strings.Contains(string(data), `"_metadata"`) {
+			t.Errorf("legacy backup rewritten without metadata:\n%s", data)
+		}
+
+		// Nothing left that --fix could repair
+		for _, check := range app.doctorChecks() {
+			if check.fix != nil {
+				t.Errorf("%s still fixable after --fix: %s", check.name, check.detail)
+			}
+		}
+	})
+}
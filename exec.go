@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// exitStatusError carries the exit status of a child process
+type exitStatusError struct {
+	code int
+}
+
+func (e *exitStatusError) Error() string { return fmt.Sprintf("exit status %d", e.code) }
+
+// runExecCommand runs a command with a profile's or provider's env injected
+// into its environment, leaving settings.json untouched
+func (app *Application) runExecCommand(args []string) error {
+	name, command := splitCommandArgs(args)
+	if name == "" || len(command) == 0 {
+		return usageErrorf("usage: claude-switch exec <profile|provider> -- <command> [args...]")
+	}
+
+	// Keep stdout for the child; token messages would mix with its output
+	app.silence()
+
+	env, label, err := app.execEnv(name, false)
+	if err != nil {
+		return err
+	}
+	if provider, ok := lookupProvider(app.detectProvider(&Config{Env: env})); ok {
+		if err := requireAnthropicProtocol(provider); err != nil {
+			return err
+		}
+	}
+
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return err
+	}
+
+	// Claude Code prefers the env block of its settings over the process
+	// environment; a settings file passed to it takes precedence again
+	commandArgs := command[1:]
+	overrides, err := app.settingsEnvOverrides(env)
+	if err != nil {
+		return err
+	}
+	if len(overrides) > 0 {
+		if isClaudeCommand(command[0]) {
+			settings, err := app.writeExecSettings(overrides)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(settings)
+			commandArgs = append([]string{"--settings", settings}, commandArgs...)
+		} else if !app.quiet {
+			keys := make([]string, 0, len(overrides))
+			for key := range overrides {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			app.yellow.Fprintf(os.Stderr, "⚠️  The settings set %s; Claude Code started by %s will use them instead\n", strings.Join(keys, ", "), command[0])
+		}
+	}
+
+	cmd := exec.Command(path, commandArgs...)
+	cmd.Env = mergeProcessEnv(os.Environ(), env)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = app.stdout
+	cmd.Stderr = os.Stderr
+
+	if !app.quiet {
+		app.cyan.Fprintf(os.Stderr, "🚀 Running %s with %s\n", command[0], label)
+	}
+
+	// The child shares the terminal and receives Ctrl-C itself
+	signal.Ignore(os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run %s: %w", command[0], err)
+	}
+
+	// Pass termination on instead of leaving the child behind
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &exitStatusError{code: exitErr.ExitCode()}
+		}
+		return fmt.Errorf("failed to run %s: %w", command[0], err)
+	}
+	return nil
+}
+
+// execEnv returns the provider env of a profile or provider name, and a
+// label describing it. Quiet lookups print nothing and fail instead of
+// asking for a missing token, so that they can run next to other goroutines.
+func (app *Application) execEnv(name string, quiet bool) (map[string]string, string, error) {
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return nil, "", err
+	}
+	if profile := profiles.find(name); profile != nil {
+		env, err := app.profileEnv(profile)
+		if err != nil {
+			return nil, "", err
+		}
+		return env, fmt.Sprintf("profile %q (%s)", profile.Name, app.providerLabel(profile.Provider)), nil
+	}
+
+	provider, err := app.resolveProvider(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("no profile or provider named %q", name)
+	}
+
+	if provider.TokenSource().FromBackup {
+		env := map[string]string{}
+		hasBackup, backup, err := app.hasValidBackup(provider.Name())
+		if err != nil {
+			return nil, "", err
+		}
+		if hasBackup && backup != nil {
+			for key, value := range backup.Env {
+				if ownsEnvKey(provider, key) {
+					env[key] = value
+				}
+			}
+		}
+		return env, provider.DisplayName(), nil
+	}
+
+	env := provider.DefaultEnv()
+	if hasBackup, backup, err := app.hasValidBackup(provider.Name()); err == nil && hasBackup && backup != nil {
+		for key, value := range backup.Env {
+			env[key] = value
+		}
+	}
+
+	token := env["ANTHROPIC_AUTH_TOKEN"]
+	if source := provider.TokenSource(); token == "" || source.Command != "" || source.EnvFile != "" {
+		if quiet {
+			token, err = app.lookupToken(provider, true)
+			if err == nil && token == "" {
+				err = fmt.Errorf("no %s token available; save one with 'claude-switch token set %s'", provider.DisplayName(), provider.Name())
+			}
+		} else {
+			token, err = app.promptForToken(provider)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	env["ANTHROPIC_AUTH_TOKEN"] = token
+
+	return env, provider.DisplayName(), nil
+}
+
+// settingsEnvOverrides returns the provider keys the env blocks of the
+// user, project and local settings set, with their values in env. Keys env
+// lacks are cleared.
+func (app *Application) settingsEnvOverrides(env map[string]string) (map[string]string, error) {
+	settingsEnv, err := app.effectiveEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := map[string]string{}
+	for key, value := range settingsEnv {
+		want, ok := env[key]
+		if !ok && !isProviderKey(key) {
+			continue
+		}
+		if key == "ANTHROPIC_CUSTOM_HEADERS" {
+			want = withProjectHeader(want, value)
+		}
+		if want != value {
+			overrides[key] = want
+		}
+	}
+	return overrides, nil
+}
+
+// writeExecSettings writes a settings file holding only an env block for
+// Claude Code's --settings flag. It holds the token, so it is private to the
+// user and removed once the command exits.
+func (app *Application) writeExecSettings(env map[string]string) (string, error) {
+	data, err := json.MarshalIndent(map[string]interface{}{"env": env}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(app.configDir, "exec-settings-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to create settings for exec: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write settings for exec: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// isClaudeCommand reports whether a command starts Claude Code
+func isClaudeCommand(command string) bool {
+	return strings.TrimSuffix(filepath.Base(command), ".exe") == "claude"
+}
+
+// mergeProcessEnv replaces every provider-owned variable of a process
+// environment with the given env
+func mergeProcessEnv(environ []string, env map[string]string) []string {
+	merged := make([]string, 0, len(environ)+len(env))
+	for _, entry := range environ {
+		key, _, _ := strings.Cut(entry, "=")
+		if isProviderKey(key) {
+			continue
+		}
+		if _, ok := env[key]; ok {
+			continue
+		}
+		merged = append(merged, entry)
+	}
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		merged = append(merged, key+"="+env[key])
+	}
+	return merged
+}
+
+// splitCommandArgs separates the leading name from the command after "--".
+// The "--" may be omitted when the command does not start with a dash.
+func splitCommandArgs(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	name, rest := args[0], args[1:]
+	if len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+	return name, rest
+}
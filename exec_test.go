@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSettingsEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		env      map[string]string
+		want     map[string]string
+	}{
+		{
+			name:     "no env block",
+			settings: `{"model":"opus"}`,
+			env:      map[string]string{"ANTHROPIC_BASE_URL": "https://api.z.ai/api/anthropic", "ANTHROPIC_AUTH_TOKEN": "sk-zai"},
+			want:     map[string]string{},
+		},
+		{
+			name:     "same provider",
+			settings: `{"env":{"ANTHROPIC_BASE_URL":"https://api.z.ai/api/anthropic","ANTHROPIC_AUTH_TOKEN":"sk-zai","DEBUG":"1"}}`,
+			env:      map[string]string{"ANTHROPIC_BASE_URL": "https://api.z.ai/api/anthropic", "ANTHROPIC_AUTH_TOKEN": "sk-zai"},
+			want:     map[string]string{},
+		},
+		{
+			name:     "other provider keys are replaced or cleared",
+			settings: `{"env":{"ANTHROPIC_BASE_URL":"https://api.z.ai/api/anthropic","ANTHROPIC_AUTH_TOKEN":"sk-zai","API_TIMEOUT_MS":"3000000","DEBUG":"1"}}`,
+			env:      map[string]string{"ANTHROPIC_AUTH_TOKEN": "sk-ant"},
+			want:     map[string]string{"ANTHROPIC_BASE_URL": "", "ANTHROPIC_AUTH_TOKEN": "sk-ant", "API_TIMEOUT_MS": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			writeTestFile(t, app.settingsFile, tt.settings)
+
+			got, err := app.settingsEnvOverrides(tt.env)
+			if err != nil {
+				t.Fatalf("settingsEnvOverrides() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("settingsEnvOverrides() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsClaudeCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"claude", true},
+		{"/usr/local/bin/claude", true},
+		{"claude.exe", true},
+		{"claude-switch", false},
+		{"sh", false},
+	}
+
+	for _, tt := range tests {
+		if got := isClaudeCommand(tt.command); got != tt.want {
+			t.Errorf("isClaudeCommand(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
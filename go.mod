@@ -2,10 +2,15 @@ module github.com/softkr/claude-provider
 
 go 1.21
 
-require github.com/fatih/color v1.16.0
+require (
+	filippo.io/age v1.0.0
+	github.com/fatih/color v1.16.0
+	golang.org/x/sys v0.16.0
+	golang.org/x/term v0.16.0
+)
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/crypto v0.18.0 // indirect
 )
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLockTimeout is how long to wait for another claude-switch process
+const defaultLockTimeout = 10 * time.Second
+
+var (
+	// errLocked is returned when another process holds the settings lock
+	errLocked = errors.New("settings are locked by another claude-switch process")
+	// errLockBusy is returned by tryLockFile when the lock is held
+	errLockBusy = errors.New("lock is busy")
+)
+
+// settingsLock is an advisory lock serializing the read-modify-write cycles
+// of claude-switch processes
+type settingsLock struct {
+	file *os.File
+}
+
+// lockTimeout returns the lock timeout from CLAUDE_SWITCH_LOCK_TIMEOUT
+func lockTimeout() (time.Duration, error) {
+	value := os.Getenv("CLAUDE_SWITCH_LOCK_TIMEOUT")
+	if value == "" {
+		return defaultLockTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid CLAUDE_SWITCH_LOCK_TIMEOUT %q (e.g. 30s)", value)
+	}
+	return timeout, nil
+}
+
+// acquireLock takes the settings lock, waiting up to the lock timeout
+func (app *Application) acquireLock() (*settingsLock, error) {
+	timeout, err := lockTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(app.configDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	path := filepath.Join(app.configDir, ".claude-switch.lock")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tryLockFile(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLockBusy) {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if !time.Now().Before(deadline) {
+			holder := "unknown pid"
+			if data, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+				holder = "pid " + strings.TrimSpace(string(data))
+			}
+			f.Close()
+			return nil, fmt.Errorf("%w (%s); gave up after %s", errLocked, holder, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Record the holder for the error message of waiting processes
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &settingsLock{file: f}, nil
+}
+
+// release gives up the settings lock
+func (l *settingsLock) release() {
+	l.file.Truncate(0)
+	unlockFile(l.file)
+	l.file.Close()
+}
+
+// writeFileAtomic writes data to a uniquely named temp file in the target
+// directory, syncs it and renames it over the target, then syncs the
+// directory so the rename survives a crash
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempFile := f.Name()
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if err := os.Rename(tempFile, filename); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquireLockContention(t *testing.T) {
+	app := newTestApp(t)
+	t.Setenv("CLAUDE_SWITCH_LOCK_TIMEOUT", "300ms")
+
+	held, err := app.acquireLock()
+	if err != nil {
+		t.Fatalf("acquireLock() error = %v", err)
+	}
+
+	start := time.Now()
+	_, err = app.acquireLock()
+	if !errors.Is(err, errLocked) {
+		t.Fatalf("acquireLock() while held error = %v, want errLocked", err)
+	}
+	if waited := time.Since(start); waited < 300*time.Millisecond {
+		t.Errorf("gave up after %s, want the 300ms timeout", waited)
+	}
+	if pid := "pid " + strconv.Itoa(os.Getpid()); !strings.Contains(err.Error(), pid) {
+		t.Errorf("error %q does not name the holder (%s)", err, pid)
+	}
+
+	// A waiting process gets the lock once it is released
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		held.release()
+	}()
+	lock, err := app.acquireLock()
+	if err != nil {
+		t.Fatalf("acquireLock() after release error = %v", err)
+	}
+	lock.release()
+}
+
+func TestAcquireLockSerializesWriters(t *testing.T) {
+	app := newTestApp(t)
+	counter := filepath.Join(app.configDir, "counter")
+	writeTestFile(t, counter, "0")
+
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := app.acquireLock()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer lock.release()
+
+			// A read-modify-write cycle that loses updates without the lock
+			data, err := os.ReadFile(counter)
+			if err != nil {
+				errs <- err
+				return
+			}
+			n, _ := strconv.Atoi(string(data))
+			time.Sleep(5 * time.Millisecond)
+			errs <- writeFileAtomic(counter, []byte(strconv.Itoa(n+1)), 0600)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != strconv.Itoa(writers) {
+		t.Errorf("counter = %s, want %d", got, writers)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: defaultLockTimeout},
+		{value: "30s", want: 30 * time.Second},
+		{value: "0", want: 0},
+		{value: "-1s", wantErr: true},
+		{value: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("CLAUDE_SWITCH_LOCK_TIMEOUT", tt.value)
+			got, err := lockTimeout()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lockTimeout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("lockTimeout() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
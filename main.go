@@ -3,12 +3,11 @@ package main
 import (
 	"bufio"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
@@ -16,7 +15,7 @@ import (
 )
 
 const (
-	Version = "2.2.0"
+	Version = "2.3.0"
 )
 
 // Provider types
@@ -27,16 +26,16 @@ const (
 	ProviderUnknown   = "unknown"
 )
 
-// Config represents the Claude configuration structure
-type Config struct {
-	Env map[string]string `json:"env"`
-}
-
 // BackupMetadata stores information about the backup
 type BackupMetadata struct {
-	Provider  string `json:"provider"`
-	CreatedAt string `json:"created_at"`
-	Version   string `json:"version"`
+	ID          string `json:"id,omitempty"`
+	Provider    string `json:"provider"`
+	CreatedAt   string `json:"created_at"`
+	Version     string `json:"version"`
+	File        string `json:"file,omitempty"`
+	SecretStore string `json:"secret_store,omitempty"`
+	// Secrets maps env keys kept out of a snapshot to their secret store key
+	Secrets map[string]string `json:"secrets,omitempty"`
 }
 
 // BackupConfig represents the backup file structure with metadata
@@ -47,22 +46,31 @@ type BackupConfig struct {
 
 // Application holds the application state
 type Application struct {
-	settingsFile string
-	backupFile   string
-	configDir    string
-	green        *color.Color
-	yellow       *color.Color
-	cyan         *color.Color
-	red          *color.Color
-}
-
-// Z.AI specific environment keys (excluding ANTHROPIC_AUTH_TOKEN which is shared)
-var z_aiEnvKeys = []string{
-	"ANTHROPIC_BASE_URL",
-	"API_TIMEOUT_MS",
-	"ANTHROPIC_DEFAULT_OPUS_MODEL",
-	"ANTHROPIC_DEFAULT_SONNET_MODEL",
-	"ANTHROPIC_DEFAULT_HAIKU_MODEL",
+	settingsFile   string
+	backupFile     string
+	backupDir      string
+	providersFile  string
+	profilesFile   string
+	vaultFile      string
+	proxyFile      string
+	usageFile      string
+	prices         map[string]map[string]ModelPrice
+	hooks          map[string]string
+	configDir      string
+	passphrase     string
+	directories    map[string]string
+	fallbacks      map[string]string
+	quiet          bool
+	nonInteractive bool
+	dryRun         bool
+	confirm        bool
+	assumeYes      bool
+	plan           *writePlan
+	stdout         *os.File
+	green          *color.Color
+	yellow         *color.Color
+	cyan           *color.Color
+	red            *color.Color
 }
 
 // NewApplication creates a new application instance
@@ -76,13 +84,20 @@ func NewApplication() *Application {
 	configDir := filepath.Join(homeDir, ".claude")
 
 	return &Application{
-		settingsFile: filepath.Join(configDir, "settings.json"),
-		backupFile:   filepath.Join(configDir, "settings.json.backup"),
-		configDir:    configDir,
-		green:        color.New(color.FgGreen),
-		yellow:       color.New(color.FgYellow),
-		cyan:         color.New(color.FgCyan),
-		red:          color.New(color.FgRed),
+		settingsFile:  filepath.Join(configDir, "settings.json"),
+		backupFile:    filepath.Join(configDir, "settings.json.backup"),
+		backupDir:     filepath.Join(configDir, "backups"),
+		providersFile: filepath.Join(configDir, "providers.json"),
+		profilesFile:  filepath.Join(configDir, "profiles.json"),
+		vaultFile:     filepath.Join(configDir, "secrets.age"),
+		proxyFile:     filepath.Join(configDir, "proxy.json"),
+		usageFile:     filepath.Join(configDir, "usage.jsonl"),
+		configDir:     configDir,
+		stdout:        os.Stdout,
+		green:         color.New(color.FgGreen),
+		yellow:        color.New(color.FgYellow),
+		cyan:          color.New(color.FgCyan),
+		red:           color.New(color.FgRed),
 	}
 }
 
@@ -94,10 +109,12 @@ func (app *Application) printHeader() {
 
 // loadConfig loads configuration from file
 func (app *Application) loadConfig(filename string) (*Config, error) {
-	data, err := os.ReadFile(filename)
+	data, err := app.readFile(filename)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &Config{Env: make(map[string]string)}, nil
+			config := newConfig()
+			config.origin = newConfigOrigin(nil, false, nil)
+			return config, nil
 		}
 		return nil, err
 	}
@@ -105,72 +122,80 @@ func (app *Application) loadConfig(filename string) (*Config, error) {
 	var config Config
 	err = json.Unmarshal(data, &config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse config: %w", err)
+		return nil, &configError{file: filename, err: err}
 	}
 
 	if config.Env == nil {
 		config.Env = make(map[string]string)
 	}
+	config.origin = newConfigOrigin(data, true, config.Env)
 
 	return &config, nil
 }
 
 // saveConfigAtomic saves configuration to file atomically
 func (app *Application) saveConfigAtomic(filename string, config *Config) error {
-	// Ensure directory exists
-	dir := filepath.Dir(filename)
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+	// Snapshot the current file so every write can be undone. This may talk
+	// to a secret store, so it runs before the on-disk check below.
+	if _, err := app.takeSnapshot(filename); err != nil {
+		// A file broken since it was loaded is reported as a conflict
+		if _, conflict := app.rebaseOnDisk(filename, config); conflict != nil {
+			return conflict
+		}
+		return fmt.Errorf("failed to snapshot config: %w", err)
 	}
 
-	data, err := json.MarshalIndent(config, "", "  ")
+	// Keep edits made on disk since the config was loaded
+	disk, err := app.rebaseOnDisk(filename, config)
 	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+		return err
 	}
 
-	// Write to temp file first
-	tempFile := filename + ".tmp"
-	err = os.WriteFile(tempFile, data, 0600)
+	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to write temp config: %w", err)
+		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	// Atomic rename
-	err = os.Rename(tempFile, filename)
-	if err != nil {
-		os.Remove(tempFile) // Clean up temp file on error
+	// Write to a temp file, then rename it over the settings, unless the
+	// file changed once more after the rebase
+	if err := app.checkOnDisk(filename, disk); err != nil {
+		return err
+	}
+	if err := app.writeFile(filename, data, 0600); err != nil {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
+	config.origin = newConfigOrigin(data, true, config.Env)
 
 	return nil
 }
 
-// promptForToken prompts user for API token
-func (app *Application) promptForToken() (string, error) {
-	// Check environment variable first
-	if token := os.Getenv("Z_AI_AUTH_TOKEN"); token != "" {
-		app.cyan.Println("📌 Using token from Z_AI_AUTH_TOKEN environment variable")
-		return token, nil
+// promptForToken resolves the API token for a provider, asking the user if
+// no token is available from its environment variable or token file
+func (app *Application) promptForToken(provider Provider) (string, error) {
+	token, err := app.lookupToken(provider, false)
+	if token != "" || err != nil {
+		return token, err
 	}
 
-	// Check if token file exists
-	tokenFile := filepath.Join(app.configDir, ".z_ai_token")
-	if data, err := os.ReadFile(tokenFile); err == nil {
-		token := strings.TrimSpace(string(data))
-		if token != "" {
-			app.cyan.Println("📌 Using token from saved token file")
-			return token, nil
-		}
+	secretKey := "provider/" + provider.Name()
+	stores, err := app.tokenStores()
+	if err != nil {
+		return "", err
 	}
+	store := stores[0]
 
-	// Prompt user for token
-	app.yellow.Println("⚠️  No API token found")
-	fmt.Println()
-	app.cyan.Println("Please enter your Z.AI API token:")
-	fmt.Print("> ")
+	if app.nonInteractive {
+		return "", fmt.Errorf("no %s token available; save one with 'claude-switch token set %s'", provider.DisplayName(), provider.Name())
+	}
+
+	// Prompt user for token; prompts go to stderr so they survive --quiet
+	app.yellow.Fprintln(os.Stderr, "⚠️  No API token found")
+	fmt.Fprintln(os.Stderr)
+	app.cyan.Fprintf(os.Stderr, "Please enter your %s API token:\n", provider.DisplayName())
+	fmt.Fprint(os.Stderr, "> ")
 
 	reader := bufio.NewReader(os.Stdin)
-	token, err := reader.ReadString('\n')
+	token, err = reader.ReadString('\n')
 	if err != nil {
 		return "", fmt.Errorf("failed to read token: %w", err)
 	}
@@ -181,84 +206,112 @@ func (app *Application) promptForToken() (string, error) {
 	}
 
 	// Ask if user wants to save the token
-	app.cyan.Println("\nSave token for future use? (y/n)")
-	fmt.Print("> ")
+	app.cyan.Fprintln(os.Stderr, "\nSave token for future use? (y/n)")
+	fmt.Fprint(os.Stderr, "> ")
 	answer, _ := reader.ReadString('\n')
 	answer = strings.TrimSpace(strings.ToLower(answer))
 
 	if answer == "y" || answer == "yes" {
-		err = os.WriteFile(tokenFile, []byte(token), 0600)
+		err = store.Set(secretKey, token)
 		if err != nil {
-			app.yellow.Printf("⚠️  Failed to save token: %v\n", err)
+			app.yellow.Fprintf(os.Stderr, "⚠️  Failed to save token: %v\n", err)
 		} else {
-			app.green.Println("✅ Token saved successfully")
+			app.green.Fprintf(os.Stderr, "✅ Token saved successfully (%s secret store)\n", store.Name())
 		}
 	}
 
 	return token, nil
 }
 
-// switchToAnthropic switches to Anthropic configuration
-func (app *Application) switchToAnthropic() error {
-	app.green.Println("🔄 Switching to Anthropic API...")
-
-	// Load current config to check if already using Anthropic
-	currentConfig, err := app.loadConfig(app.settingsFile)
-	if err == nil && app.isAnthropicConfig(currentConfig) {
-		app.yellow.Println("⚠️  Already using Anthropic configuration")
-		app.cyan.Println("   Use --status to check current settings")
-		return nil
+// lookupToken finds a provider's API token in its environment variable,
+// token command, .env file or the secret stores without asking for it. It
+// returns an empty token when there is none. Unless quiet, it notes where
+// the token came from.
+func (app *Application) lookupToken(provider Provider, quiet bool) (string, error) {
+	source := provider.TokenSource()
+	note := func(format string, args ...interface{}) {
+		if !quiet {
+			app.cyan.Printf(format, args...)
+		}
 	}
 
-	// Check if valid Anthropic backup exists
-	hasBackup, backup, err := app.hasValidAnthropicBackup()
-	if err != nil {
-		app.yellow.Printf("⚠️  Failed to read backup: %v\n", err)
+	// Check environment variable first
+	if source.EnvVar != "" {
+		if token := os.Getenv(source.EnvVar); token != "" {
+			note("📌 Using token from %s environment variable\n", source.EnvVar)
+			return token, nil
+		}
 	}
 
-	if !hasBackup || backup == nil {
-		app.red.Println("❌ No valid Anthropic backup found!")
-		app.yellow.Println("⚠️  Cannot restore Anthropic web login token without backup.")
-		app.yellow.Println("   You may need to re-login to Claude Code.")
-		fmt.Println()
-
-		// Create empty config without Z.AI keys
-		config := &Config{Env: make(map[string]string)}
-		err = app.saveConfigAtomic(app.settingsFile, config)
+	// Token command (password manager helper); never fall back to a prompt
+	if source.Command != "" {
+		note("📌 Using token from token command\n")
+		token, err := tokenFromCommand(source.Command)
 		if err != nil {
-			return fmt.Errorf("failed to save config: %w", err)
+			return "", fmt.Errorf("failed to get %s token: %w", provider.DisplayName(), err)
 		}
-
-		app.yellow.Println("⚠️  Created empty configuration (re-login required)")
-		return nil
+		return token, nil
 	}
 
-	// Show backup info
-	if backup.Metadata.CreatedAt != "" {
-		app.cyan.Printf("💾 Restoring from backup created at: %s\n", backup.Metadata.CreatedAt)
+	// .env file
+	if source.EnvFile != "" {
+		token, err := readEnvFile(source.EnvFile, envFileKey(source))
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to read %s: %w", source.EnvFile, err)
+		}
+		if token != "" {
+			note("📌 Using token from %s\n", source.EnvFile)
+			return token, nil
+		}
 	}
 
-	// Create config from backup
-	restoredConfig := &Config{Env: backup.Env}
-
-	// Remove any Z.AI specific keys that might be in backup
-	for _, key := range z_aiEnvKeys {
-		delete(restoredConfig.Env, key)
+	// Check the secret store, then the plaintext token file
+	stores, err := app.tokenStores()
+	if err != nil {
+		return "", err
+	}
+	var storeErr error
+	for _, s := range stores {
+		token, err := s.Get("provider/" + provider.Name())
+		if err == nil {
+			note("📌 Using token from %s secret store\n", s.Name())
+			return token, nil
+		}
+		if !errors.Is(err, errSecretNotFound) {
+			storeErr = fmt.Errorf("failed to read token from %s secret store: %w", s.Name(), err)
+			if !quiet {
+				app.yellow.Printf("⚠️  %v\n", storeErr)
+			}
+		}
 	}
 
-	err = app.saveConfigAtomic(app.settingsFile, restoredConfig)
-	if err != nil {
-		return fmt.Errorf("failed to restore config: %w", err)
+	// Without a prompt to fall back to, a broken store is the error
+	if quiet {
+		return "", storeErr
 	}
+	return "", nil
+}
 
-	app.green.Println("✅ Anthropic configuration restored from backup")
-	app.cyan.Println("   Web login token has been restored")
-	return nil
+// switchTo switches settings.json to the given provider, leaving the active
+// profile
+func (app *Application) switchTo(provider Provider) error {
+	if err := app.switchProvider(provider); err != nil {
+		return err
+	}
+	return app.clearActiveProfile()
 }
 
-// switchToZAI switches to Z.AI configuration
-func (app *Application) switchToZAI() error {
-	app.green.Println("🔄 Switching to Z.AI API...")
+// switchProvider applies a provider's settings to settings.json
+func (app *Application) switchProvider(provider Provider) error {
+	app.green.Printf("🔄 Switching to %s API...\n", provider.DisplayName())
+
+	// With the proxy enabled, switching only changes its upstream
+	if proxied, err := app.switchProxyUpstream(&autoTarget{Provider: provider.Name()}); proxied {
+		return err
+	}
+	if err := requireAnthropicProtocol(provider); err != nil {
+		return err
+	}
 
 	// Load current config
 	config, err := app.loadConfig(app.settingsFile)
@@ -266,39 +319,69 @@ func (app *Application) switchToZAI() error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	// Check if already using Z.AI
-	if app.isZAIConfig(config) {
-		app.yellow.Println("⚠️  Already using Z.AI configuration")
-		app.cyan.Println("   Use --status to check current settings")
-		return nil
+	// Check if already using the target provider
+	currentProvider := app.detectProvider(config)
+	if currentProvider == provider.Name() {
+		app.yellow.Printf("⚠️  Already using %s configuration\n", provider.DisplayName())
+		app.cyan.Println("   Use 'claude-switch status' to check current settings")
+		return errAlreadyActive
 	}
 
-	// Check current provider
-	currentProvider := app.detectProvider(config)
+	// Stash the settings of the provider being left
+	if err := app.backupCurrentConfig(config, currentProvider); err != nil {
+		return err
+	}
 
-	// Only backup if current config is Anthropic (has web login token)
-	if currentProvider == ProviderAnthropic && len(config.Env) > 0 {
-		// Check if valid Anthropic backup already exists
-		hasBackup, existingBackup, err := app.hasValidAnthropicBackup()
-		if err != nil {
-			app.yellow.Printf("⚠️  Failed to check existing backup: %v\n", err)
+	if provider.TokenSource().FromBackup {
+		return app.restoreFromBackup(provider, config)
+	}
+
+	// Start from the provider template, then restore its last-used settings
+	env := provider.DefaultEnv()
+	hasBackup, backup, err := app.hasValidBackup(provider.Name())
+	if err != nil {
+		app.yellow.Printf("⚠️  Failed to read %s backup: %v\n", provider.DisplayName(), err)
+	}
+	if hasBackup && backup != nil {
+		app.cyan.Printf("💾 Restoring last-used %s settings from %s\n", provider.DisplayName(), backup.Metadata.CreatedAt)
+		for key, value := range backup.Env {
+			env[key] = value
 		}
+	}
 
-		if hasBackup && existingBackup != nil {
-			// Backup already exists - don't overwrite
-			app.cyan.Println("💾 Existing Anthropic backup found (preserving web login token)")
-			if existingBackup.Metadata.CreatedAt != "" {
-				app.cyan.Printf("   Backed up at: %s\n", existingBackup.Metadata.CreatedAt)
-			}
-		} else {
-			// Create new backup with metadata
-			err = app.createBackupWithMetadata(config, ProviderAnthropic)
-			if err != nil {
-				return fmt.Errorf("failed to backup Anthropic config (web login token): %w", err)
-			}
-			app.green.Println("✅ Anthropic configuration backed up (web login token saved)")
+	// Get provider API token; external token sources always win over the
+	// restored token so rotated secrets are picked up
+	token := env["ANTHROPIC_AUTH_TOKEN"]
+	if source := provider.TokenSource(); token == "" || source.Command != "" || source.EnvFile != "" {
+		token, err = app.promptForToken(provider)
+		if err != nil {
+			return err
 		}
-	} else if currentProvider == ProviderUnknown {
+	}
+
+	// Validate token format
+	app.validateTokenForProvider(token, provider)
+
+	// Replace provider keys only, keeping every other setting as is
+	clearProviderEnv(config)
+	setProviderEnv(config, env)
+	config.Env["ANTHROPIC_AUTH_TOKEN"] = token
+
+	err = app.saveConfigAtomic(app.settingsFile, config)
+	if err != nil {
+		return fmt.Errorf("failed to save %s configuration: %w", provider.DisplayName(), err)
+	}
+
+	app.green.Printf("✅ %s configuration applied successfully\n", provider.DisplayName())
+	fmt.Println()
+	app.cyan.Println("💡 To switch back to Anthropic: claude-switch use anthropic")
+	return nil
+}
+
+// backupCurrentConfig stashes the provider settings of the current config
+// before switching away from it, so they can be restored when switching back
+func (app *Application) backupCurrentConfig(config *Config, currentProvider string) error {
+	if currentProvider == ProviderUnknown {
 		// Check if we have a valid backup from before
 		hasBackup, _, _ := app.hasValidAnthropicBackup()
 		if hasBackup {
@@ -307,51 +390,118 @@ func (app *Application) switchToZAI() error {
 			app.yellow.Println("⚠️  No Anthropic configuration to backup")
 			app.yellow.Println("   You may need to re-login when switching back")
 		}
-	} else if currentProvider == ProviderCustom {
-		app.yellow.Println("⚠️  Current config is custom provider - not backing up")
-		app.yellow.Println("   Anthropic backup will be preserved if it exists")
+		return nil
 	}
 
-	// Get Z.AI API token
-	token, err := app.promptForToken()
+	label := app.providerLabel(currentProvider)
+
+	// Never replace a backed up web login token with settings that lack one
+	if config.Env["ANTHROPIC_AUTH_TOKEN"] == "" {
+		hasBackup, existingBackup, err := app.hasValidBackup(currentProvider)
+		if err != nil {
+			app.yellow.Printf("⚠️  Failed to check existing backup: %v\n", err)
+		}
+		if hasBackup && existingBackup != nil && existingBackup.Env["ANTHROPIC_AUTH_TOKEN"] != "" {
+			app.cyan.Printf("💾 Existing %s backup found (preserving its token)\n", label)
+			if existingBackup.Metadata.CreatedAt != "" {
+				app.cyan.Printf("   Backed up at: %s\n", existingBackup.Metadata.CreatedAt)
+			}
+			return nil
+		}
+	}
+
+	err := app.createBackupWithMetadata(config, currentProvider)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to backup %s config: %w", label, err)
 	}
 
-	// Validate token format
-	app.validateTokenForProvider(token, ProviderZAI)
-
-	// Create new config for Z.AI (fresh start, don't mix with Anthropic settings)
-	newConfig := &Config{
-		Env: map[string]string{
-			"ANTHROPIC_AUTH_TOKEN":           token,
-			"ANTHROPIC_BASE_URL":             "https://api.z.ai/api/anthropic",
-			"API_TIMEOUT_MS":                 "3000000",
-			"ANTHROPIC_DEFAULT_OPUS_MODEL":   "GLM-4.6",
-			"ANTHROPIC_DEFAULT_SONNET_MODEL": "GLM-4.6",
-			"ANTHROPIC_DEFAULT_HAIKU_MODEL":  "GLM-4.5-Air",
-		},
+	if currentProvider == ProviderAnthropic {
+		app.green.Println("✅ Anthropic configuration backed up (web login token saved)")
+	} else {
+		app.green.Printf("✅ %s configuration backed up\n", label)
 	}
+	return nil
+}
 
-	err = app.saveConfigAtomic(app.settingsFile, newConfig)
+// restoreFromBackup switches to a provider whose token lives in the backup
+func (app *Application) restoreFromBackup(provider Provider, config *Config) error {
+	// Check if valid Anthropic backup exists
+	hasBackup, backup, err := app.hasValidAnthropicBackup()
 	if err != nil {
-		return fmt.Errorf("failed to save Z.AI configuration: %w", err)
+		app.yellow.Printf("⚠️  Failed to read backup: %v\n", err)
 	}
 
-	app.green.Println("✅ Z.AI configuration applied successfully")
-	fmt.Println()
-	app.cyan.Println("💡 To switch back to Anthropic: claude-switch --anthropic")
+	if !hasBackup || backup == nil {
+		app.red.Printf("❌ No valid %s backup found!\n", provider.DisplayName())
+		app.yellow.Printf("⚠️  Cannot restore %s web login token without backup.\n", provider.DisplayName())
+		app.yellow.Println("   You may need to re-login to Claude Code.")
+		fmt.Println()
+
+		// Drop provider keys but keep the rest of the settings
+		clearProviderEnv(config)
+		err = app.saveConfigAtomic(app.settingsFile, config)
+		if err != nil {
+			return fmt.Errorf("failed to save config: %w", err)
+		}
+
+		app.yellow.Println("⚠️  Removed provider settings (re-login required)")
+		return errNoBackup
+	}
+
+	// Show backup info
+	if backup.Metadata.CreatedAt != "" {
+		app.cyan.Printf("💾 Restoring from backup created at: %s\n", backup.Metadata.CreatedAt)
+	}
+
+	// Restore backed up env on top of the current settings, skipping keys
+	// that belong to other providers
+	clearProviderEnv(config)
+	env := map[string]string{}
+	for key, value := range backup.Env {
+		if ownsEnvKey(provider, key) || !isProviderKey(key) {
+			env[key] = value
+		}
+	}
+	setProviderEnv(config, env)
+
+	err = app.saveConfigAtomic(app.settingsFile, config)
+	if err != nil {
+		return fmt.Errorf("failed to restore config: %w", err)
+	}
+
+	app.green.Printf("✅ %s configuration restored from backup\n", provider.DisplayName())
+	app.cyan.Println("   Web login token has been restored")
 	return nil
 }
 
-// isZ_AIKey checks if a key is a Z.AI specific key
-func isZ_AIKey(key string) bool {
-	for _, z_aiKey := range z_aiEnvKeys {
-		if key == z_aiKey {
-			return true
+// clearProviderEnv removes every provider-owned key from a config. The
+// project header of ANTHROPIC_CUSTOM_HEADERS is kept.
+func clearProviderEnv(config *Config) {
+	project := projectHeaderLine(config.Env["ANTHROPIC_CUSTOM_HEADERS"])
+	for _, p := range providerRegistry {
+		for _, key := range p.EnvKeys() {
+			config.deleteEnv(key)
 		}
 	}
-	return false
+	for _, key := range sharedProviderEnvKeys {
+		config.deleteEnv(key)
+	}
+	if project != "" {
+		config.Env["ANTHROPIC_CUSTOM_HEADERS"] = project
+	}
+}
+
+// setProviderEnv writes provider env into a config cleared by
+// clearProviderEnv, keeping its project header
+func setProviderEnv(config *Config, env map[string]string) {
+	for key, value := range env {
+		if key == "ANTHROPIC_CUSTOM_HEADERS" {
+			if value = withProjectHeader(value, config.Env[key]); value == "" {
+				continue
+			}
+		}
+		config.Env[key] = value
+	}
 }
 
 // detectProvider detects the current provider from configuration
@@ -360,40 +510,68 @@ func (app *Application) detectProvider(config *Config) string {
 		return ProviderUnknown
 	}
 
-	baseURL := config.Env["ANTHROPIC_BASE_URL"]
-
-	// Z.AI detection
-	if strings.Contains(baseURL, "z.ai") {
-		return ProviderZAI
-	}
-
-	// If no custom base URL, it's Anthropic (default)
-	if baseURL == "" {
-		return ProviderAnthropic
+	for _, p := range providerRegistry {
+		if p.Detect(config.Env) {
+			return p.Name()
+		}
 	}
 
 	// Custom provider
 	return ProviderCustom
 }
 
-// isAnthropicConfig checks if the current configuration is for Anthropic
-func (app *Application) isAnthropicConfig(config *Config) bool {
-	return app.detectProvider(config) == ProviderAnthropic
-}
-
-// isZAIConfig checks if the current configuration is for Z.AI
-func (app *Application) isZAIConfig(config *Config) bool {
-	return app.detectProvider(config) == ProviderZAI
+// providerBackupFile returns the backup file holding a provider's last-used
+// settings. Anthropic keeps the original settings.json.backup location.
+func (app *Application) providerBackupFile(provider string) string {
+	if provider == ProviderAnthropic {
+		return app.backupFile
+	}
+	return app.backupFile + "." + provider
 }
 
 // hasValidAnthropicBackup checks if a valid Anthropic backup exists
 func (app *Application) hasValidAnthropicBackup() (bool, *BackupConfig, error) {
-	if _, err := os.Stat(app.backupFile); os.IsNotExist(err) {
-		return false, nil, nil
+	return app.hasValidBackup(ProviderAnthropic)
+}
+
+// hasValidBackup checks if a valid backup exists for a provider and loads
+// its token from the secret store
+func (app *Application) hasValidBackup(provider string) (bool, *BackupConfig, error) {
+	ok, backup, err := app.readBackup(provider)
+	if !ok || err != nil {
+		return ok, backup, err
 	}
 
-	data, err := os.ReadFile(app.backupFile)
+	// Load the token kept outside of the backup file
+	if backup.Metadata.SecretStore != "" {
+		store, err := app.secretStore(backup.Metadata.SecretStore)
+		if err != nil {
+			return false, backup, err
+		}
+		token, err := store.Get("backup/" + provider)
+		if err != nil && !errors.Is(err, errSecretNotFound) {
+			return false, backup, fmt.Errorf("failed to read backup token from %s store: %w", store.Name(), err)
+		}
+		if token != "" {
+			if backup.Env == nil {
+				backup.Env = make(map[string]string)
+			}
+			backup.Env["ANTHROPIC_AUTH_TOKEN"] = token
+		}
+	}
+
+	return true, backup, nil
+}
+
+// readBackup checks if a valid backup exists for a provider without loading
+// a token kept in a secret store, which may ask for a passphrase
+func (app *Application) readBackup(provider string) (bool, *BackupConfig, error) {
+	backupFile := app.providerBackupFile(provider)
+	data, err := app.readFile(backupFile)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil, nil
+		}
 		return false, nil, err
 	}
 
@@ -402,7 +580,7 @@ func (app *Application) hasValidAnthropicBackup() (bool, *BackupConfig, error) {
 	if err != nil {
 		// Try loading as old format (without metadata)
 		var oldConfig Config
-		if json.Unmarshal(data, &oldConfig) == nil {
+		if provider == ProviderAnthropic && json.Unmarshal(data, &oldConfig) == nil {
 			// Old format backup - assume it's Anthropic
 			backup = BackupConfig{
 				Metadata: BackupMetadata{Provider: ProviderAnthropic},
@@ -413,15 +591,21 @@ func (app *Application) hasValidAnthropicBackup() (bool, *BackupConfig, error) {
 		return false, nil, err
 	}
 
-	// Check if backup is for Anthropic
-	if backup.Metadata.Provider != ProviderAnthropic {
+	// Old format backups have no metadata and are always Anthropic
+	if backup.Metadata.Provider == "" && provider == ProviderAnthropic {
+		backup.Metadata.Provider = ProviderAnthropic
+	}
+
+	// Check if backup is for the requested provider
+	if backup.Metadata.Provider != provider {
 		return false, &backup, nil
 	}
 
 	return true, &backup, nil
 }
 
-// createBackupWithMetadata creates a backup with metadata
+// createBackupWithMetadata stashes the provider-owned env of a config in the
+// provider's backup file
 func (app *Application) createBackupWithMetadata(config *Config, provider string) error {
 	backup := BackupConfig{
 		Metadata: BackupMetadata{
@@ -429,7 +613,22 @@ func (app *Application) createBackupWithMetadata(config *Config, provider string
 			CreatedAt: time.Now().Format(time.RFC3339),
 			Version:   Version,
 		},
-		Env: config.Env,
+		Env: profileEnvFromConfig(config),
+	}
+
+	// Keep the token out of the backup file when a secret store is selected
+	if storeName := app.defaultSecretStore(); storeName != SecretStoreFile {
+		store, err := app.secretStore(storeName)
+		if err != nil {
+			return err
+		}
+		if token := backup.Env["ANTHROPIC_AUTH_TOKEN"]; token != "" {
+			if err := store.Set("backup/"+provider, token); err != nil {
+				return fmt.Errorf("failed to save backup token to %s store: %w", store.Name(), err)
+			}
+			delete(backup.Env, "ANTHROPIC_AUTH_TOKEN")
+			backup.Metadata.SecretStore = store.Name()
+		}
 	}
 
 	data, err := json.MarshalIndent(backup, "", "  ")
@@ -437,21 +636,53 @@ func (app *Application) createBackupWithMetadata(config *Config, provider string
 		return fmt.Errorf("failed to marshal backup: %w", err)
 	}
 
-	tempFile := app.backupFile + ".tmp"
-	err = os.WriteFile(tempFile, data, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to write temp backup: %w", err)
+	backupFile := app.providerBackupFile(provider)
+
+	// Keep the previous backup in the history before replacing it
+	if _, err := app.takeSnapshot(backupFile); err != nil {
+		app.yellow.Printf("⚠️  Failed to snapshot previous backup: %v\n", err)
 	}
 
-	err = os.Rename(tempFile, app.backupFile)
-	if err != nil {
-		os.Remove(tempFile)
+	if err := app.writeFile(backupFile, data, 0600); err != nil {
 		return fmt.Errorf("failed to save backup: %w", err)
 	}
 
 	return nil
 }
 
+// customProvider builds a provider from the stashed settings of the last
+// custom (unregistered) configuration
+func (app *Application) customProvider() (Provider, error) {
+	hasBackup, backup, err := app.hasValidBackup(ProviderCustom)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read custom backup: %w", err)
+	}
+	if !hasBackup || backup == nil || backup.Env["ANTHROPIC_BASE_URL"] == "" {
+		return nil, fmt.Errorf("no custom provider settings have been backed up")
+	}
+
+	env := make(map[string]string, len(backup.Env))
+	for key, value := range backup.Env {
+		if key != "ANTHROPIC_AUTH_TOKEN" {
+			env[key] = value
+		}
+	}
+
+	return &ProviderSpec{ID: ProviderCustom, Display: "Custom", Env: env}, nil
+}
+
+// resolveProvider finds a registered provider by name, including the stashed
+// custom provider
+func (app *Application) resolveProvider(name string) (Provider, error) {
+	if provider, ok := lookupProvider(name); ok {
+		return provider, nil
+	}
+	if normalizeProviderName(name) == ProviderCustom {
+		return app.customProvider()
+	}
+	return nil, fmt.Errorf("unknown provider %q (available: %s)", name, strings.Join(providerNames(), ", "))
+}
+
 // showStatus shows current configuration status
 func (app *Application) showStatus() error {
 	app.cyan.Println("📊 Current Configuration Status")
@@ -468,12 +699,25 @@ func (app *Application) showStatus() error {
 	}
 
 	baseURL := config.Env["ANTHROPIC_BASE_URL"]
-	if strings.Contains(baseURL, "z.ai") {
-		app.green.Println("┌─────────────────────────────────────┐")
-		app.green.Println("│  🔗 Provider: Z.AI (GLM Models)     │")
-		app.green.Println("└─────────────────────────────────────┘")
-		fmt.Println()
+	current := app.currentProvider(config)
+	provider, known := lookupProvider(current)
+	label := "Custom"
+	if known {
+		label = provider.DisplayName()
+	}
+
+	app.green.Println("┌─────────────────────────────────────┐")
+	app.green.Printf("│  🔗 Provider: %-22s│\n", label)
+	app.green.Println("└─────────────────────────────────────┘")
+	fmt.Println()
+
+	if baseURL == "" {
+		app.cyan.Println("  Base URL: api.anthropic.com (default)")
+	} else {
 		app.cyan.Printf("  Base URL: %s\n", baseURL)
+		if state, err := app.loadProxyState(); err == nil && state != nil && state.Enabled && state.URL() == baseURL {
+			app.cyan.Printf("  Proxy Upstream: %s\n", state.describe())
+		}
 
 		if model := config.Env["ANTHROPIC_DEFAULT_SONNET_MODEL"]; model != "" {
 			app.cyan.Printf("  Sonnet Model: %s\n", model)
@@ -496,22 +740,17 @@ func (app *Application) showStatus() error {
 			if tokenType == TokenTypeZAI {
 				tokenTypeStr = " (API key)"
 			} else if tokenType == TokenTypeAnthropic {
-				tokenTypeStr = " (web token - unexpected for Z.AI)"
+				tokenTypeStr = fmt.Sprintf(" (web token - unexpected for %s)", label)
 			}
 			app.cyan.Printf("  Auth Token: %s%s\n", maskedToken, tokenTypeStr)
 		}
-	} else if baseURL == "" {
-		app.green.Println("┌─────────────────────────────────────┐")
-		app.green.Println("│  🔗 Provider: Anthropic (Default)   │")
-		app.green.Println("└─────────────────────────────────────┘")
-		fmt.Println()
-		app.cyan.Println("  Base URL: api.anthropic.com (default)")
-	} else {
-		app.green.Println("┌─────────────────────────────────────┐")
-		app.green.Println("│  🔗 Provider: Custom                │")
-		app.green.Println("└─────────────────────────────────────┘")
-		fmt.Println()
-		app.cyan.Printf("  Base URL: %s\n", baseURL)
+	}
+
+	// Show active profile if it still matches the settings
+	if profiles, err := app.loadProfiles(); err == nil {
+		if active := profiles.find(profiles.Active); active != nil && active.Provider == current {
+			app.cyan.Printf("  Profile: %s\n", active.Name)
+		}
 	}
 
 	fmt.Println()
@@ -519,7 +758,7 @@ func (app *Application) showStatus() error {
 	// Show other environment variables
 	otherEnvCount := 0
 	for key := range config.Env {
-		if !isZ_AIKey(key) && key != "ANTHROPIC_BASE_URL" {
+		if !isProviderKey(key) {
 			otherEnvCount++
 		}
 	}
@@ -528,7 +767,7 @@ func (app *Application) showStatus() error {
 	}
 
 	// Check for backup with metadata
-	hasBackup, backup, _ := app.hasValidAnthropicBackup()
+	hasBackup, backup, _ := app.readBackup(ProviderAnthropic)
 	if hasBackup && backup != nil {
 		app.cyan.Println("  💾 Backup: Available (Anthropic)")
 		if backup.Metadata.CreatedAt != "" {
@@ -542,6 +781,8 @@ func (app *Application) showStatus() error {
 			} else if tokenType == TokenTypeZAI {
 				app.yellow.Println("     Token: API key (unexpected)")
 			}
+		} else if backup.Metadata.SecretStore != "" {
+			app.cyan.Printf("     Token: in %s store\n", backup.Metadata.SecretStore)
 		}
 	} else if _, err := os.Stat(app.backupFile); err == nil {
 		app.yellow.Println("  💾 Backup: Available (unknown format)")
@@ -549,12 +790,32 @@ func (app *Application) showStatus() error {
 		app.yellow.Println("  💾 Backup: Not found")
 	}
 
-	// Check for saved token
-	tokenFile := filepath.Join(app.configDir, ".z_ai_token")
-	if _, err := os.Stat(tokenFile); err == nil {
-		app.cyan.Println("  🔑 Saved Token: Available")
+	// Show stashed settings of the other providers
+	for _, name := range append(providerNames(), ProviderCustom) {
+		if name == ProviderAnthropic {
+			continue
+		}
+		if ok, stash, _ := app.readBackup(name); ok && stash != nil {
+			app.cyan.Printf("  💾 %s settings: saved %s\n", app.providerLabel(name), stash.Metadata.CreatedAt)
+		}
 	}
 
+	// Check for saved tokens
+	for _, p := range providerRegistry {
+		file := app.tokenFilePath(p.TokenSource())
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err == nil {
+			app.cyan.Printf("  🔑 Saved Token: Available (%s)\n", p.DisplayName())
+		}
+	}
+	if store := app.defaultSecretStore(); store != SecretStoreFile {
+		app.cyan.Printf("  🔐 Secret store: %s\n", store)
+	}
+
+	app.showBudgets()
+
 	return nil
 }
 
@@ -607,22 +868,21 @@ func detectTokenType(token string) TokenType {
 }
 
 // validateTokenForProvider checks if a token appears valid for the given provider
-func (app *Application) validateTokenForProvider(token string, provider string) bool {
+func (app *Application) validateTokenForProvider(token string, provider Provider) bool {
 	tokenType := detectTokenType(token)
 
-	switch provider {
-	case ProviderZAI:
-		// Z.AI should use API keys
-		if tokenType == TokenTypeAnthropic {
-			app.yellow.Println("⚠️  Warning: Token looks like an Anthropic web login token")
-			app.yellow.Println("   Z.AI typically uses API keys (sk-xxx or z_ai-xxx format)")
-			return true // Still allow, just warn
-		}
-	case ProviderAnthropic:
+	if provider.TokenSource().FromBackup {
 		// Anthropic should use web login tokens
 		if tokenType == TokenTypeZAI {
 			app.yellow.Println("⚠️  Warning: Token looks like an API key, not a web login token")
-			app.yellow.Println("   Anthropic web login uses longer JWT-style tokens")
+			app.yellow.Printf("   %s web login uses longer JWT-style tokens\n", provider.DisplayName())
+			return true // Still allow, just warn
+		}
+	} else {
+		// API providers should use API keys
+		if tokenType == TokenTypeAnthropic {
+			app.yellow.Println("⚠️  Warning: Token looks like an Anthropic web login token")
+			app.yellow.Printf("   %s typically uses API keys (sk-xxx format)\n", provider.DisplayName())
 			return true // Still allow, just warn
 		}
 	}
@@ -630,18 +890,107 @@ func (app *Application) validateTokenForProvider(token string, provider string)
 	return true
 }
 
-// clearToken removes the saved token
-func (app *Application) clearToken() error {
-	tokenFile := filepath.Join(app.configDir, ".z_ai_token")
+// setToken asks for a provider token and saves it in the secret store
+func (app *Application) setToken(provider Provider) error {
+	if provider.TokenSource().FromBackup {
+		app.yellow.Printf("⚠️  %s does not use a saved token\n", provider.DisplayName())
+		return nil
+	}
 
-	if _, err := os.Stat(tokenFile); os.IsNotExist(err) {
-		app.yellow.Println("⚠️  No saved token found")
+	token, err := app.readToken(fmt.Sprintf("Please enter your %s API token:", provider.DisplayName()))
+	if err != nil {
+		return err
+	}
+	app.validateTokenForProvider(token, provider)
+
+	store, err := app.secretStore("")
+	if err != nil {
+		return err
+	}
+	if err := store.Set("provider/"+provider.Name(), token); err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+
+	app.green.Printf("✅ Token saved successfully (%s secret store)\n", store.Name())
+	return nil
+}
+
+// showToken reports where a provider's token would be taken from, without
+// running token commands
+func (app *Application) showToken(provider Provider) error {
+	source := provider.TokenSource()
+	if source.FromBackup {
+		app.cyan.Printf("%s uses the web login token kept in the backup\n", provider.DisplayName())
+		return nil
+	}
+
+	if source.EnvVar != "" {
+		if token := os.Getenv(source.EnvVar); token != "" {
+			app.cyan.Printf("🔑 %s: %s (%s environment variable)\n", provider.DisplayName(), maskToken(token), source.EnvVar)
+			return nil
+		}
+	}
+	if source.Command != "" {
+		app.cyan.Printf("🔑 %s: from token command: %s\n", provider.DisplayName(), source.Command)
+		return nil
+	}
+	if source.EnvFile != "" {
+		if token, err := readEnvFile(source.EnvFile, envFileKey(source)); err == nil && token != "" {
+			app.cyan.Printf("🔑 %s: %s (%s)\n", provider.DisplayName(), maskToken(token), source.EnvFile)
+			return nil
+		}
+	}
+
+	stores, err := app.tokenStores()
+	if err != nil {
+		return err
+	}
+	for _, s := range stores {
+		token, err := s.Get("provider/" + provider.Name())
+		if err == nil {
+			app.cyan.Printf("🔑 %s: %s (%s secret store)\n", provider.DisplayName(), maskToken(token), s.Name())
+			return nil
+		}
+		if !errors.Is(err, errSecretNotFound) {
+			app.yellow.Printf("⚠️  Failed to read %s secret store: %v\n", s.Name(), err)
+		}
+	}
+
+	app.yellow.Printf("⚠️  No saved %s token\n", provider.DisplayName())
+	return nil
+}
+
+// clearToken removes the saved token of a provider from the secret store
+// and the plaintext token file
+func (app *Application) clearToken(provider Provider) error {
+	if provider.TokenSource().FromBackup {
+		app.yellow.Printf("⚠️  %s does not use a saved token\n", provider.DisplayName())
 		return nil
 	}
 
-	err := os.Remove(tokenFile)
+	secretKey := "provider/" + provider.Name()
+	stores, err := app.tokenStores()
 	if err != nil {
-		return fmt.Errorf("failed to remove token: %w", err)
+		return err
+	}
+
+	removed := false
+	for _, s := range stores {
+		if _, err := s.Get(secretKey); err != nil {
+			if !errors.Is(err, errSecretNotFound) {
+				app.yellow.Printf("⚠️  Failed to read %s secret store: %v\n", s.Name(), err)
+			}
+			continue
+		}
+		if err := s.Delete(secretKey); err != nil {
+			return fmt.Errorf("failed to remove token: %w", err)
+		}
+		removed = true
+	}
+
+	if !removed {
+		app.yellow.Println("⚠️  No saved token found")
+		return nil
 	}
 
 	app.green.Println("✅ Saved token removed successfully")
@@ -666,7 +1015,6 @@ func (app *Application) install() error {
 	}
 
 	// Install binary to /usr/local/bin
-	installPath := "/usr/local/bin/claude-switch"
 	if execPath != installPath {
 		app.cyan.Println("📦 Installing binary to /usr/local/bin...")
 
@@ -749,9 +1097,20 @@ alias claude-status '%s --status'
 			continue
 		}
 
-		// Check if aliases already exist
-		if strings.Contains(string(content), "Claude Code API Switcher") {
+		// Check if aliases already exist; the auto hook is added separately
+		// so that earlier installations pick it up
+		var added []string
+		if strings.Contains(string(content), "# Claude Code API Switcher\n") {
 			app.yellow.Printf("⚠️  Aliases already exist in %s\n", shellRC)
+			block = ""
+		} else {
+			added = append(added, "Aliases")
+		}
+		if !strings.Contains(string(content), "Claude Code API Switcher auto hook") {
+			block += autoHook(shellOfConfig(shellRC), execPath)
+			added = append(added, "auto hook")
+		}
+		if block == "" {
 			continue
 		}
 
@@ -769,7 +1128,7 @@ alias claude-status '%s --status'
 			continue
 		}
 
-		app.green.Printf("✅ Aliases added to %s\n", shellRC)
+		app.green.Printf("✅ %s added to %s\n", strings.Join(added, " and "), shellRC)
 		installedCount++
 	}
 
@@ -788,6 +1147,8 @@ alias claude-status '%s --status'
 	fmt.Println("  claude-z_ai                # Quick switch to Z.AI")
 	fmt.Println("  claude-status              # Quick status check")
 	fmt.Println()
+	app.cyan.Printf("Directories with a %s file now switch automatically on cd.\n", providerMarkerFile)
+	fmt.Println()
 	app.cyan.Println("Reload your shell:")
 	for _, shellRC := range shellConfigs {
 		fmt.Printf("  source %s\n", shellRC)
@@ -843,95 +1204,68 @@ func (app *Application) printUsage() {
 	app.printHeader()
 	app.cyan.Println("Usage:")
 	fmt.Println()
-	fmt.Println("  claude-switch [command]")
+	fmt.Println("  claude-switch [--quiet] [--no-color] [--dry-run|--confirm] <command> [arguments]")
 	fmt.Println()
 	app.cyan.Println("Commands:")
-	fmt.Println("  -a, --anthropic  Switch to Anthropic API (restore web login token)")
-	fmt.Println("  -z, --z_ai       Switch to Z.AI API (use API key)")
-	fmt.Println("  -s, --status     Show current configuration")
-	fmt.Println("  --clear-token    Remove saved Z_AI API token")
-	fmt.Println("  --install        Install aliases to shell")
-	fmt.Println("  -v, --version    Show version")
-	fmt.Println("  -h, --help       Show this help message")
+	for _, cmd := range cliCommands() {
+		fmt.Printf("  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Println()
+	fmt.Println("  Run 'claude-switch help <command>' for details on a command.")
+	fmt.Println()
+	app.cyan.Println("Global Flags:")
+	fmt.Println("  -q, --quiet     Suppress all output except errors and prompts")
+	fmt.Println("  --no-color      Disable colored output")
+	fmt.Println("  --dry-run       Show the changes a command would make without writing")
+	fmt.Println("  --confirm       Show the changes and ask before applying them")
+	fmt.Println("  -y, --yes       Apply without asking")
+	fmt.Println()
+	app.cyan.Println("Shortcuts:")
+	fmt.Println("  -a, --anthropic  Same as 'use anthropic'")
+	fmt.Println("  -z, --z_ai       Same as 'use z_ai'")
+	fmt.Println("  --use <name>     Same as 'use <name>'")
+	fmt.Println("  -s, --status     Same as 'status'")
+	fmt.Println("  --clear-token    Same as 'token clear z_ai'")
+	fmt.Println("  --install        Same as 'install'")
+	fmt.Println("  -v, --version    Same as 'version'")
+	fmt.Println()
+	app.cyan.Println("Exit Codes:")
+	fmt.Println("  0  Success")
+	fmt.Println("  1  Error")
+	fmt.Println("  2  Invalid command line")
+	fmt.Println("  3  Provider or profile already active")
+	fmt.Println("  4  Backup not found")
+	fmt.Println("  5  Invalid configuration file")
+	fmt.Println("  6  Settings locked by another claude-switch process")
+	fmt.Println("  7  Settings changed on disk in a conflicting way")
+	fmt.Println("  8  Provider failed verification (use --verify)")
+	fmt.Println()
+	app.cyan.Println("Providers:")
+	fmt.Printf("  %s\n", strings.Join(providerNames(), ", "))
+	fmt.Printf("  Custom providers are read from %s\n", app.providersFile)
 	fmt.Println()
 	app.cyan.Println("Authentication:")
 	fmt.Println("  Anthropic  Uses web login token (automatically backed up)")
 	fmt.Println("  Z.AI       Uses API key (prompted or from Z_AI_AUTH_TOKEN env)")
 	fmt.Println()
 	app.cyan.Println("Environment Variables:")
-	fmt.Println("  Z_AI_AUTH_TOKEN  Z.AI API key (optional)")
+	fmt.Println("  Z_AI_AUTH_TOKEN                 Z.AI API key (optional)")
+	fmt.Println("  CLAUDE_SWITCH_SECRET_STORE      Token storage: file, keyring or vault (default: file)")
+	fmt.Println("  CLAUDE_SWITCH_VAULT_PASSPHRASE  Passphrase for the encrypted vault (optional)")
+	fmt.Println("  CLAUDE_SWITCH_LOCK_TIMEOUT      How long to wait for another claude-switch (default: 10s)")
+	fmt.Println("  CLAUDE_SWITCH_CONFIRM           Set to 1 to confirm every change, as with --confirm")
 	fmt.Println()
 	app.cyan.Println("Examples:")
-	fmt.Println("  claude-switch --z_ai       # Backup web token, switch to Z.AI")
-	fmt.Println("  claude-switch --anthropic  # Restore web token from backup")
-	fmt.Println("  claude-switch --status     # Check current provider")
+	fmt.Println("  claude-switch use z_ai        # Backup web token, switch to Z.AI")
+	fmt.Println("  claude-switch use anthropic   # Restore web token from backup")
+	fmt.Println("  claude-switch status          # Check current provider")
 	fmt.Println()
 	app.yellow.Println("Note: Switching to Z.AI automatically backs up your Anthropic")
-	fmt.Println("      web login token. Use --anthropic to restore it later.")
+	fmt.Println("      web login token. Use 'use anthropic' to restore it later.")
 	fmt.Println()
 }
 
 func main() {
-	var (
-		anthropic  = flag.Bool("anthropic", false, "Switch to Anthropic API")
-		a          = flag.Bool("a", false, "Switch to Anthropic API (short)")
-		z_ai       = flag.Bool("z_ai", false, "Switch to Z.AI API")
-		z          = flag.Bool("z", false, "Switch to Z.AI API (short)")
-		status     = flag.Bool("status", false, "Show current configuration")
-		s          = flag.Bool("s", false, "Show current configuration (short)")
-		clearToken = flag.Bool("clear-token", false, "Remove saved Z.AI token")
-		install    = flag.Bool("install", false, "Install aliases to shell")
-		version    = flag.Bool("version", false, "Show version")
-		v          = flag.Bool("v", false, "Show version")
-		help       = flag.Bool("help", false, "Show help message")
-		h          = flag.Bool("h", false, "Show help message")
-	)
-
-	flag.Parse()
-
 	app := NewApplication()
-
-	// Show help if no arguments or help flag
-	if len(os.Args) == 1 || *help || *h {
-		app.printUsage()
-		return
-	}
-
-	// Show version
-	if *version || *v {
-		fmt.Printf("claude-switch v%s (%s/%s)\n", Version, runtime.GOOS, runtime.GOARCH)
-		return
-	}
-
-	// Execute command
-	switch {
-	case *anthropic || *a:
-		if err := app.switchToAnthropic(); err != nil {
-			app.red.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-	case *z_ai || *z:
-		if err := app.switchToZAI(); err != nil {
-			app.red.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-	case *status || *s:
-		if err := app.showStatus(); err != nil {
-			app.red.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-	case *clearToken:
-		if err := app.clearToken(); err != nil {
-			app.red.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-	case *install:
-		if err := app.install(); err != nil {
-			app.red.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-	default:
-		app.printUsage()
-		os.Exit(1)
-	}
+	os.Exit(app.run(os.Args[1:]))
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestApp returns an application whose home directory is a temporary
+// directory, with colored output going to the test's stdout
+func newTestApp(t *testing.T) *Application {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("CLAUDE_SWITCH_SECRET_STORE", "")
+	t.Setenv("CLAUDE_SWITCH_VAULT_PASSPHRASE", "")
+
+	app := NewApplication()
+	app.nonInteractive = true
+	if err := os.MkdirAll(app.configDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// writeTestFile writes a file below dir, creating its directories
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chdirTest changes the working directory for the rest of the test
+func chdirTest(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestProviderBackupsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		settings string
+		want     map[string]string
+	}{
+		{
+			name:     "registered provider",
+			provider: "corp",
+			settings: `{"env":{"ANTHROPIC_BASE_URL":"https://llm.corp.example.com","ANTHROPIC_AUTH_TOKEN":"sk-corp-tuned","API_TIMEOUT_MS":"600000","ANTHROPIC_DEFAULT_OPUS_MODEL":"corp-large"}}`,
+			want: map[string]string{
+				"ANTHROPIC_BASE_URL":           "https://llm.corp.example.com",
+				"ANTHROPIC_AUTH_TOKEN":         "sk-corp-tuned",
+				"API_TIMEOUT_MS":               "600000",
+				"ANTHROPIC_DEFAULT_OPUS_MODEL": "corp-large",
+			},
+		},
+		{
+			name:     "custom gateway",
+			provider: ProviderCustom,
+			settings: `{"env":{"ANTHROPIC_BASE_URL":"https://gateway.example.com","ANTHROPIC_AUTH_TOKEN":"sk-gateway","ANTHROPIC_MODEL":"gw-default"}}`,
+			want: map[string]string{
+				"ANTHROPIC_BASE_URL":   "https://gateway.example.com",
+				"ANTHROPIC_AUTH_TOKEN": "sk-gateway",
+				"ANTHROPIC_MODEL":      "gw-default",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestProvider(t, "corp", "https://llm.corp.example.com")
+			app := newTestApp(t)
+			app.assumeYes = true
+			t.Setenv("Z_AI_AUTH_TOKEN", "sk-zai")
+			writeTestFile(t, app.settingsFile, tt.settings)
+
+			// Leave through another provider and Anthropic, then come back
+			for _, name := range []string{ProviderZAI, ProviderAnthropic, tt.provider} {
+				provider, err := app.resolveProvider(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := app.switchTo(provider); err != nil && err != errNoBackup {
+					t.Fatalf("switchTo(%s) error = %v", name, err)
+				}
+			}
+
+			config, err := app.loadConfig(app.settingsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.Env, tt.want) {
+				t.Errorf("env after the round trip = %v, want %v", config.Env, tt.want)
+			}
+		})
+	}
+}
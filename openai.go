@@ -0,0 +1,744 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Wire protocols of provider endpoints
+const (
+	ProtocolAnthropic = "anthropic" // Anthropic Messages API, used by Claude Code
+	ProtocolOpenAI    = "openai"    // OpenAI Chat Completions API, through the proxy
+)
+
+// providerProtocol returns the wire protocol of the provider an env belongs to
+func providerProtocol(env map[string]string) string {
+	for _, p := range providerRegistry {
+		if p.Detect(env) {
+			if spec, ok := p.(*ProviderSpec); ok && spec.Protocol != "" {
+				return spec.Protocol
+			}
+			break
+		}
+	}
+	return ProtocolAnthropic
+}
+
+// requireAnthropicProtocol fails for providers Claude Code cannot talk to
+// without the proxy
+func requireAnthropicProtocol(provider Provider) error {
+	if spec, ok := provider.(*ProviderSpec); ok && spec.Protocol == ProtocolOpenAI {
+		return fmt.Errorf("%s speaks the OpenAI API, which Claude Code can only use through the proxy; run 'claude-switch proxy enable' first", provider.DisplayName())
+	}
+	return nil
+}
+
+// anthropicRequest is the part of a Messages API request that is translated
+type anthropicRequest struct {
+	Model    string          `json:"model"`
+	System   json.RawMessage `json:"system"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+	MaxTokens     int      `json:"max_tokens"`
+	Temperature   *float64 `json:"temperature"`
+	TopP          *float64 `json:"top_p"`
+	StopSequences []string `json:"stop_sequences"`
+	Stream        bool     `json:"stream"`
+	Tools         []struct {
+		Type        string          `json:"type"`
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		InputSchema json.RawMessage `json:"input_schema"`
+	} `json:"tools"`
+	ToolChoice *struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"tool_choice"`
+}
+
+// anthropicBlock is a content block of a message or system prompt
+type anthropicBlock struct {
+	Type   string `json:"type"`
+	Text   string `json:"text,omitempty"`
+	Source *struct {
+		Type      string `json:"type"`
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+		URL       string `json:"url"`
+	} `json:"source,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+}
+
+// anthropicBlocks decodes content that is either a string or a block list
+func anthropicBlocks(raw json.RawMessage) ([]anthropicBlock, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return []anthropicBlock{{Type: "text", Text: text}}, nil
+	}
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// blocksText joins the text blocks of content
+func blocksText(raw json.RawMessage) string {
+	blocks, _ := anthropicBlocks(raw)
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// openAIRequest is a Chat Completions request
+type openAIRequest struct {
+	Model         string          `json:"model"`
+	Messages      []openAIMessage `json:"messages"`
+	MaxTokens     int             `json:"max_tokens,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	Stop          []string        `json:"stop,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	Tools      []openAITool `json:"tools,omitempty"`
+	ToolChoice interface{}  `json:"tool_choice,omitempty"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    interface{}      `json:"content"` // string, content parts or nil
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    int    `json:"index,omitempty"` // only set in stream deltas
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type openAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// anthropic returns the usage block of a Messages API response. Anthropic
+// input tokens exclude cache reads, which OpenAI counts as prompt tokens.
+func (u *openAIUsage) anthropic() map[string]int {
+	cached := u.PromptTokensDetails.CachedTokens
+	return map[string]int{
+		"input_tokens":            u.PromptTokens - cached,
+		"output_tokens":           u.CompletionTokens,
+		"cache_read_input_tokens": cached,
+	}
+}
+
+// openAIResponse is a Chat Completions response
+type openAIResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+// openAIChunk is one streamed Chat Completions event
+type openAIChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+// anthropicToOpenAI translates a Messages API request body into a Chat
+// Completions request body
+func anthropicToOpenAI(body []byte) ([]byte, error) {
+	var in anthropicRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+
+	out := openAIRequest{
+		Model:       in.Model,
+		MaxTokens:   in.MaxTokens,
+		Temperature: in.Temperature,
+		TopP:        in.TopP,
+		Stop:        in.StopSequences,
+		Stream:      in.Stream,
+	}
+	if in.Stream {
+		// Ask for the token counts Anthropic streams report
+		out.StreamOptions = &struct {
+			IncludeUsage bool `json:"include_usage"`
+		}{IncludeUsage: true}
+	}
+
+	if system := blocksText(in.System); system != "" {
+		out.Messages = append(out.Messages, openAIMessage{Role: "system", Content: system})
+	}
+	for i, message := range in.Messages {
+		blocks, err := anthropicBlocks(message.Content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid content of message %d: %w", i, err)
+		}
+		if message.Role == "assistant" {
+			assistant, err := assistantMessage(blocks)
+			if err != nil {
+				return nil, fmt.Errorf("message %d: %w", i, err)
+			}
+			out.Messages = append(out.Messages, assistant)
+		} else {
+			user, err := userMessages(blocks)
+			if err != nil {
+				return nil, fmt.Errorf("message %d: %w", i, err)
+			}
+			out.Messages = append(out.Messages, user...)
+		}
+	}
+
+	for _, tool := range in.Tools {
+		// Server tools such as web search have no OpenAI equivalent
+		if tool.Type != "" && tool.Type != "custom" {
+			continue
+		}
+		var t openAITool
+		t.Type = "function"
+		t.Function.Name = tool.Name
+		t.Function.Description = tool.Description
+		t.Function.Parameters = tool.InputSchema
+		out.Tools = append(out.Tools, t)
+	}
+	if in.ToolChoice != nil && len(out.Tools) > 0 {
+		switch in.ToolChoice.Type {
+		case "auto":
+			out.ToolChoice = "auto"
+		case "any":
+			out.ToolChoice = "required"
+		case "none":
+			out.ToolChoice = "none"
+		case "tool":
+			out.ToolChoice = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]string{"name": in.ToolChoice.Name},
+			}
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// unsupportedBlock is the error for a content block OpenAI cannot carry.
+// Dropping it would let the model answer without content the user sent.
+func unsupportedBlock(blockType string) error {
+	return fmt.Errorf("%s content blocks are not supported by OpenAI-compatible upstreams", blockType)
+}
+
+// assistantMessage joins the text of an assistant turn and turns its
+// tool_use blocks into tool calls. Thinking blocks are dropped.
+func assistantMessage(blocks []anthropicBlock) (openAIMessage, error) {
+	message := openAIMessage{Role: "assistant"}
+	var text []string
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			text = append(text, block.Text)
+		case "tool_use":
+			var call openAIToolCall
+			call.ID = block.ID
+			call.Type = "function"
+			call.Function.Name = block.Name
+			call.Function.Arguments = string(block.Input)
+			if call.Function.Arguments == "" {
+				call.Function.Arguments = "{}"
+			}
+			message.ToolCalls = append(message.ToolCalls, call)
+		case "thinking", "redacted_thinking":
+		default:
+			return message, unsupportedBlock(block.Type)
+		}
+	}
+	if len(text) > 0 {
+		message.Content = strings.Join(text, "\n")
+	}
+	return message, nil
+}
+
+// userMessages turns the tool_result blocks of a user turn into tool
+// messages, which must directly follow the assistant's tool calls, and the
+// remaining blocks into a user message
+func userMessages(blocks []anthropicBlock) ([]openAIMessage, error) {
+	var messages []openAIMessage
+	var parts []map[string]interface{}
+	textOnly := true
+	for _, block := range blocks {
+		switch block.Type {
+		case "tool_result":
+			// Tool messages only hold text
+			result, err := anthropicBlocks(block.Content)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tool_result content: %w", err)
+			}
+			for _, inner := range result {
+				if inner.Type != "text" {
+					return nil, unsupportedBlock("tool_result " + inner.Type)
+				}
+			}
+			content := blocksText(block.Content)
+			if block.IsError {
+				content = "Error: " + content
+			}
+			messages = append(messages, openAIMessage{Role: "tool", ToolCallID: block.ToolUseID, Content: content})
+		case "text":
+			parts = append(parts, map[string]interface{}{"type": "text", "text": block.Text})
+		case "image":
+			if block.Source == nil {
+				return nil, fmt.Errorf("image content block without a source")
+			}
+			url := block.Source.URL
+			if block.Source.Type == "base64" {
+				url = fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data)
+			}
+			parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": map[string]string{"url": url}})
+			textOnly = false
+		default:
+			return nil, unsupportedBlock(block.Type)
+		}
+	}
+
+	if len(parts) == 0 {
+		return messages, nil
+	}
+	// Plain strings work with every OpenAI-compatible server; content
+	// parts are only needed for images
+	if textOnly {
+		text := make([]string, len(parts))
+		for i, part := range parts {
+			text[i] = part["text"].(string)
+		}
+		return append(messages, openAIMessage{Role: "user", Content: strings.Join(text, "\n")}), nil
+	}
+	return append(messages, openAIMessage{Role: "user", Content: parts}), nil
+}
+
+// anthropicStopReason maps an OpenAI finish reason to a stop reason
+func anthropicStopReason(finish string) string {
+	switch finish {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+// toolInput parses tool call arguments, which some servers leave empty
+func toolInput(arguments string) json.RawMessage {
+	if json.Valid([]byte(arguments)) {
+		return json.RawMessage(arguments)
+	}
+	return json.RawMessage("{}")
+}
+
+// openAIToAnthropic translates a Chat Completions response body into a
+// Messages API response body
+func openAIToAnthropic(body []byte, model string) ([]byte, error) {
+	var in openAIResponse
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, fmt.Errorf("invalid upstream response: %w", err)
+	}
+	if len(in.Choices) == 0 {
+		return nil, fmt.Errorf("upstream response has no choices")
+	}
+	if in.Model != "" {
+		model = in.Model
+	}
+
+	choice := in.Choices[0]
+	content := []anthropicBlock{}
+	if choice.Message.Content != "" {
+		content = append(content, anthropicBlock{Type: "text", Text: choice.Message.Content})
+	}
+	for _, call := range choice.Message.ToolCalls {
+		content = append(content, anthropicBlock{
+			Type:  "tool_use",
+			ID:    call.ID,
+			Name:  call.Function.Name,
+			Input: toolInput(call.Function.Arguments),
+		})
+	}
+
+	usage := map[string]int{"input_tokens": 0, "output_tokens": 0}
+	if in.Usage != nil {
+		usage = in.Usage.anthropic()
+	}
+	return json.Marshal(map[string]interface{}{
+		"id":            "msg_" + in.ID,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   anthropicStopReason(choice.FinishReason),
+		"stop_sequence": nil,
+		"usage":         usage,
+	})
+}
+
+// openAIErrorType maps an HTTP status to an Anthropic error type
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusBadRequest:
+		return "invalid_request_error"
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status == http.StatusServiceUnavailable || status == 529:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// openAIStream translates a streamed Chat Completions response into the
+// Messages API event sequence: message_start, one content_block_start,
+// deltas and content_block_stop per block, message_delta and message_stop.
+// Text streams as it arrives. Parallel tool calls interleave their argument
+// deltas, while Messages API blocks cannot, so tool calls are collected by
+// index and sent as whole blocks at the end.
+type openAIStream struct {
+	w     io.Writer
+	flush func()
+	model string
+
+	started bool
+	id      string
+	blocks  int // content blocks started so far
+	open    int // index of the open block, or -1
+	tools   map[int]*openAIToolCall
+	order   []int // OpenAI tool call indexes in the order they started
+	finish  string
+	usage   openAIUsage
+}
+
+func newOpenAIStream(w io.Writer, flush func(), model string) *openAIStream {
+	return &openAIStream{w: w, flush: flush, model: model, open: -1, tools: map[int]*openAIToolCall{}}
+}
+
+// event writes one server-sent event
+func (s *openAIStream) event(name string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, encoded); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+// start sends message_start once
+func (s *openAIStream) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	return s.event("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            "msg_" + s.id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         s.model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]int{"input_tokens": 0, "output_tokens": 0},
+		},
+	})
+}
+
+// closeBlock stops the open content block
+func (s *openAIStream) closeBlock() error {
+	if s.open < 0 {
+		return nil
+	}
+	index := s.open
+	s.open = -1
+	return s.event("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+}
+
+// startBlock stops the open block and starts a new one
+func (s *openAIStream) startBlock(block map[string]interface{}) error {
+	if err := s.closeBlock(); err != nil {
+		return err
+	}
+	s.open = s.blocks
+	s.blocks++
+	return s.event("content_block_start", map[string]interface{}{"type": "content_block_start", "index": s.open, "content_block": block})
+}
+
+// delta sends a delta for the open block
+func (s *openAIStream) delta(delta map[string]interface{}) error {
+	return s.event("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": s.open, "delta": delta})
+}
+
+// chunk translates one streamed chunk
+func (s *openAIStream) chunk(c *openAIChunk) error {
+	if s.id == "" {
+		s.id = c.ID
+	}
+	if c.Model != "" {
+		s.model = c.Model
+	}
+	if c.Usage != nil {
+		s.usage = *c.Usage
+	}
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	for _, choice := range c.Choices {
+		if text := choice.Delta.Content; text != "" {
+			if s.open < 0 {
+				if err := s.startBlock(map[string]interface{}{"type": "text", "text": ""}); err != nil {
+					return err
+				}
+			}
+			if err := s.delta(map[string]interface{}{"type": "text_delta", "text": text}); err != nil {
+				return err
+			}
+		}
+
+		for _, call := range choice.Delta.ToolCalls {
+			tool := s.tools[call.Index]
+			if tool == nil {
+				tool = &openAIToolCall{}
+				s.tools[call.Index] = tool
+				s.order = append(s.order, call.Index)
+			}
+			// Some servers repeat the id and name in every delta
+			if tool.ID == "" {
+				tool.ID = call.ID
+			}
+			if tool.Function.Name == "" {
+				tool.Function.Name = call.Function.Name
+			}
+			tool.Function.Arguments += call.Function.Arguments
+		}
+
+		if choice.FinishReason != "" {
+			s.finish = choice.FinishReason
+		}
+	}
+	return nil
+}
+
+// toolBlocks sends the collected tool calls as tool_use blocks
+func (s *openAIStream) toolBlocks() error {
+	for _, index := range s.order {
+		tool := s.tools[index]
+		if err := s.startBlock(map[string]interface{}{"type": "tool_use", "id": tool.ID, "name": tool.Function.Name, "input": map[string]interface{}{}}); err != nil {
+			return err
+		}
+		if tool.Function.Arguments != "" {
+			if err := s.delta(map[string]interface{}{"type": "input_json_delta", "partial_json": tool.Function.Arguments}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// end sends the collected tool calls, stops the open block and sends
+// message_delta and message_stop
+func (s *openAIStream) end() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.toolBlocks(); err != nil {
+		return err
+	}
+	if err := s.closeBlock(); err != nil {
+		return err
+	}
+	if err := s.event("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": anthropicStopReason(s.finish), "stop_sequence": nil},
+		"usage": s.usage.anthropic(),
+	}); err != nil {
+		return err
+	}
+	return s.event("message_stop", map[string]string{"type": "message_stop"})
+}
+
+// translate reads the upstream's server-sent events until [DONE] or the
+// end of the stream. A broken stream ends with an error event.
+func (s *openAIStream) translate(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var c openAIChunk
+		if err := json.Unmarshal([]byte(data), &c); err != nil {
+			return s.fail(fmt.Errorf("invalid upstream event: %w", err))
+		}
+		if err := s.chunk(&c); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return s.fail(err)
+	}
+	return s.end()
+}
+
+// fail sends an error event
+func (s *openAIStream) fail(err error) error {
+	s.event("error", map[string]interface{}{
+		"type":  "error",
+		"error": map[string]string{"type": "api_error", "message": err.Error()},
+	})
+	return err
+}
+
+// forwardOpenAI serves a Messages API request from an OpenAI-compatible
+// upstream, translating the request and the response
+func (s *proxyServer) forwardOpenAI(w http.ResponseWriter, r *http.Request, upstream *proxyUpstream, body []byte) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/messages":
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/messages/count_tokens":
+		// OpenAI has no token counting; about four bytes make a token
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"input_tokens": len(body)/4 + 1})
+		return
+	default:
+		writeAPIError(w, http.StatusNotFound, "not_found_error", fmt.Sprintf("%s %s is not available from the OpenAI upstream %s", r.Method, r.URL.Path, upstream.name))
+		return
+	}
+
+	translated, err := anthropicToOpenAI(body)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	var request struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	json.Unmarshal(body, &request)
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, upstream.base.JoinPath("chat/completions").String(), bytes.NewReader(translated))
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "api_error", err.Error())
+		return
+	}
+	target := probeTargetFromEnv(upstream.env)
+	for name, values := range target.Headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	token := target.Token
+	if token == "" {
+		token = target.APIKey
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "api_error", fmt.Sprintf("upstream request failed: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_, message := classifyProbeResponse(resp.StatusCode, data)
+		writeAPIError(w, resp.StatusCode, openAIErrorType(resp.StatusCode), message)
+		return
+	}
+
+	if !request.Stream {
+		data, err := io.ReadAll(resp.Body)
+		if err == nil {
+			data, err = openAIToAnthropic(data, request.Model)
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusBadGateway, "api_error", err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	stream := newOpenAIStream(w, func() { controller.Flush() }, request.Model)
+	stream.translate(resp.Body)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// equalJSON reports whether two JSON documents hold the same values
+func equalJSON(t *testing.T, got []byte, want string) bool {
+	t.Helper()
+
+	var a, b interface{}
+	if err := json.Unmarshal(got, &a); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &b); err != nil {
+		t.Fatalf("invalid JSON %s: %v", want, err)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestAnthropicToOpenAI(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "system prompt and text",
+			in:   `{"model":"gpt-4o","max_tokens":100,"system":[{"type":"text","text":"Be brief."}],"messages":[{"role":"user","content":"Hi"}],"stop_sequences":["END"]}`,
+			want: `{"model":"gpt-4o","max_tokens":100,"stop":["END"],"messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"Hi"}]}`,
+		},
+		{
+			name: "streaming asks for usage",
+			in:   `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hi"}]}`,
+			want: `{"model":"gpt-4o","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"Hi"}]}`,
+		},
+		{
+			name: "tool use and results",
+			in: `{"model":"gpt-4o","messages":[
+				{"role":"user","content":"List files"},
+				{"role":"assistant","content":[{"type":"thinking","thinking":"..."},{"type":"text","text":"Listing."},{"type":"tool_use","id":"call_1","name":"Bash","input":{"command":"ls"}}]},
+				{"role":"user","content":[{"type":"tool_result","tool_use_id":"call_1","content":[{"type":"text","text":"a.go"}]},{"type":"text","text":"Now read it"}]},
+				{"role":"assistant","content":[{"type":"tool_use","id":"call_2","name":"Read","input":{"path":"a.go"}}]},
+				{"role":"user","content":[{"type":"tool_result","tool_use_id":"call_2","content":"no such file","is_error":true}]}
+			]}`,
+			want: `{"model":"gpt-4o","messages":[
+				{"role":"user","content":"List files"},
+				{"role":"assistant","content":"Listing.","tool_calls":[{"id":"call_1","type":"function","function":{"name":"Bash","arguments":"{\"command\":\"ls\"}"}}]},
+				{"role":"tool","tool_call_id":"call_1","content":"a.go"},
+				{"role":"user","content":"Now read it"},
+				{"role":"assistant","content":null,"tool_calls":[{"id":"call_2","type":"function","function":{"name":"Read","arguments":"{\"path\":\"a.go\"}"}}]},
+				{"role":"tool","tool_call_id":"call_2","content":"Error: no such file"}
+			]}`,
+		},
+		{
+			name: "images become content parts",
+			in:   `{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBO"}}]}]}`,
+			want: `{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBO"}}]}]}`,
+		},
+		{
+			name: "tools and tool choice",
+			in: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}],
+				"tools":[{"name":"Bash","description":"Run a command","input_schema":{"type":"object"}},{"type":"web_search_20250305","name":"web_search"}],
+				"tool_choice":{"type":"tool","name":"Bash"}}`,
+			want: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}],
+				"tools":[{"type":"function","function":{"name":"Bash","description":"Run a command","parameters":{"type":"object"}}}],
+				"tool_choice":{"type":"function","function":{"name":"Bash"}}}`,
+		},
+		{
+			name: "any tool is required",
+			in:   `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}],"tools":[{"name":"Bash","input_schema":{"type":"object"}}],"tool_choice":{"type":"any"}}`,
+			want: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}],"tools":[{"type":"function","function":{"name":"Bash","parameters":{"type":"object"}}}],"tool_choice":"required"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := anthropicToOpenAI([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("anthropicToOpenAI() error = %v", err)
+			}
+			if !equalJSON(t, got, tt.want) {
+				t.Errorf("anthropicToOpenAI() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicToOpenAIErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{name: "not JSON", in: `{"model":`},
+		{name: "invalid content", in: `{"model":"gpt-4o","messages":[{"role":"user","content":42}]}`},
+		{name: "document", in: `{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"document","source":{"type":"base64","media_type":"application/pdf","data":"JVBE"}},{"type":"text","text":"Summarize"}]}]}`},
+		{name: "image without a source", in: `{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"image"}]}]}`},
+		{name: "image tool result", in: `{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"tool_result","tool_use_id":"call_1","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBO"}}]}]}]}`},
+		{name: "server tool use", in: `{"model":"gpt-4o","messages":[{"role":"assistant","content":[{"type":"server_tool_use","id":"srv_1","name":"web_search","input":{}}]}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := anthropicToOpenAI([]byte(tt.in)); err == nil {
+				t.Errorf("anthropicToOpenAI(%s) error = nil, want an error", tt.in)
+			}
+		})
+	}
+}
+
+// sseEvent is one server-sent event of a Messages API stream
+type sseEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+// readEvents parses the server-sent events of a Messages API stream
+func readEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+
+	var events []sseEvent
+	for _, raw := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		name, data, ok := strings.Cut(raw, "\n")
+		if !ok || !strings.HasPrefix(name, "event: ") || !strings.HasPrefix(data, "data: ") {
+			t.Fatalf("malformed event %q", raw)
+		}
+		event := sseEvent{name: strings.TrimPrefix(name, "event: ")}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &event.data); err != nil {
+			t.Fatalf("invalid event data %q: %v", data, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// fakeOpenAI serves Chat Completions requests with a canned response and
+// records the last request
+type fakeOpenAI struct {
+	status   int
+	response string // JSON body, or server-sent event data lines
+	stream   bool
+
+	request []byte
+	auth    string
+}
+
+func (f *fakeOpenAI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/chat/completions" {
+		http.NotFound(w, r)
+		return
+	}
+	f.request, _ = io.ReadAll(r.Body)
+	f.auth = r.Header.Get("Authorization")
+
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		w.Write([]byte(f.response))
+		return
+	}
+	if !f.stream {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(f.response))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, line := range strings.Split(strings.TrimSpace(f.response), "\n") {
+		fmt.Fprintf(w, "data: %s\n\n", strings.TrimSpace(line))
+		w.(http.Flusher).Flush()
+	}
+}
+
+// forwardToFakeOpenAI sends a Messages API request through forwardOpenAI to
+// a fake OpenAI server
+func forwardToFakeOpenAI(t *testing.T, upstream *fakeOpenAI, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+	base, err := url.Parse(server.URL + "/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &proxyServer{client: server.Client()}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	s.forwardOpenAI(rec, req, &proxyUpstream{
+		name:     "openai",
+		base:     base,
+		env:      map[string]string{"ANTHROPIC_BASE_URL": server.URL + "/v1", "ANTHROPIC_AUTH_TOKEN": "sk-openai"},
+		protocol: ProtocolOpenAI,
+	}, []byte(body))
+	return rec
+}
+
+func TestForwardOpenAI(t *testing.T) {
+	upstream := &fakeOpenAI{response: `{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"message":{"content":"Hello","tool_calls":[{"id":"call_1","type":"function","function":{"name":"Bash","arguments":"{\"command\":\"ls\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":12,"completion_tokens":5,"prompt_tokens_details":{"cached_tokens":2}}}`}
+	rec := forwardToFakeOpenAI(t, upstream, `{"model":"gpt-4o","max_tokens":50,"messages":[{"role":"user","content":"Hi"}]}`)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if upstream.auth != "Bearer sk-openai" {
+		t.Errorf("Authorization = %q, want the bearer token", upstream.auth)
+	}
+	if !equalJSON(t, upstream.request, `{"model":"gpt-4o","max_tokens":50,"messages":[{"role":"user","content":"Hi"}]}`) {
+		t.Errorf("upstream request = %s", upstream.request)
+	}
+	want := `{"id":"msg_chatcmpl-1","type":"message","role":"assistant","model":"gpt-4o",
+		"content":[{"type":"text","text":"Hello"},{"type":"tool_use","id":"call_1","name":"Bash","input":{"command":"ls"}}],
+		"stop_reason":"tool_use","stop_sequence":null,
+		"usage":{"input_tokens":10,"output_tokens":5,"cache_read_input_tokens":2}}`
+	if !equalJSON(t, rec.Body.Bytes(), want) {
+		t.Errorf("response =\n%s\nwant\n%s", rec.Body, want)
+	}
+}
+
+func TestForwardOpenAIError(t *testing.T) {
+	upstream := &fakeOpenAI{status: http.StatusTooManyRequests, response: `{"error":{"code":"rate_limit_exceeded","message":"Rate limit reached"}}`}
+	rec := forwardToFakeOpenAI(t, upstream, `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	want := `{"type":"error","error":{"type":"rate_limit_error","message":"Rate limit reached"}}`
+	if !equalJSON(t, rec.Body.Bytes(), want) {
+		t.Errorf("response =\n%s\nwant\n%s", rec.Body, want)
+	}
+}
+
+func TestForwardOpenAIStream(t *testing.T) {
+	// toolBlock is a tool_use block put together from its events
+	type toolBlock struct {
+		ID, Name, Input string
+	}
+	tests := []struct {
+		name      string
+		events    string
+		wantNames []string
+		wantText  string
+		wantTools []toolBlock
+		wantDelta string // message_delta event
+	}{
+		{
+			name: "text and a tool call",
+			events: `
+				{"id":"chatcmpl-2","model":"gpt-4o","choices":[{"delta":{"content":"Let me "}}]}
+				{"id":"chatcmpl-2","choices":[{"delta":{"content":"check."}}]}
+				{"id":"chatcmpl-2","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"Bash","arguments":""}}]}}]}
+				{"id":"chatcmpl-2","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"command\":"}}]}}]}
+				{"id":"chatcmpl-2","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"ls\"}"}}]}}]}
+				{"id":"chatcmpl-2","choices":[{"delta":{},"finish_reason":"tool_calls"}]}
+				{"id":"chatcmpl-2","choices":[],"usage":{"prompt_tokens":20,"completion_tokens":7}}
+				[DONE]`,
+			wantNames: []string{
+				"message_start",
+				"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+				"content_block_start", "content_block_delta", "content_block_stop",
+				"message_delta", "message_stop",
+			},
+			wantText:  "Let me check.",
+			wantTools: []toolBlock{{"call_1", "Bash", `{"command":"ls"}`}},
+			wantDelta: `{"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"input_tokens":20,"output_tokens":7,"cache_read_input_tokens":0}}`,
+		},
+		{
+			name: "interleaved parallel tool calls",
+			events: `
+				{"id":"chatcmpl-3","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"Read","arguments":"{\"path\":"}}]}}]}
+				{"id":"chatcmpl-3","choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"Read","arguments":"{\"path\":"}}]}}]}
+				{"id":"chatcmpl-3","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","function":{"name":"Read","arguments":"\"a.go\"}"}}]}}]}
+				{"id":"chatcmpl-3","choices":[{"delta":{"tool_calls":[{"index":1,"function":{"arguments":"\"b.go\"}"}}]},"finish_reason":"tool_calls"}]}
+				[DONE]`,
+			wantNames: []string{
+				"message_start",
+				"content_block_start", "content_block_delta", "content_block_stop",
+				"content_block_start", "content_block_delta", "content_block_stop",
+				"message_delta", "message_stop",
+			},
+			wantTools: []toolBlock{{"call_a", "Read", `{"path":"a.go"}`}, {"call_b", "Read", `{"path":"b.go"}`}},
+			wantDelta: `{"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"input_tokens":0,"output_tokens":0,"cache_read_input_tokens":0}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &fakeOpenAI{stream: true, response: tt.events}
+			rec := forwardToFakeOpenAI(t, upstream, `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hi"}]}`)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("Content-Type = %q", got)
+			}
+
+			var names []string
+			var text string
+			var tools []toolBlock
+			var messageDelta []byte
+			for _, event := range readEvents(t, rec.Body.String()) {
+				names = append(names, event.name)
+				switch event.name {
+				case "content_block_start":
+					if block := event.data["content_block"].(map[string]interface{}); block["type"] == "tool_use" {
+						tools = append(tools, toolBlock{ID: block["id"].(string), Name: block["name"].(string)})
+					}
+				case "content_block_delta":
+					delta := event.data["delta"].(map[string]interface{})
+					switch delta["type"] {
+					case "text_delta":
+						text += delta["text"].(string)
+					case "input_json_delta":
+						tools[len(tools)-1].Input += delta["partial_json"].(string)
+					}
+				case "message_delta":
+					messageDelta, _ = json.Marshal(event.data)
+				}
+			}
+
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Fatalf("events = %v, want %v", names, tt.wantNames)
+			}
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			if !reflect.DeepEqual(tools, tt.wantTools) {
+				t.Errorf("tool blocks = %+v, want %+v", tools, tt.wantTools)
+			}
+			if !equalJSON(t, messageDelta, tt.wantDelta) {
+				t.Errorf("message_delta = %s, want %s", messageDelta, tt.wantDelta)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// errAborted is returned when the user declines the planned changes
+var errAborted = errors.New("aborted; nothing was written")
+
+// writePlan collects the file writes, removals and secret updates of a
+// command so they can be previewed with --dry-run or confirmed before
+// anything touches disk. Reads are not redirected: commands read each file
+// before writing it.
+type writePlan struct {
+	actions []*plannedAction
+	dryRun  bool // show the changes without applying them
+	confirm bool // show the changes and ask before applying them
+
+	// verify is probed in verifyScope before the changes are applied, see
+	// use --verify
+	verify      Provider
+	verifyScope string
+}
+
+// plannedAction is one deferred change
+type plannedAction struct {
+	path    string // file path, or "<store> secret store" for secrets
+	key     string // secret key
+	data    []byte // new file content, nil for removals
+	remove  bool
+	summary bool   // show only the action, not a content diff
+	desc    string // description of a change that is not a file write
+	apply   func() error
+}
+
+// writeFile writes a file atomically, or adds the write to the plan
+func (app *Application) writeFile(filename string, data []byte, perm os.FileMode) error {
+	write := func() error {
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		return writeFileAtomic(filename, data, perm)
+	}
+	if app.plan == nil {
+		return write()
+	}
+	app.plan.actions = append(app.plan.actions, &plannedAction{
+		path:    filename,
+		data:    data,
+		summary: strings.HasPrefix(filename, app.backupDir+string(filepath.Separator)),
+		apply:   write,
+	})
+	return nil
+}
+
+// readFile reads a file as the plan would leave it, so a command sees its
+// own staged writes
+func (app *Application) readFile(filename string) ([]byte, error) {
+	if app.plan != nil {
+		if action := app.plan.latest(filename); action != nil {
+			if action.remove {
+				return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+			}
+			return action.data, nil
+		}
+	}
+	return os.ReadFile(filename)
+}
+
+// latest returns the last staged change of a file
+func (p *writePlan) latest(filename string) *plannedAction {
+	for i := len(p.actions) - 1; i >= 0; i-- {
+		if action := p.actions[i]; action.key == "" && action.desc == "" && action.path == filename {
+			return action
+		}
+	}
+	return nil
+}
+
+// applyChange applies a change that is not a file write, or adds it to the
+// plan with a description
+func (app *Application) applyChange(desc string, apply func() error) error {
+	if app.plan == nil {
+		return apply()
+	}
+	app.plan.actions = append(app.plan.actions, &plannedAction{desc: desc, apply: apply})
+	return nil
+}
+
+// removeFile removes a file, or adds the removal to the plan
+func (app *Application) removeFile(filename string) error {
+	if app.plan == nil {
+		return os.Remove(filename)
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return err
+	}
+	app.plan.actions = append(app.plan.actions, &plannedAction{
+		path:    filename,
+		remove:  true,
+		summary: true,
+		apply:   func() error { return os.Remove(filename) },
+	})
+	return nil
+}
+
+// plannedSecretStore defers secret updates to the plan
+type plannedSecretStore struct {
+	SecretStore
+	plan *writePlan
+}
+
+func (s *plannedSecretStore) Set(key, secret string) error {
+	s.plan.actions = append(s.plan.actions, &plannedAction{
+		path:  s.Name() + " secret store",
+		key:   key,
+		data:  []byte(secret),
+		apply: func() error { return s.SecretStore.Set(key, secret) },
+	})
+	return nil
+}
+
+func (s *plannedSecretStore) Delete(key string) error {
+	s.plan.actions = append(s.plan.actions, &plannedAction{
+		path:   s.Name() + " secret store",
+		key:    key,
+		remove: true,
+		apply:  func() error { return s.SecretStore.Delete(key) },
+	})
+	return nil
+}
+
+// finishPlan shows the planned changes and applies them unless this is a
+// dry run or the user declines. The plan stays staged in app.plan while it
+// is verified.
+func (app *Application) finishPlan(plan *writePlan) error {
+	defer func() { app.plan = nil }()
+
+	if len(plan.actions) == 0 {
+		if plan.dryRun {
+			fmt.Println()
+			app.cyan.Println("📝 No changes")
+		}
+		return nil
+	}
+
+	if plan.verify != nil {
+		if err := app.verifyPlan(plan.verify, plan.verifyScope); err != nil {
+			return err
+		}
+	}
+
+	if !plan.dryRun && !plan.confirm {
+		return plan.apply()
+	}
+
+	fmt.Println()
+
+	if plan.dryRun {
+		app.cyan.Println("📝 Planned changes (dry run, nothing was written):")
+	} else {
+		app.cyan.Println("📝 Planned changes:")
+	}
+	staged := map[string][]byte{}
+	for _, action := range plan.actions {
+		old, seen := staged[action.path]
+		if !seen && action.key == "" && action.desc == "" {
+			old, _ = os.ReadFile(action.path)
+		}
+		app.printAction(action, old)
+		if action.key == "" && action.desc == "" {
+			staged[action.path] = action.data
+		}
+	}
+
+	if plan.dryRun {
+		return nil
+	}
+
+	if !app.assumeYes {
+		if app.nonInteractive {
+			return fmt.Errorf("confirmation required; pass --yes to apply")
+		}
+		app.cyan.Fprintln(os.Stderr, "\nApply these changes? (y/n)")
+		fmt.Fprint(os.Stderr, "> ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.TrimSpace(strings.ToLower(answer))
+		if answer != "y" && answer != "yes" {
+			return errAborted
+		}
+	}
+
+	if err := plan.apply(); err != nil {
+		return err
+	}
+	app.green.Println("✅ Changes applied")
+	return nil
+}
+
+// apply applies the planned changes in order
+func (p *writePlan) apply() error {
+	for _, action := range p.actions {
+		if err := action.apply(); err != nil {
+			if action.desc != "" {
+				return fmt.Errorf("failed to apply change (%s): %w", action.desc, err)
+			}
+			return fmt.Errorf("failed to apply change to %s: %w", action.path, err)
+		}
+	}
+	return nil
+}
+
+// printAction prints one planned change against the previous content of the
+// file, with tokens masked
+func (app *Application) printAction(action *plannedAction, old []byte) {
+	if action.desc != "" {
+		app.yellow.Printf("  ~ %s\n", action.desc)
+		return
+	}
+	if action.key != "" {
+		if action.remove {
+			app.red.Printf("  - %s: delete %s\n", action.path, action.key)
+		} else {
+			app.green.Printf("  + %s: set %s = %s\n", action.path, action.key, maskToken(string(action.data)))
+		}
+		return
+	}
+
+	if action.remove {
+		app.red.Printf("  - remove %s\n", action.path)
+		return
+	}
+
+	if old == nil {
+		app.green.Printf("  + create %s\n", action.path)
+	} else {
+		app.yellow.Printf("  ~ update %s\n", action.path)
+	}
+	if action.summary {
+		return
+	}
+
+	for _, line := range diffJSON(old, action.data) {
+		switch line[0] {
+		case '+':
+			app.green.Printf("      %s\n", line)
+		case '-':
+			app.red.Printf("      %s\n", line)
+		default:
+			app.yellow.Printf("      %s\n", line)
+		}
+	}
+}
+
+// diffJSON returns a key-level diff of two JSON documents, with secrets masked
+func diffJSON(old, new []byte) []string {
+	before := flattenJSON(old)
+	after := flattenJSON(new)
+
+	paths := map[string]bool{}
+	for path := range before {
+		paths[path] = true
+	}
+	for path := range after {
+		paths[path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var lines []string
+	for _, path := range sorted {
+		b, inBefore := before[path]
+		a, inAfter := after[path]
+		switch {
+		case inBefore && inAfter && a != b:
+			lines = append(lines, fmt.Sprintf("~ %s: %s → %s", path, b, a))
+		case inBefore && !inAfter:
+			lines = append(lines, fmt.Sprintf("- %s: %s", path, b))
+		case !inBefore && inAfter:
+			lines = append(lines, fmt.Sprintf("+ %s: %s", path, a))
+		}
+	}
+	return lines
+}
+
+// flattenJSON maps every scalar of a JSON document to its dotted path.
+// Values of token-like keys are masked.
+func flattenJSON(data []byte) map[string]string {
+	flat := map[string]string{}
+	var doc interface{}
+	if len(data) == 0 || json.Unmarshal(data, &doc) != nil {
+		return flat
+	}
+
+	var walk func(path string, value interface{})
+	walk = func(path string, value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				childPath := key
+				if path != "" {
+					childPath = path + "." + key
+				}
+				walk(childPath, child)
+			}
+		case []interface{}:
+			for i, child := range v {
+				walk(fmt.Sprintf("%s[%d]", path, i), child)
+			}
+		default:
+			out, _ := json.Marshal(v)
+			if s, ok := v.(string); ok && isSecretKey(path) {
+				out, _ = json.Marshal(maskToken(s))
+			}
+			flat[path] = string(out)
+		}
+	}
+	walk("", doc)
+	return flat
+}
+
+// isSecretKey reports whether the last segment of a path names a secret
+func isSecretKey(path string) bool {
+	key := path[strings.LastIndex(path, ".")+1:]
+	return strings.Contains(key, "TOKEN") || strings.HasSuffix(key, "API_KEY")
+}
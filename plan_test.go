@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readTree returns the content of every file below dir
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		files[path] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPlanWritesNothing(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{args: []string{"--dry-run", "use", "z_ai"}},
+		{args: []string{"--dry-run", "profile", "use", "work"}},
+		{args: []string{"--dry-run", "profile", "add", "spare"}},
+		{args: []string{"--dry-run", "profile", "rename", "work", "office"}},
+		{args: []string{"--dry-run", "profile", "remove", "work"}},
+		{args: []string{"--dry-run", "backup", "prune", "--keep", "1"}},
+		// Non-interactive without --yes
+		{args: []string{"--confirm", "use", "z_ai"}, want: ExitError},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			app := newTestApp(t)
+			t.Setenv("Z_AI_AUTH_TOKEN", "sk-zai")
+			writeTestFile(t, app.settingsFile, `{"model":"opus","env":{"DEBUG":"1"}}`)
+
+			// Some history, stashes and profiles to leave alone
+			for _, args := range [][]string{
+				{"use", "z_ai"},
+				{"profile", "add", "work"},
+				{"use", "anthropic"},
+			} {
+				if code := app.run(args); code != ExitOK {
+					t.Fatalf("run(%q) = %d", args, code)
+				}
+			}
+			before := readTree(t, app.configDir)
+
+			if code := app.run(tt.args); code != tt.want {
+				t.Errorf("run(%q) = %d, want %d", tt.args, code, tt.want)
+			}
+			if after := readTree(t, app.configDir); !reflect.DeepEqual(after, before) {
+				for path, data := range after {
+					if before[path] != data {
+						t.Errorf("%s changed:\n%s", path, data)
+					}
+				}
+				for path := range before {
+					if _, ok := after[path]; !ok {
+						t.Errorf("%s was removed", path)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errVerifyFailed is returned when use --verify finds the new provider broken
+var errVerifyFailed = errors.New("verification failed")
+
+// Probe outcomes
+const (
+	ProbeOK            = "ok"
+	ProbeAuthFailed    = "auth_failed"
+	ProbeModelNotFound = "model_not_found"
+	ProbeRateLimited   = "rate_limited"
+	ProbeTimeout       = "timeout"
+	ProbeUnreachable   = "unreachable"
+	ProbeHTTPError     = "http_error"
+)
+
+const (
+	// defaultBaseURL is the endpoint Claude Code uses without ANTHROPIC_BASE_URL
+	defaultBaseURL = "https://api.anthropic.com"
+	// defaultProbeModel is probed when no model tier is configured
+	defaultProbeModel = "claude-3-5-haiku-latest"
+	// defaultProbeTimeout applies when API_TIMEOUT_MS is not set
+	defaultProbeTimeout = 30 * time.Second
+	// anthropicVersion is the Messages API version sent with every probe
+	anthropicVersion = "2023-06-01"
+)
+
+// probeTarget is the endpoint and credentials to probe
+type probeTarget struct {
+	BaseURL string
+	Token   string // sent as a bearer token, like ANTHROPIC_AUTH_TOKEN
+	APIKey  string // sent as x-api-key, like ANTHROPIC_API_KEY
+	Headers http.Header
+	// Protocol is the wire protocol of the endpoint
+	Protocol string
+}
+
+// probeResult is the outcome of one probe request
+type probeResult struct {
+	Tier    string
+	Model   string
+	Status  int // HTTP status, 0 when no response arrived
+	Latency time.Duration
+	Outcome string
+	Message string // error detail from the endpoint or the network
+}
+
+// probeTargetFromEnv builds a probe target from provider env
+func probeTargetFromEnv(env map[string]string) *probeTarget {
+	target := &probeTarget{
+		BaseURL:  strings.TrimRight(env["ANTHROPIC_BASE_URL"], "/"),
+		Token:    env["ANTHROPIC_AUTH_TOKEN"],
+		APIKey:   env["ANTHROPIC_API_KEY"],
+		Headers:  http.Header{},
+		Protocol: providerProtocol(env),
+	}
+	if target.BaseURL == "" {
+		target.BaseURL = defaultBaseURL
+	}
+	// ANTHROPIC_CUSTOM_HEADERS holds "Name: Value" lines
+	for _, line := range strings.Split(env["ANTHROPIC_CUSTOM_HEADERS"], "\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			target.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+	return target
+}
+
+// probeTimeout returns API_TIMEOUT_MS of the env, or the default
+func probeTimeout(env map[string]string) (time.Duration, error) {
+	value := env["API_TIMEOUT_MS"]
+	if value == "" {
+		return defaultProbeTimeout, nil
+	}
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms <= 0 {
+		return 0, fmt.Errorf("invalid API_TIMEOUT_MS %q", value)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// probeModel sends a minimal Messages API request for a model and
+// classifies the response
+func probeModel(client *http.Client, target *probeTarget, model string) *probeResult {
+	result := &probeResult{Model: model}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":      model,
+		"max_tokens": 1,
+		"messages":   []map[string]string{{"role": "user", "content": "ping"}},
+	})
+	path := "/v1/messages"
+	if target.Protocol == ProtocolOpenAI {
+		path = "/chat/completions"
+		body, _ = anthropicToOpenAI(body)
+	}
+	req, err := http.NewRequest(http.MethodPost, target.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		result.Outcome = ProbeUnreachable
+		result.Message = err.Error()
+		return result
+	}
+	for name, values := range target.Headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if target.Protocol == ProtocolOpenAI {
+		// OpenAI-compatible servers only take bearer tokens
+		token := target.Token
+		if token == "" {
+			token = target.APIKey
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.Header.Set("anthropic-version", anthropicVersion)
+		if target.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+target.Token)
+		}
+		if target.APIKey != "" {
+			req.Header.Set("x-api-key", target.APIKey)
+		}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.Latency = time.Since(start)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			result.Outcome = ProbeTimeout
+			result.Message = fmt.Sprintf("no response within %s", client.Timeout)
+		} else {
+			result.Outcome = ProbeUnreachable
+			result.Message = err.Error()
+		}
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	result.Outcome, result.Message = classifyProbeResponse(resp.StatusCode, data)
+	return result
+}
+
+// classifyProbeResponse maps a Messages API response to a probe outcome
+func classifyProbeResponse(status int, body []byte) (string, string) {
+	if status >= 200 && status < 300 {
+		return ProbeOK, ""
+	}
+
+	// Anthropic errors look like {"type":"error","error":{"type":...,"message":...}};
+	// compatible endpoints often use {"error":{"code":...,"message":...}}
+	var apiErr struct {
+		Error struct {
+			Type    string      `json:"type"`
+			Code    interface{} `json:"code"`
+			Message string      `json:"message"`
+		} `json:"error"`
+		Message string `json:"message"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &apiErr) == nil {
+		if apiErr.Error.Message != "" {
+			message = apiErr.Error.Message
+		} else if apiErr.Message != "" {
+			message = apiErr.Message
+		}
+	}
+	if len(message) > 200 {
+		message = message[:200] + "..."
+	}
+
+	mentionsModel := strings.Contains(strings.ToLower(message), "model")
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden || apiErr.Error.Type == "authentication_error":
+		return ProbeAuthFailed, message
+	case apiErr.Error.Type == "not_found_error" || (status == http.StatusNotFound || status == http.StatusBadRequest) && mentionsModel:
+		return ProbeModelNotFound, message
+	case status == http.StatusTooManyRequests:
+		return ProbeRateLimited, message
+	default:
+		return ProbeHTTPError, message
+	}
+}
+
+// probeModels lists the model tiers of the env to probe. Without tiers the
+// endpoint picks models itself, so a default model checks the credentials.
+func probeModels(env map[string]string) [][2]string {
+	var models [][2]string
+	for _, tier := range []struct{ name, key string }{
+		{"opus", "ANTHROPIC_DEFAULT_OPUS_MODEL"},
+		{"sonnet", "ANTHROPIC_DEFAULT_SONNET_MODEL"},
+		{"haiku", "ANTHROPIC_DEFAULT_HAIKU_MODEL"},
+		{"model", "ANTHROPIC_MODEL"},
+	} {
+		if model := env[tier.key]; model != "" {
+			models = append(models, [2]string{tier.name, model})
+		}
+	}
+	if len(models) == 0 {
+		models = append(models, [2]string{"default", defaultProbeModel})
+	}
+	return models
+}
+
+// runTestCommand probes the endpoint of the active settings, a profile or a
+// provider with its credentials and model tiers
+func (app *Application) runTestCommand(args []string) error {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	timeoutFlag := fs.Duration("timeout", 0, "Timeout per request (default: API_TIMEOUT_MS, at most 30s)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usageErrorf("usage: claude-switch test [--timeout <duration>] [profile|provider]")
+	}
+
+	var env map[string]string
+	var label string
+	if fs.NArg() == 1 {
+		var err error
+		env, label, err = app.execEnv(fs.Arg(0), false)
+		if err != nil {
+			return err
+		}
+	} else {
+		config, err := app.loadConfig(app.settingsFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		env = config.Env
+		label = app.providerLabel(app.detectProvider(config))
+	}
+
+	timeout := *timeoutFlag
+	if timeout == 0 {
+		var err error
+		if timeout, err = probeTimeout(env); err != nil {
+			return err
+		}
+		// API_TIMEOUT_MS is sized for long generations, not a one-token probe
+		if timeout > defaultProbeTimeout {
+			timeout = defaultProbeTimeout
+		}
+	}
+
+	if err := app.probeEnv(env, label, timeout); err != nil {
+		return err
+	}
+	app.green.Println("✅ Endpoint reachable, credentials accepted, all model tiers resolve")
+	return nil
+}
+
+// probeEnv probes every model tier of provider env and prints the results
+func (app *Application) probeEnv(env map[string]string, label string, timeout time.Duration) error {
+	target := probeTargetFromEnv(env)
+	if target.Token == "" && target.APIKey == "" {
+		return fmt.Errorf("%s has no ANTHROPIC_AUTH_TOKEN or ANTHROPIC_API_KEY to test; Claude Code uses its own login", label)
+	}
+	client := &http.Client{Timeout: timeout}
+
+	app.cyan.Printf("🔌 Testing %s at %s (timeout %s)\n", label, target.BaseURL, timeout)
+	fmt.Println()
+
+	failed := 0
+	models := probeModels(env)
+	for i, tier := range models {
+		result := probeModel(client, target, tier[1])
+		result.Tier = tier[0]
+
+		status := "---"
+		if result.Status != 0 {
+			status = strconv.Itoa(result.Status)
+		}
+		line := fmt.Sprintf("%-8s %-28s %s %6dms  %s", result.Tier, result.Model, status, result.Latency.Milliseconds(), result.Outcome)
+		if result.Message != "" {
+			line += ": " + result.Message
+		}
+
+		switch result.Outcome {
+		case ProbeOK:
+			app.green.Printf("  ✅ %s\n", line)
+		case ProbeRateLimited:
+			// The credentials were accepted
+			app.yellow.Printf("  ⚠️  %s\n", line)
+		default:
+			app.red.Printf("  ❌ %s\n", line)
+			failed++
+		}
+
+		// Every tier fails the same way with bad credentials or no connection
+		if result.Outcome == ProbeAuthFailed || result.Outcome == ProbeUnreachable || result.Outcome == ProbeTimeout {
+			if skipped := len(models) - i - 1; skipped > 0 {
+				app.yellow.Printf("  ⚠️  Skipped %d more tier(s)\n", skipped)
+			}
+			break
+		}
+	}
+
+	fmt.Println()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d probe(s) failed", failed, len(models))
+	}
+	return nil
+}
+
+// verifyPlan probes the configuration the staged writes would leave in the
+// scope being written. The plan is discarded when a probe fails, so the
+// current settings stay untouched.
+func (app *Application) verifyPlan(provider Provider, scope string) error {
+	if provider.TokenSource().FromBackup {
+		app.yellow.Printf("⚠️  Not verifying %s: it uses Claude Code's own login\n", provider.DisplayName())
+		return nil
+	}
+
+	filename, err := app.scopeFile(scope)
+	if err != nil {
+		return err
+	}
+	config, err := app.loadConfig(filename)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	env := config.Env
+	timeout, err := probeTimeout(env)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errVerifyFailed, err)
+	}
+	// The settings lock is held while probing; don't wait for Claude Code's
+	// long request timeout
+	if timeout > defaultProbeTimeout {
+		timeout = defaultProbeTimeout
+	}
+
+	fmt.Println()
+	if err := app.probeEnv(env, provider.DisplayName(), timeout); err != nil {
+		app.red.Println("❌ The current configuration was left in place")
+		return fmt.Errorf("%w for %s (%v); nothing was written", errVerifyFailed, provider.DisplayName(), err)
+	}
+	app.green.Printf("✅ %s verified\n", provider.DisplayName())
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClassifyProbeResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		want        string
+		wantMessage string
+	}{
+		{
+			name:   "success",
+			status: http.StatusOK,
+			body:   `{"type":"message"}`,
+			want:   ProbeOK,
+		},
+		{
+			name:        "anthropic authentication error",
+			status:      http.StatusUnauthorized,
+			body:        `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`,
+			want:        ProbeAuthFailed,
+			wantMessage: "invalid x-api-key",
+		},
+		{
+			name:        "forbidden",
+			status:      http.StatusForbidden,
+			body:        `forbidden`,
+			want:        ProbeAuthFailed,
+			wantMessage: "forbidden",
+		},
+		{
+			name:        "anthropic not found error",
+			status:      http.StatusNotFound,
+			body:        `{"type":"error","error":{"type":"not_found_error","message":"model: claude-x"}}`,
+			want:        ProbeModelNotFound,
+			wantMessage: "model: claude-x",
+		},
+		{
+			name:        "compatible endpoint unknown model",
+			status:      http.StatusBadRequest,
+			body:        `{"error":{"code":1211,"message":"Unknown Model, please check the model code."}}`,
+			want:        ProbeModelNotFound,
+			wantMessage: "Unknown Model, please check the model code.",
+		},
+		{
+			name:        "bad request without a model",
+			status:      http.StatusBadRequest,
+			body:        `{"message":"max_tokens too large"}`,
+			want:        ProbeHTTPError,
+			wantMessage: "max_tokens too large",
+		},
+		{
+			name:        "rate limited",
+			status:      http.StatusTooManyRequests,
+			body:        `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`,
+			want:        ProbeRateLimited,
+			wantMessage: "slow down",
+		},
+		{
+			name:        "overloaded",
+			status:      529,
+			body:        `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			want:        ProbeHTTPError,
+			wantMessage: "Overloaded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, message := classifyProbeResponse(tt.status, []byte(tt.body))
+			if got != tt.want || message != tt.wantMessage {
+				t.Errorf("classifyProbeResponse() = %q, %q; want %q, %q", got, message, tt.want, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestProbeModel(t *testing.T) {
+	// The path and model of the last request
+	var mu sync.Mutex
+	var path, model string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requestBody map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&requestBody)
+		mu.Lock()
+		path, model = r.URL.Path, fmt.Sprint(requestBody["model"])
+		mu.Unlock()
+
+		switch {
+		case r.Header.Get("x-api-key") != "sk-good" && r.Header.Get("Authorization") != "Bearer sk-good":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid token"}}`))
+		case requestBody["model"] == "slow":
+			time.Sleep(200 * time.Millisecond)
+		case requestBody["model"] != "glm-4.6":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"type":"error","error":{"type":"not_found_error","message":"model not found"}}`))
+		default:
+			w.Write([]byte(`{"type":"message","content":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		protocol   string
+		model      string
+		want       string
+		wantStatus int
+		wantPath   string
+	}{
+		{
+			name:       "auth token",
+			env:        map[string]string{"ANTHROPIC_AUTH_TOKEN": "sk-good"},
+			model:      "glm-4.6",
+			want:       ProbeOK,
+			wantStatus: http.StatusOK,
+			wantPath:   "/v1/messages",
+		},
+		{
+			name:       "api key",
+			env:        map[string]string{"ANTHROPIC_API_KEY": "sk-good"},
+			model:      "glm-4.6",
+			want:       ProbeOK,
+			wantStatus: http.StatusOK,
+			wantPath:   "/v1/messages",
+		},
+		{
+			name:       "openai protocol",
+			env:        map[string]string{"ANTHROPIC_AUTH_TOKEN": "sk-good"},
+			protocol:   ProtocolOpenAI,
+			model:      "glm-4.6",
+			want:       ProbeOK,
+			wantStatus: http.StatusOK,
+			wantPath:   "/chat/completions",
+		},
+		{
+			name:       "wrong token",
+			env:        map[string]string{"ANTHROPIC_AUTH_TOKEN": "sk-bad"},
+			model:      "glm-4.6",
+			want:       ProbeAuthFailed,
+			wantStatus: http.StatusUnauthorized,
+			wantPath:   "/v1/messages",
+		},
+		{
+			name:       "unknown model",
+			env:        map[string]string{"ANTHROPIC_AUTH_TOKEN": "sk-good"},
+			model:      "glm-9",
+			want:       ProbeModelNotFound,
+			wantStatus: http.StatusNotFound,
+			wantPath:   "/v1/messages",
+		},
+		{
+			name:     "timeout",
+			env:      map[string]string{"ANTHROPIC_AUTH_TOKEN": "sk-good"},
+			model:    "slow",
+			want:     ProbeTimeout,
+			wantPath: "/v1/messages",
+		},
+	}
+
+	client := &http.Client{Timeout: 100 * time.Millisecond}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env["ANTHROPIC_BASE_URL"] = server.URL + "/"
+			target := probeTargetFromEnv(tt.env)
+			if tt.protocol != "" {
+				target.Protocol = tt.protocol
+			}
+			result := probeModel(client, target, tt.model)
+
+			if result.Outcome != tt.want || result.Status != tt.wantStatus {
+				t.Fatalf("probeModel() = %s (%d) %q, want %s (%d)", result.Outcome, result.Status, result.Message, tt.want, tt.wantStatus)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if path != tt.wantPath {
+				t.Errorf("request path = %s, want %s", path, tt.wantPath)
+			}
+			if model != tt.model {
+				t.Errorf("request model = %s, want %s", model, tt.model)
+			}
+		})
+	}
+
+	server.Close()
+	result := probeModel(client, probeTargetFromEnv(map[string]string{"ANTHROPIC_BASE_URL": server.URL, "ANTHROPIC_AUTH_TOKEN": "sk-good"}), "glm-4.6")
+	if result.Outcome != ProbeUnreachable {
+		t.Errorf("probeModel() of a closed server = %s, want %s", result.Outcome, ProbeUnreachable)
+	}
+}
+
+func TestVerifyPlanProbesWrittenScope(t *testing.T) {
+	app := newTestApp(t)
+	app.assumeYes = true
+
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"type":"message","content":[]}`))
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer bad.Close()
+	useTestProvider(t, "healthy", good.URL)
+	useTestProvider(t, "broken", bad.URL)
+
+	// The local settings of the project point somewhere else
+	project := filepath.Join(t.TempDir(), "project")
+	writeTestFile(t, filepath.Join(project, ".claude", "settings.local.json"),
+		fmt.Sprintf(`{"env":{"ANTHROPIC_BASE_URL":%q,"ANTHROPIC_AUTH_TOKEN":"sk-other"}}`, bad.URL))
+	chdirTest(t, project)
+
+	tests := []struct {
+		provider string
+		wantErr  bool
+		wantURL  string // ANTHROPIC_BASE_URL of the user settings afterwards
+	}{
+		{provider: "healthy", wantURL: good.URL},
+		{provider: "broken", wantErr: true, wantURL: good.URL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			err := app.runUseCommand([]string{tt.provider, "--verify"})
+			if err == nil {
+				err = app.finishPlan(app.plan)
+			}
+			app.plan = nil
+			if tt.wantErr != errors.Is(err, errVerifyFailed) {
+				t.Fatalf("use %s --verify error = %v, wantErr %v", tt.provider, err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := app.loadConfig(app.settingsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.Env["ANTHROPIC_BASE_URL"]; got != tt.wantURL {
+				t.Errorf("ANTHROPIC_BASE_URL = %q, want %q", got, tt.wantURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profile is a named provider account with its own env snapshot and token
+type Profile struct {
+	Name        string            `json:"name"`
+	Provider    string            `json:"provider"`
+	Env         map[string]string `json:"env"`
+	SecretStore string            `json:"secret_store,omitempty"`
+	CreatedAt   string            `json:"created_at"`
+	UpdatedAt   string            `json:"updated_at,omitempty"`
+	Budget      *Budget           `json:"budget,omitempty"`
+}
+
+// secretKey returns the secret store key of the profile's token
+func (p *Profile) secretKey() string {
+	return "profile/" + p.Name
+}
+
+// ProfilesFile represents the profiles file structure
+type ProfilesFile struct {
+	Active   string     `json:"active,omitempty"`
+	Profiles []*Profile `json:"profiles"`
+}
+
+// find returns the profile with the given name
+func (f *ProfilesFile) find(name string) *Profile {
+	for _, p := range f.Profiles {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// remove deletes the profile with the given name
+func (f *ProfilesFile) remove(name string) bool {
+	for i, p := range f.Profiles {
+		if p.Name == name {
+			f.Profiles = append(f.Profiles[:i], f.Profiles[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// loadProfiles loads the profiles file
+func (app *Application) loadProfiles() (*ProfilesFile, error) {
+	data, err := app.readFile(app.profilesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ProfilesFile{}, nil
+		}
+		return nil, err
+	}
+
+	var profiles ProfilesFile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, &configError{file: app.profilesFile, err: err}
+	}
+
+	return &profiles, nil
+}
+
+// saveProfiles saves the profiles file atomically
+func (app *Application) saveProfiles(profiles *ProfilesFile) error {
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal profiles: %w", err)
+	}
+
+	if err := app.writeFile(app.profilesFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to save profiles: %w", err)
+	}
+
+	return nil
+}
+
+// validateProfileName checks that a profile name is usable
+func validateProfileName(name string) error {
+	if name == "" {
+		return fmt.Errorf("profile name cannot be empty")
+	}
+	if strings.ContainsAny(name, " \t/\\") {
+		return fmt.Errorf("profile name %q must not contain spaces or slashes", name)
+	}
+	return nil
+}
+
+// profileEnvFromConfig extracts the provider-owned env keys from a config
+func profileEnvFromConfig(config *Config) map[string]string {
+	env := make(map[string]string)
+	for key, value := range config.Env {
+		if isProviderKey(key) {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// profileEnv returns the profile's env with its token loaded from the
+// profile's secret store
+func (app *Application) profileEnv(profile *Profile) (map[string]string, error) {
+	env := make(map[string]string, len(profile.Env)+1)
+	for key, value := range profile.Env {
+		env[key] = value
+	}
+
+	if profile.SecretStore == "" {
+		return env, nil
+	}
+
+	store, err := app.secretStore(profile.SecretStore)
+	if err != nil {
+		return nil, err
+	}
+	token, err := store.Get(profile.secretKey())
+	if err != nil && !errors.Is(err, errSecretNotFound) {
+		return nil, fmt.Errorf("failed to read token of profile %q from %s store: %w", profile.Name, store.Name(), err)
+	}
+	if token != "" {
+		env["ANTHROPIC_AUTH_TOKEN"] = token
+	}
+
+	return env, nil
+}
+
+// setProfileEnv stores env in the profile, moving the token into the
+// profile's secret store when one is selected
+func (app *Application) setProfileEnv(profile *Profile, env map[string]string) error {
+	profile.Env = make(map[string]string, len(env))
+	for key, value := range env {
+		profile.Env[key] = value
+	}
+
+	if profile.SecretStore == "" {
+		return nil
+	}
+
+	store, err := app.secretStore(profile.SecretStore)
+	if err != nil {
+		return err
+	}
+
+	token := profile.Env["ANTHROPIC_AUTH_TOKEN"]
+	delete(profile.Env, "ANTHROPIC_AUTH_TOKEN")
+	if token == "" {
+		return store.Delete(profile.secretKey())
+	}
+	if err := store.Set(profile.secretKey(), token); err != nil {
+		return fmt.Errorf("failed to save token of profile %q to %s store: %w", profile.Name, store.Name(), err)
+	}
+	return nil
+}
+
+// runProfileCommand dispatches the profile subcommands
+func (app *Application) runProfileCommand(args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		return app.listProfiles()
+	case "add":
+		return app.addProfile(args[1:])
+	case "remove", "rm":
+		if len(args) != 2 {
+			return usageErrorf("usage: claude-switch profile remove <name>")
+		}
+		return app.removeProfile(args[1])
+	case "use":
+		if len(args) != 2 {
+			return usageErrorf("usage: claude-switch profile use <name>")
+		}
+		return app.useProfile(args[1])
+	case "rename", "mv":
+		if len(args) != 3 {
+			return usageErrorf("usage: claude-switch profile rename <old> <new>")
+		}
+		return app.renameProfile(args[1], args[2])
+	case "show":
+		if len(args) != 2 {
+			return usageErrorf("usage: claude-switch profile show <name>")
+		}
+		return app.showProfile(args[1])
+	case "store":
+		if len(args) != 3 {
+			return usageErrorf("usage: claude-switch profile store <name> <inline|%s>", strings.Join(secretStoreNames, "|"))
+		}
+		return app.moveProfileSecret(args[1], args[2])
+	case "budget":
+		return app.runProfileBudget(args[1:])
+	default:
+		return usageErrorf("unknown profile command %q (available: list, add, remove, use, rename, show, store, budget)", args[0])
+	}
+}
+
+// listProfiles prints all profiles
+func (app *Application) listProfiles() error {
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	if len(profiles.Profiles) == 0 {
+		app.yellow.Println("⚠️  No profiles found")
+		app.cyan.Println("   Use 'claude-switch profile add <name>' to create one")
+		return nil
+	}
+
+	app.cyan.Println("👤 Profiles")
+	fmt.Println()
+
+	sorted := append([]*Profile(nil), profiles.Profiles...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	for _, p := range sorted {
+		marker := "  "
+		if p.Name == profiles.Active {
+			marker = "* "
+		}
+		line := fmt.Sprintf("%s%-20s %s", marker, p.Name, app.providerLabel(p.Provider))
+		if p.Name == profiles.Active {
+			app.green.Println(line)
+		} else {
+			fmt.Println(line)
+		}
+	}
+
+	return nil
+}
+
+// providerLabel returns the display name for a provider name
+func (app *Application) providerLabel(name string) string {
+	if provider, ok := lookupProvider(name); ok {
+		return provider.DisplayName()
+	}
+	if name == ProviderCustom {
+		return "Custom"
+	}
+	return name
+}
+
+// addProfile creates a profile from the current settings or a provider template
+func (app *Application) addProfile(args []string) error {
+	fs := flag.NewFlagSet("profile add", flag.ContinueOnError)
+	providerName := fs.String("provider", "", "Create the profile from a provider template")
+	secretStoreName := fs.String("secret-store", "", "Where to keep the token: "+strings.Join(secretStoreNames, ", ")+" (default: CLAUDE_SWITCH_SECRET_STORE)")
+
+	name, rest := splitNameArgs(args)
+	if err := parseFlags(fs, rest); err != nil {
+		return err
+	}
+	if name == "" && fs.NArg() > 0 {
+		name = fs.Arg(0)
+	}
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return err
+	}
+	if profiles.find(name) != nil {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+
+	// Follow the machine's secret store; without one the token stays inline
+	if *secretStoreName == "" && app.defaultSecretStore() != SecretStoreFile {
+		*secretStoreName = app.defaultSecretStore()
+	}
+	if *secretStoreName != "" {
+		if _, err := app.secretStore(*secretStoreName); err != nil {
+			return err
+		}
+	}
+
+	profile := &Profile{
+		Name:        name,
+		SecretStore: *secretStoreName,
+		CreatedAt:   time.Now().Format(time.RFC3339),
+	}
+
+	var env map[string]string
+
+	if *providerName == "" {
+		// Snapshot the current settings
+		config, err := app.loadConfig(app.settingsFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		upstream, err := app.proxiedTarget(config)
+		if err != nil {
+			return err
+		}
+		if upstream != nil {
+			// settings.json holds the proxy's placeholder token; take the
+			// settings of the upstream instead
+			name := upstream.Provider
+			if upstream.Profile != "" {
+				name = upstream.Profile
+			}
+			if env, _, err = app.execEnv(name, false); err != nil {
+				return err
+			}
+			profile.Provider = app.targetProvider(upstream)
+		} else {
+			profile.Provider = app.detectProvider(config)
+			env = profileEnvFromConfig(config)
+		}
+		if profile.Provider == ProviderUnknown {
+			return fmt.Errorf("current configuration is empty; use --provider to create a profile from a template")
+		}
+	} else {
+		provider, ok := lookupProvider(*providerName)
+		if !ok {
+			return fmt.Errorf("unknown provider %q (available: %s)", *providerName, strings.Join(providerNames(), ", "))
+		}
+		if provider.TokenSource().FromBackup {
+			return fmt.Errorf("%s profiles are created from the current login; switch to it and run 'claude-switch profile add %s'", provider.DisplayName(), name)
+		}
+
+		token, err := app.readToken(fmt.Sprintf("Please enter the %s API token for profile %q:", provider.DisplayName(), name))
+		if err != nil {
+			return err
+		}
+		app.validateTokenForProvider(token, provider)
+
+		profile.Provider = provider.Name()
+		env = provider.DefaultEnv()
+		env["ANTHROPIC_AUTH_TOKEN"] = token
+	}
+
+	if err := app.setProfileEnv(profile, env); err != nil {
+		return err
+	}
+
+	profiles.Profiles = append(profiles.Profiles, profile)
+	if err := app.saveProfiles(profiles); err != nil {
+		return err
+	}
+
+	app.green.Printf("✅ Profile %q added (%s)\n", name, app.providerLabel(profile.Provider))
+	return nil
+}
+
+// removeProfile deletes a profile
+func (app *Application) removeProfile(name string) error {
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	profile := profiles.find(name)
+	if profile == nil {
+		return fmt.Errorf("profile %q not found", name)
+	}
+	if profile.SecretStore != "" {
+		if err := app.setProfileEnv(profile, nil); err != nil {
+			app.yellow.Printf("⚠️  Failed to remove stored token: %v\n", err)
+		}
+	}
+	profiles.remove(name)
+	if profiles.Active == name {
+		profiles.Active = ""
+	}
+
+	if err := app.saveProfiles(profiles); err != nil {
+		return err
+	}
+
+	app.green.Printf("✅ Profile %q removed\n", name)
+	return nil
+}
+
+// renameProfile renames a profile
+func (app *Application) renameProfile(oldName, newName string) error {
+	if err := validateProfileName(newName); err != nil {
+		return err
+	}
+
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	profile := profiles.find(oldName)
+	if profile == nil {
+		return fmt.Errorf("profile %q not found", oldName)
+	}
+	if profiles.find(newName) != nil {
+		return fmt.Errorf("profile %q already exists", newName)
+	}
+
+	env, err := app.profileEnv(profile)
+	if err != nil {
+		return err
+	}
+	previous := *profile
+	profile.Name = newName
+	if err := app.setProfileEnv(profile, env); err != nil {
+		return err
+	}
+	profile.UpdatedAt = time.Now().Format(time.RFC3339)
+	if profiles.Active == oldName {
+		profiles.Active = newName
+	}
+
+	if err := app.saveProfiles(profiles); err != nil {
+		return err
+	}
+	app.removeOldProfileSecret(&previous, profile)
+
+	app.green.Printf("✅ Profile %q renamed to %q\n", oldName, newName)
+	return nil
+}
+
+// showProfile prints a profile with its token masked
+func (app *Application) showProfile(name string) error {
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	profile := profiles.find(name)
+	if profile == nil {
+		return fmt.Errorf("profile %q not found", name)
+	}
+
+	app.cyan.Printf("👤 Profile: %s\n", profile.Name)
+	fmt.Println()
+	app.cyan.Printf("  Provider: %s\n", app.providerLabel(profile.Provider))
+	if profile.Name == profiles.Active {
+		app.green.Println("  Active: yes")
+	}
+	if profile.SecretStore != "" {
+		app.cyan.Printf("  Secret store: %s\n", profile.SecretStore)
+	}
+	app.cyan.Printf("  Created: %s\n", profile.CreatedAt)
+	if profile.UpdatedAt != "" {
+		app.cyan.Printf("  Updated: %s\n", profile.UpdatedAt)
+	}
+
+	env, err := app.profileEnv(profile)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Println()
+	for _, key := range keys {
+		value := env[key]
+		if key == "ANTHROPIC_AUTH_TOKEN" {
+			value = maskToken(value)
+		}
+		fmt.Printf("  %s=%s\n", key, value)
+	}
+
+	return nil
+}
+
+// useProfile applies a profile's env to settings.json
+func (app *Application) useProfile(name string) error {
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	profile := profiles.find(name)
+	if profile == nil {
+		return fmt.Errorf("profile %q not found", name)
+	}
+
+	app.green.Printf("🔄 Switching to profile %q (%s)...\n", profile.Name, app.providerLabel(profile.Provider))
+
+	if proxied, err := app.switchProxyUpstream(&autoTarget{Profile: profile.Name}); proxied {
+		if err == nil {
+			profiles.Active = profile.Name
+			err = app.saveProfiles(profiles)
+		}
+		return err
+	}
+	if provider, ok := lookupProvider(profile.Provider); ok {
+		if err := requireAnthropicProtocol(provider); err != nil {
+			return err
+		}
+	}
+
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	currentProvider := app.detectProvider(config)
+
+	// Keep the active profile in sync with the settings it is leaving
+	if active := profiles.find(profiles.Active); active != nil && active.Provider == currentProvider {
+		if active.Name == profile.Name {
+			app.yellow.Printf("⚠️  Already using profile %q\n", profile.Name)
+			return errAlreadyActive
+		}
+		if err := app.setProfileEnv(active, profileEnvFromConfig(config)); err != nil {
+			return err
+		}
+		active.UpdatedAt = time.Now().Format(time.RFC3339)
+	}
+
+	env, err := app.profileEnv(profile)
+	if err != nil {
+		return err
+	}
+
+	if err := app.backupCurrentConfig(config, currentProvider); err != nil {
+		return err
+	}
+
+	clearProviderEnv(config)
+	setProviderEnv(config, env)
+
+	if err := app.saveConfigAtomic(app.settingsFile, config); err != nil {
+		return fmt.Errorf("failed to apply profile: %w", err)
+	}
+
+	profiles.Active = profile.Name
+	if err := app.saveProfiles(profiles); err != nil {
+		return err
+	}
+
+	app.green.Printf("✅ Profile %q applied successfully\n", profile.Name)
+	return nil
+}
+
+// clearActiveProfile forgets the active profile once the settings no longer
+// come from it, so that a later profile switch does not sync them into it
+func (app *Application) clearActiveProfile() error {
+	profiles, err := app.loadProfiles()
+	if err != nil || profiles.Active == "" {
+		return err
+	}
+	profiles.Active = ""
+	return app.saveProfiles(profiles)
+}
+
+// moveProfileSecret moves a profile's token to another secret store.
+// Passing "inline" keeps the token in profiles.json.
+func (app *Application) moveProfileSecret(name, storeName string) error {
+	if storeName == "inline" {
+		storeName = ""
+	} else if _, err := app.secretStore(storeName); err != nil {
+		return err
+	}
+
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	profile := profiles.find(name)
+	if profile == nil {
+		return fmt.Errorf("profile %q not found", name)
+	}
+
+	env, err := app.profileEnv(profile)
+	if err != nil {
+		return err
+	}
+
+	// Save the token in the new store before removing it from the old one,
+	// so that a failing store or profiles file never loses it
+	previous := *profile
+	profile.SecretStore = storeName
+	if err := app.setProfileEnv(profile, env); err != nil {
+		return err
+	}
+	profile.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	if err := app.saveProfiles(profiles); err != nil {
+		return err
+	}
+	app.removeOldProfileSecret(&previous, profile)
+
+	target := storeName
+	if target == "" {
+		target = "inline"
+	}
+	app.green.Printf("✅ Token of profile %q moved to %s store\n", name, target)
+	return nil
+}
+
+// removeOldProfileSecret deletes the token a renamed or moved profile kept
+// under its previous name or store. A failure leaves a stale copy behind and
+// is only reported.
+func (app *Application) removeOldProfileSecret(previous, profile *Profile) {
+	if previous.SecretStore == "" || (previous.SecretStore == profile.SecretStore && previous.Name == profile.Name) {
+		return
+	}
+	if err := app.setProfileEnv(previous, nil); err != nil {
+		app.yellow.Printf("⚠️  Failed to remove the old copy of the token: %v\n", err)
+	}
+}
+
+// readToken asks the user for a token on stdin
+func (app *Application) readToken(prompt string) (string, error) {
+	app.cyan.Fprintln(os.Stderr, prompt)
+	fmt.Fprint(os.Stderr, "> ")
+
+	reader := bufio.NewReader(os.Stdin)
+	token, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read token: %w", err)
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("token cannot be empty")
+	}
+
+	return token, nil
+}
+
+// splitNameArgs separates a leading positional name from trailing flags
+func splitNameArgs(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	return "", args
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProfileSecretSurvivesFailingStore(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    string // store holding the token
+		change   func(app *Application) error
+		wantName string // profile name the token is read under
+	}{
+		{
+			name:  "move to a locked keyring",
+			store: SecretStoreFile,
+			change: func(app *Application) error {
+				return app.moveProfileSecret("work", SecretStoreKeyring)
+			},
+			wantName: "work",
+		},
+		{
+			name:  "rename in a locked keyring",
+			store: SecretStoreKeyring,
+			change: func(app *Application) error {
+				return app.renameProfile("work", "office")
+			},
+			wantName: "work",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			useFakeKeyring(t)
+
+			profile := &Profile{Name: "work", Provider: ProviderZAI, SecretStore: tt.store}
+			if err := app.setProfileEnv(profile, map[string]string{"ANTHROPIC_AUTH_TOKEN": "sk-work"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := app.saveProfiles(&ProfilesFile{Profiles: []*Profile{profile}}); err != nil {
+				t.Fatal(err)
+			}
+
+			t.Setenv("FAKE_KEYRING_LOCKED", "1")
+			if err := tt.change(app); err == nil {
+				t.Fatal("change error = nil, want the keyring failure")
+			}
+
+			profiles, err := app.loadProfiles()
+			if err != nil {
+				t.Fatal(err)
+			}
+			saved := profiles.find(tt.wantName)
+			if saved == nil {
+				t.Fatalf("profile %q is gone", tt.wantName)
+			}
+			env, err := app.profileEnv(saved)
+			if err != nil {
+				t.Fatalf("profileEnv() error = %v", err)
+			}
+			if got := env["ANTHROPIC_AUTH_TOKEN"]; got != "sk-work" {
+				t.Errorf("token = %q, want %q", got, "sk-work")
+			}
+		})
+	}
+}
+
+func TestMoveProfileSecret(t *testing.T) {
+	app := newTestApp(t)
+	useFakeKeyring(t)
+
+	profile := &Profile{Name: "work", Provider: ProviderZAI, SecretStore: SecretStoreFile}
+	if err := app.setProfileEnv(profile, map[string]string{"ANTHROPIC_AUTH_TOKEN": "sk-work"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.saveProfiles(&ProfilesFile{Profiles: []*Profile{profile}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.moveProfileSecret("work", SecretStoreKeyring); err != nil {
+		t.Fatalf("moveProfileSecret() error = %v", err)
+	}
+	if err := app.renameProfile("work", "office"); err != nil {
+		t.Fatalf("renameProfile() error = %v", err)
+	}
+
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := app.profileEnv(profiles.find("office"))
+	if err != nil || env["ANTHROPIC_AUTH_TOKEN"] != "sk-work" {
+		t.Errorf("token = %q, %v; want %q", env["ANTHROPIC_AUTH_TOKEN"], err, "sk-work")
+	}
+
+	for _, name := range []string{SecretStoreFile, SecretStoreKeyring} {
+		store, err := app.secretStore(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secret, err := store.Get("profile/work"); err == nil {
+			t.Errorf("%s store still holds the old token %q", name, secret)
+		}
+	}
+}
+
+func TestProfileLifecycle(t *testing.T) {
+	app := newTestApp(t)
+	zai := func(token string) string {
+		return `{"model":"opus","env":{"ANTHROPIC_BASE_URL":"https://api.z.ai/api/anthropic","ANTHROPIC_AUTH_TOKEN":"` + token + `"}}`
+	}
+	settingsToken := func() string {
+		t.Helper()
+		config, err := app.loadConfig(app.settingsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Env["ANTHROPIC_BASE_URL"] == "" {
+			t.Fatalf("settings lost the base URL: %v", config.Env)
+		}
+		return config.Env["ANTHROPIC_AUTH_TOKEN"]
+	}
+	profileToken := func(name string) string {
+		t.Helper()
+		profiles, err := app.loadProfiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		profile := profiles.find(name)
+		if profile == nil {
+			t.Fatalf("profile %q not found", name)
+		}
+		env, err := app.profileEnv(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return env["ANTHROPIC_AUTH_TOKEN"]
+	}
+	active := func() string {
+		t.Helper()
+		profiles, err := app.loadProfiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return profiles.Active
+	}
+
+	// Two accounts of one provider, each captured from the settings
+	writeTestFile(t, app.settingsFile, zai("sk-personal"))
+	if err := app.addProfile([]string{"personal"}); err != nil {
+		t.Fatalf("profile add personal: %v", err)
+	}
+	writeTestFile(t, app.settingsFile, zai("sk-work"))
+	if err := app.addProfile([]string{"work"}); err != nil {
+		t.Fatalf("profile add work: %v", err)
+	}
+	if err := app.addProfile([]string{"work"}); err == nil {
+		t.Error("profile add of an existing name error = nil")
+	}
+	if err := app.addProfile([]string{"../work"}); err == nil {
+		t.Error("profile add of an invalid name error = nil")
+	}
+
+	if err := app.useProfile("personal"); err != nil {
+		t.Fatalf("profile use personal: %v", err)
+	}
+	if got := settingsToken(); got != "sk-personal" {
+		t.Errorf("token after profile use personal = %q", got)
+	}
+	if got := active(); got != "personal" {
+		t.Errorf("active = %q, want personal", got)
+	}
+	if err := app.useProfile("personal"); err != errAlreadyActive {
+		t.Errorf("second profile use personal error = %v, want errAlreadyActive", err)
+	}
+
+	// A token rotated in the settings is kept in the profile being left
+	writeTestFile(t, app.settingsFile, zai("sk-personal-2"))
+	if err := app.useProfile("work"); err != nil {
+		t.Fatalf("profile use work: %v", err)
+	}
+	if got := settingsToken(); got != "sk-work" {
+		t.Errorf("token after profile use work = %q", got)
+	}
+	if got := profileToken("personal"); got != "sk-personal-2" {
+		t.Errorf("personal token = %q, want the rotated sk-personal-2", got)
+	}
+
+	if err := app.renameProfile("work", "team"); err != nil {
+		t.Fatalf("profile rename: %v", err)
+	}
+	if got := active(); got != "team" {
+		t.Errorf("active after rename = %q, want team", got)
+	}
+	if got := profileToken("team"); got != "sk-work" {
+		t.Errorf("team token = %q, want sk-work", got)
+	}
+
+	if err := app.removeProfile("team"); err != nil {
+		t.Fatalf("profile remove: %v", err)
+	}
+	if got := active(); got != "" {
+		t.Errorf("active after removing it = %q, want none", got)
+	}
+	if err := app.removeProfile("team"); err == nil {
+		t.Error("profile remove of a missing profile error = nil")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Provider describes an API provider that can be written into the env block
+// of settings.json
+type Provider interface {
+	// Name is the stable identifier used on the command line and in backups
+	Name() string
+	// DisplayName is the human readable provider name
+	DisplayName() string
+	// EnvKeys lists every env key owned by the provider
+	EnvKeys() []string
+	// DefaultEnv returns the env template applied on switch, without the token
+	DefaultEnv() map[string]string
+	// Detect reports whether an env block belongs to the provider
+	Detect(env map[string]string) bool
+	// TokenSource describes where the provider's auth token comes from
+	TokenSource() TokenSource
+}
+
+// TokenSource describes where a provider's auth token comes from
+type TokenSource struct {
+	EnvVar     string // environment variable checked first
+	Command    string // shell command printing the token, e.g. "pass show zai"
+	EnvFile    string // .env file containing EnvVar (or ANTHROPIC_AUTH_TOKEN)
+	File       string // token file, relative to the config directory or absolute
+	FromBackup bool   // token is the web login token restored from backup
+}
+
+// ProviderSpec is a Provider defined entirely by data. Adding a new
+// Anthropic-compatible provider only requires a new spec.
+type ProviderSpec struct {
+	ID      string            // provider name
+	Display string            // human readable name
+	Match   string            // substring of ANTHROPIC_BASE_URL identifying the provider
+	Env     map[string]string // env template including ANTHROPIC_BASE_URL
+	Token   TokenSource
+	// Protocol is the wire protocol of the endpoint; empty means the
+	// Anthropic Messages API
+	Protocol string
+}
+
+func (p *ProviderSpec) Name() string        { return p.ID }
+func (p *ProviderSpec) DisplayName() string { return p.Display }
+
+func (p *ProviderSpec) EnvKeys() []string {
+	keys := []string{"ANTHROPIC_AUTH_TOKEN"}
+	for key := range p.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys[1:])
+	return keys
+}
+
+func (p *ProviderSpec) DefaultEnv() map[string]string {
+	env := make(map[string]string, len(p.Env))
+	for key, value := range p.Env {
+		env[key] = value
+	}
+	return env
+}
+
+func (p *ProviderSpec) Detect(env map[string]string) bool {
+	baseURL := env["ANTHROPIC_BASE_URL"]
+	match := p.Match
+	if match == "" {
+		match = p.Env["ANTHROPIC_BASE_URL"]
+	}
+	return match != "" && strings.Contains(baseURL, match)
+}
+
+func (p *ProviderSpec) TokenSource() TokenSource { return p.Token }
+
+// anthropicProvider is the default Anthropic API, used whenever no base URL
+// override is configured
+type anthropicProvider struct{}
+
+func (anthropicProvider) Name() string                  { return ProviderAnthropic }
+func (anthropicProvider) DisplayName() string           { return "Anthropic" }
+func (anthropicProvider) EnvKeys() []string             { return []string{"ANTHROPIC_AUTH_TOKEN"} }
+func (anthropicProvider) DefaultEnv() map[string]string { return map[string]string{} }
+func (anthropicProvider) TokenSource() TokenSource      { return TokenSource{FromBackup: true} }
+
+func (anthropicProvider) Detect(env map[string]string) bool {
+	return env["ANTHROPIC_BASE_URL"] == ""
+}
+
+// zaiProvider is Z.AI's Anthropic-compatible GLM endpoint
+var zaiProvider = &ProviderSpec{
+	ID:      ProviderZAI,
+	Display: "Z.AI",
+	Match:   "z.ai",
+	Env: map[string]string{
+		"ANTHROPIC_BASE_URL":             "https://api.z.ai/api/anthropic",
+		"API_TIMEOUT_MS":                 "3000000",
+		"ANTHROPIC_DEFAULT_OPUS_MODEL":   "GLM-4.6",
+		"ANTHROPIC_DEFAULT_SONNET_MODEL": "GLM-4.6",
+		"ANTHROPIC_DEFAULT_HAIKU_MODEL":  "GLM-4.5-Air",
+	},
+	Token: TokenSource{EnvVar: "Z_AI_AUTH_TOKEN", File: ".z_ai_token"},
+}
+
+// sharedProviderEnvKeys are provider-related env keys that Claude Code reads
+// regardless of the provider. They travel with the provider's settings.
+var sharedProviderEnvKeys = []string{
+	"ANTHROPIC_API_KEY",
+	"ANTHROPIC_MODEL",
+	"ANTHROPIC_SMALL_FAST_MODEL",
+	"ANTHROPIC_CUSTOM_HEADERS",
+}
+
+// providerRegistry holds all known providers in detection order
+var providerRegistry []Provider
+
+func init() {
+	registerProvider(anthropicProvider{})
+	registerProvider(zaiProvider)
+}
+
+// registerProvider adds a provider to the registry, replacing any provider
+// with the same name
+func registerProvider(p Provider) {
+	for i, existing := range providerRegistry {
+		if existing.Name() == p.Name() {
+			providerRegistry[i] = p
+			return
+		}
+	}
+	providerRegistry = append(providerRegistry, p)
+}
+
+// lookupProvider finds a provider by name, ignoring case, '-' and '_'
+func lookupProvider(name string) (Provider, bool) {
+	want := normalizeProviderName(name)
+	for _, p := range providerRegistry {
+		if normalizeProviderName(p.Name()) == want {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// normalizeProviderName folds a provider name for comparison
+func normalizeProviderName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, "-", "")
+	return strings.ReplaceAll(name, "_", "")
+}
+
+// providerNames returns the names of all registered providers
+func providerNames() []string {
+	names := make([]string, 0, len(providerRegistry))
+	for _, p := range providerRegistry {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// isProviderKey checks if a key is owned by any registered provider
+func isProviderKey(key string) bool {
+	for _, p := range providerRegistry {
+		if ownsEnvKey(p, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// ownsEnvKey checks if a key belongs to a provider's settings
+func ownsEnvKey(p Provider, key string) bool {
+	for _, owned := range p.EnvKeys() {
+		if key == owned {
+			return true
+		}
+	}
+	for _, shared := range sharedProviderEnvKeys {
+		if key == shared {
+			return true
+		}
+	}
+	return false
+}
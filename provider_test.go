@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectProvider(t *testing.T) {
+	useTestProvider(t, "corp", "https://llm.corp.example.com/anthropic")
+	registerProvider(&ProviderSpec{ID: "moonshot", Match: "moonshot.", Env: map[string]string{"ANTHROPIC_BASE_URL": "https://api.moonshot.ai/anthropic"}})
+
+	app := newTestApp(t)
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{name: "no env", want: ProviderUnknown},
+		{name: "web login", env: map[string]string{"ANTHROPIC_AUTH_TOKEN": "sk-ant-oat01"}, want: ProviderAnthropic},
+		{name: "z.ai", env: map[string]string{"ANTHROPIC_BASE_URL": "https://api.z.ai/api/anthropic"}, want: ProviderZAI},
+		{name: "z.ai by match", env: map[string]string{"ANTHROPIC_BASE_URL": "https://open.z.ai/other"}, want: ProviderZAI},
+		{name: "registered base URL", env: map[string]string{"ANTHROPIC_BASE_URL": "https://llm.corp.example.com/anthropic"}, want: "corp"},
+		{name: "registered match", env: map[string]string{"ANTHROPIC_BASE_URL": "https://api.moonshot.cn/anthropic"}, want: "moonshot"},
+		{name: "unregistered URL", env: map[string]string{"ANTHROPIC_BASE_URL": "https://gateway.example.com"}, want: ProviderCustom},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := app.detectProvider(&Config{Env: tt.env}); got != tt.want {
+				t.Errorf("detectProvider(%v) = %q, want %q", tt.env, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupProvider(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // "" when not found
+	}{
+		{name: "z_ai", want: ProviderZAI},
+		{name: "Z-AI", want: ProviderZAI},
+		{name: "zai", want: ProviderZAI},
+		{name: "Anthropic", want: ProviderAnthropic},
+		{name: "openrouter"},
+	}
+
+	for _, tt := range tests {
+		p, ok := lookupProvider(tt.name)
+		if got := ""; ok {
+			got = p.Name()
+			if got != tt.want {
+				t.Errorf("lookupProvider(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		} else if tt.want != "" {
+			t.Errorf("lookupProvider(%q) found nothing, want %q", tt.name, tt.want)
+		}
+	}
+}
+
+func TestProviderEnvKeys(t *testing.T) {
+	want := []string{
+		"ANTHROPIC_AUTH_TOKEN",
+		"ANTHROPIC_BASE_URL",
+		"ANTHROPIC_DEFAULT_HAIKU_MODEL",
+		"ANTHROPIC_DEFAULT_OPUS_MODEL",
+		"ANTHROPIC_DEFAULT_SONNET_MODEL",
+		"API_TIMEOUT_MS",
+	}
+	if got := zaiProvider.EnvKeys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("EnvKeys() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"API_TIMEOUT_MS", true},
+		{"ANTHROPIC_CUSTOM_HEADERS", true},
+		{"ANTHROPIC_MODEL", true},
+		{"DEBUG", false},
+		{"MAX_THINKING_TOKENS", false},
+	}
+	for _, tt := range tests {
+		if got := isProviderKey(tt.key); got != tt.want {
+			t.Errorf("isProviderKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSwitchToProvider(t *testing.T) {
+	useTestProvider(t, "corp", "https://llm.corp.example.com/anthropic")
+	app := newTestApp(t)
+	app.assumeYes = true
+	writeTestFile(t, app.settingsFile, `{"model":"opus","env":{"ANTHROPIC_BASE_URL":"https://api.z.ai/api/anthropic","API_TIMEOUT_MS":"3000000","DEBUG":"1"}}`)
+
+	provider, err := app.resolveProvider("corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.switchTo(provider); err != nil && err != errNoBackup {
+		t.Fatalf("switchTo() error = %v", err)
+	}
+
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"ANTHROPIC_BASE_URL":   "https://llm.corp.example.com/anthropic",
+		"ANTHROPIC_AUTH_TOKEN": "sk-corp",
+		"DEBUG":                "1",
+	}
+	if !reflect.DeepEqual(config.Env, want) {
+		t.Errorf("env = %v, want %v", config.Env, want)
+	}
+	if got := app.detectProvider(config); got != "corp" {
+		t.Errorf("detectProvider() = %q, want corp", got)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ProvidersFile represents the user-defined providers file structure
+type ProvidersFile struct {
+	Providers []UserProvider `json:"providers"`
+	// Directories maps directory trees to a provider or "profile:<name>"
+	// for the auto command
+	Directories map[string]string `json:"directories,omitempty"`
+	// Fallbacks maps a provider or "profile:<name>" to the one watch fails
+	// over to
+	Fallbacks map[string]string `json:"fallbacks,omitempty"`
+	// Prices maps a provider to model name prefixes and their prices per
+	// million tokens, overriding the built-in list prices
+	Prices map[string]map[string]ModelPrice `json:"prices,omitempty"`
+	// Hooks maps an event, e.g. "budget_alert", to a shell command that
+	// receives the event as JSON on stdin
+	Hooks map[string]string `json:"hooks,omitempty"`
+}
+
+// UserProvider is a provider declared in providers.json
+type UserProvider struct {
+	Name         string            `json:"name"`
+	DisplayName  string            `json:"display_name,omitempty"`
+	BaseURL      string            `json:"base_url"`
+	Match        string            `json:"match,omitempty"`
+	TimeoutMS    json.Number       `json:"timeout_ms,omitempty"`
+	Models       ModelTiers        `json:"models,omitempty"`
+	TokenEnv     string            `json:"token_env,omitempty"`
+	TokenCommand string            `json:"token_command,omitempty"`
+	TokenEnvFile string            `json:"token_env_file,omitempty"`
+	TokenFile    string            `json:"token_file,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`
+	Protocol     string            `json:"protocol,omitempty"`
+}
+
+// ModelTiers maps Claude Code model tiers to provider model names
+type ModelTiers struct {
+	Opus   string `json:"opus"`
+	Sonnet string `json:"sonnet"`
+	Haiku  string `json:"haiku"`
+}
+
+// reservedProviderNames cannot be used by user-defined providers
+var reservedProviderNames = []string{ProviderAnthropic, ProviderCustom, ProviderUnknown}
+
+// providerNamePattern restricts user-defined provider names, which become
+// part of backup file names and secret store keys
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// loadUserProviders reads providers.json and registers every provider in it
+func (app *Application) loadUserProviders() error {
+	data, err := os.ReadFile(app.providersFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var file ProvidersFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return &configError{file: app.providersFile, err: err}
+	}
+
+	for _, up := range file.Providers {
+		spec, err := app.providerSpecFromUser(up)
+		if err != nil {
+			return &configError{file: app.providersFile, err: fmt.Errorf("invalid provider %q: %w", up.Name, err)}
+		}
+		registerProvider(spec)
+	}
+	app.directories = file.Directories
+	app.fallbacks = file.Fallbacks
+	app.prices = file.Prices
+	app.hooks = file.Hooks
+
+	return nil
+}
+
+// providerSpecFromUser converts a user-defined provider into a ProviderSpec.
+// An entry without base_url that names a built-in provider only overrides
+// the fields it sets, e.g. adding a token_command to z_ai.
+func (app *Application) providerSpecFromUser(up UserProvider) (*ProviderSpec, error) {
+	if up.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if !providerNamePattern.MatchString(up.Name) {
+		return nil, fmt.Errorf("name %q may only contain lowercase letters, digits, '-' and '_'", up.Name)
+	}
+	// Lookups ignore case, '-' and '_', so only an exact name may override
+	// a registered provider
+	for _, reserved := range reservedProviderNames {
+		if normalizeProviderName(up.Name) == normalizeProviderName(reserved) {
+			return nil, fmt.Errorf("name %q clashes with the reserved name %q", up.Name, reserved)
+		}
+	}
+	for _, p := range providerRegistry {
+		if p.Name() != up.Name && normalizeProviderName(p.Name()) == normalizeProviderName(up.Name) {
+			return nil, fmt.Errorf("name %q clashes with provider %q; names are compared ignoring '-' and '_'", up.Name, p.Name())
+		}
+	}
+
+	base := &ProviderSpec{ID: up.Name}
+	if up.BaseURL == "" {
+		existing, ok := lookupProvider(up.Name)
+		spec, isSpec := existing.(*ProviderSpec)
+		if !ok || !isSpec {
+			return nil, fmt.Errorf("base_url is required")
+		}
+		base = spec
+	}
+
+	env := base.DefaultEnv()
+	for key, value := range up.Env {
+		env[key] = value
+	}
+	if up.BaseURL != "" {
+		env["ANTHROPIC_BASE_URL"] = up.BaseURL
+	}
+
+	if up.TimeoutMS != "" {
+		if _, err := strconv.ParseInt(up.TimeoutMS.String(), 10, 64); err != nil {
+			return nil, fmt.Errorf("timeout_ms must be an integer: %w", err)
+		}
+		env["API_TIMEOUT_MS"] = up.TimeoutMS.String()
+	}
+	if up.Models.Opus != "" {
+		env["ANTHROPIC_DEFAULT_OPUS_MODEL"] = up.Models.Opus
+	}
+	if up.Models.Sonnet != "" {
+		env["ANTHROPIC_DEFAULT_SONNET_MODEL"] = up.Models.Sonnet
+	}
+	if up.Models.Haiku != "" {
+		env["ANTHROPIC_DEFAULT_HAIKU_MODEL"] = up.Models.Haiku
+	}
+	delete(env, "ANTHROPIC_AUTH_TOKEN")
+
+	display := up.DisplayName
+	if display == "" {
+		display = base.Display
+	}
+	if display == "" {
+		display = up.Name
+	}
+
+	match := up.Match
+	if match == "" && up.BaseURL == "" {
+		match = base.Match
+	}
+
+	token := base.Token
+	if up.TokenEnv != "" {
+		token.EnvVar = up.TokenEnv
+	}
+	if up.TokenCommand != "" {
+		token.Command = up.TokenCommand
+	}
+	if up.TokenEnvFile != "" {
+		token.EnvFile = app.expandHome(up.TokenEnvFile)
+	}
+	if up.TokenFile != "" {
+		token.File = app.expandHome(up.TokenFile)
+	}
+
+	protocol := base.Protocol
+	switch up.Protocol {
+	case "":
+	case ProtocolAnthropic:
+		protocol = ""
+	case ProtocolOpenAI:
+		protocol = ProtocolOpenAI
+	default:
+		return nil, fmt.Errorf("unknown protocol %q (available: %s, %s)", up.Protocol, ProtocolAnthropic, ProtocolOpenAI)
+	}
+
+	return &ProviderSpec{
+		ID:       base.ID,
+		Display:  display,
+		Match:    match,
+		Env:      env,
+		Token:    token,
+		Protocol: protocol,
+	}, nil
+}
+
+// expandHome expands a leading ~ in a path to the user's home directory
+func (app *Application) expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(homeDir, strings.TrimPrefix(path, "~"))
+}
+
+// tokenFilePath resolves a token file relative to the config directory
+func (app *Application) tokenFilePath(source TokenSource) string {
+	if source.File == "" || filepath.IsAbs(source.File) {
+		return source.File
+	}
+	return filepath.Join(app.configDir, source.File)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProviderSpecFromUserNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "corp"},
+		{name: "open-router_2"},
+		{name: "", wantErr: true},
+		{name: "Corp", wantErr: true},
+		{name: "../../evil", wantErr: true},
+		{name: "a/b", wantErr: true},
+		{name: "corp gateway", wantErr: true},
+		{name: "anthropic", wantErr: true},
+		{name: "custom", wantErr: true},
+		{name: "z_ai"},
+		{name: "zai", wantErr: true},
+		{name: "z-ai", wantErr: true},
+		{name: "open-router"},
+		{name: "open_router", wantErr: true},
+		{name: "openrouter", wantErr: true},
+	}
+
+	app := newTestApp(t)
+	useTestProvider(t, "open-router", "https://openrouter.example.com")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := app.providerSpecFromUser(UserProvider{Name: tt.name, BaseURL: "https://llm.example.com"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("providerSpecFromUser(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadUserProvidersClash(t *testing.T) {
+	app := newTestApp(t)
+	registry := append([]Provider(nil), providerRegistry...)
+	t.Cleanup(func() { providerRegistry = registry })
+	writeTestFile(t, app.providersFile, `{"providers":[
+		{"name":"open-router","base_url":"https://openrouter.ai/api"},
+		{"name":"open_router","base_url":"https://openrouter.example.com"}
+	]}`)
+
+	err := app.loadUserProviders()
+	if err == nil || !strings.Contains(err.Error(), `clashes with provider "open-router"`) {
+		t.Errorf("loadUserProviders() error = %v, want a clash with open-router", err)
+	}
+}
+
+func TestRunWithBrokenProviders(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{args: []string{"use", "corp"}, want: ExitBadConfig},
+		{args: []string{"status"}, want: ExitBadConfig},
+		{args: []string{"version"}, want: ExitOK},
+		{args: []string{"help", "use"}, want: ExitOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			app := newTestApp(t)
+			writeTestFile(t, app.providersFile, `{"providers":[{"name":"corp",`)
+
+			if got := app.run(tt.args); got != tt.want {
+				t.Errorf("run(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,833 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultProxyPort is the port the proxy listens on unless --port is given
+const defaultProxyPort = 8787
+
+// proxyTiers are the model tiers the proxy can route separately
+var proxyTiers = []string{"opus", "sonnet", "haiku"}
+
+// modelTier returns the tier a Claude model name belongs to, or "" when the
+// name has none
+func modelTier(model string) string {
+	lower := strings.ToLower(model)
+	for _, tier := range proxyTiers {
+		if strings.Contains(lower, tier) {
+			return tier
+		}
+	}
+	return ""
+}
+
+// ProxyState is the proxy configuration in proxy.json. Switching providers
+// while the proxy is enabled only changes Upstream.
+type ProxyState struct {
+	Enabled  bool   `json:"enabled"`
+	Port     int    `json:"port"`
+	Upstream string `json:"upstream"` // provider or "profile:<name>"
+	Token    string `json:"token"`    // placeholder token Claude Code sends to the proxy
+
+	// Routes sends a model tier to another provider or profile than Upstream
+	Routes map[string]string `json:"routes,omitempty"`
+}
+
+// describe summarizes the upstream and the routed tiers
+func (s *ProxyState) describe() string {
+	parts := []string{s.Upstream}
+	for _, tier := range proxyTiers {
+		if route := s.Routes[tier]; route != "" {
+			parts = append(parts, fmt.Sprintf("%s → %s", tier, route))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// URL returns the address Claude Code talks to
+func (s *ProxyState) URL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", s.Port)
+}
+
+// loadProxyState reads proxy.json, returning nil when it does not exist
+func (app *Application) loadProxyState() (*ProxyState, error) {
+	data, err := app.readFile(app.proxyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var state ProxyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, &configError{file: app.proxyFile, err: err}
+	}
+	return &state, nil
+}
+
+// proxiedTarget returns the proxy's upstream while settings.json points at
+// the enabled proxy, or nil
+func (app *Application) proxiedTarget(config *Config) (*autoTarget, error) {
+	state, err := app.loadProxyState()
+	if err != nil || state == nil || !state.Enabled || config.Env["ANTHROPIC_BASE_URL"] != state.URL() {
+		return nil, err
+	}
+	return parseAutoTarget(state.Upstream)
+}
+
+// targetProvider returns the provider name of a provider or profile target
+func (app *Application) targetProvider(target *autoTarget) string {
+	if target.Profile == "" {
+		if provider, ok := lookupProvider(target.Provider); ok {
+			return provider.Name()
+		}
+		return target.Provider
+	}
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return ProviderUnknown
+	}
+	if profile := profiles.find(target.Profile); profile != nil {
+		return profile.Provider
+	}
+	return ProviderUnknown
+}
+
+// currentProvider returns the provider settings.json selects. Through the
+// proxy, that is the provider of its upstream rather than a custom one.
+func (app *Application) currentProvider(config *Config) string {
+	if target, err := app.proxiedTarget(config); err == nil && target != nil {
+		return app.targetProvider(target)
+	}
+	return app.detectProvider(config)
+}
+
+// saveProxyState writes proxy.json
+func (app *Application) saveProxyState(state *ProxyState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal proxy state: %w", err)
+	}
+	if err := app.writeFile(app.proxyFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to save proxy state: %w", err)
+	}
+	return nil
+}
+
+// switchProxyUpstream points an enabled proxy at another provider or profile.
+// It reports false when the proxy is not enabled.
+func (app *Application) switchProxyUpstream(target *autoTarget) (bool, error) {
+	state, err := app.loadProxyState()
+	if err != nil || state == nil || !state.Enabled {
+		return false, err
+	}
+
+	name := targetName(target)
+	if state.Upstream == name {
+		app.yellow.Printf("⚠️  The proxy already forwards to %s\n", name)
+		return true, errAlreadyActive
+	}
+	if err := app.checkProxyTarget(target); err != nil {
+		return true, err
+	}
+
+	state.Upstream = name
+	if err := app.saveProxyState(state); err != nil {
+		return true, err
+	}
+	app.green.Printf("🔀 Proxy at %s now forwards to %s\n", state.URL(), name)
+	app.cyan.Println("   Running Claude Code sessions use it from the next request")
+	return true, nil
+}
+
+// checkProxyTarget fails when a provider or profile to forward to does not exist
+func (app *Application) checkProxyTarget(target *autoTarget) error {
+	if target.Profile == "" {
+		_, err := app.resolveProvider(target.Provider)
+		return err
+	}
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return err
+	}
+	if profiles.find(target.Profile) == nil {
+		return fmt.Errorf("profile %q not found", target.Profile)
+	}
+	return nil
+}
+
+// runProxyCommand dispatches the proxy subcommands
+func (app *Application) runProxyCommand(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("usage: claude-switch proxy <enable|disable|serve|route|unroute>")
+	}
+
+	switch args[0] {
+	case "enable":
+		fs := flag.NewFlagSet("proxy enable", flag.ContinueOnError)
+		port := fs.Int("port", defaultProxyPort, "Port to listen on")
+		if err := parseFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() > 0 || *port <= 0 || *port > 65535 {
+			return usageErrorf("usage: claude-switch proxy enable [--port <port>]")
+		}
+		return app.enableProxy(*port)
+	case "disable":
+		if len(args) > 1 {
+			return usageErrorf("usage: claude-switch proxy disable")
+		}
+		return app.disableProxy()
+	case "serve":
+		fs := flag.NewFlagSet("proxy serve", flag.ContinueOnError)
+		port := fs.Int("port", 0, "Port to listen on (default: the port of proxy enable)")
+		if err := parseFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() > 0 {
+			return usageErrorf("usage: claude-switch proxy serve [--port <port>]")
+		}
+		return app.serveProxy(*port)
+	case "route":
+		switch len(args) {
+		case 1:
+			return app.listProxyRoutes()
+		case 3:
+			return app.routeProxyTier(args[1], args[2])
+		default:
+			return usageErrorf("usage: claude-switch proxy route [<tier> <provider|profile:name>]")
+		}
+	case "unroute":
+		if len(args) != 2 {
+			return usageErrorf("usage: claude-switch proxy unroute <tier>")
+		}
+		return app.routeProxyTier(args[1], "")
+	default:
+		return usageErrorf("unknown proxy command %q (available: enable, disable, serve, route, unroute)", args[0])
+	}
+}
+
+// enableProxy points settings.json at the local proxy and makes the active
+// provider its upstream. The provider's settings are stashed like on a
+// switch, so the proxy and a later disable find them.
+func (app *Application) enableProxy(port int) error {
+	state, err := app.loadProxyState()
+	if err != nil {
+		return err
+	}
+	if state != nil && state.Enabled {
+		app.yellow.Printf("⚠️  The proxy is already enabled at %s (upstream %s)\n", state.URL(), state.Upstream)
+		return errAlreadyActive
+	}
+
+	upstream, err := app.currentTarget()
+	if err != nil {
+		return err
+	}
+
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	// Claude Code's own login stays inside Claude Code; requests through the
+	// proxy would reach the upstream without credentials
+	if config.Env["ANTHROPIC_AUTH_TOKEN"] == "" && config.Env["ANTHROPIC_API_KEY"] == "" {
+		return fmt.Errorf("%s has no token or API key for the proxy to forward; it cannot use Claude Code's own login (set ANTHROPIC_API_KEY or switch to a provider with a token first)", targetName(upstream))
+	}
+	if err := app.backupCurrentConfig(config, app.detectProvider(config)); err != nil {
+		return err
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("failed to generate proxy token: %w", err)
+	}
+	var routes map[string]string
+	if state != nil {
+		routes = state.Routes
+	}
+	state = &ProxyState{
+		Enabled:  true,
+		Port:     port,
+		Upstream: targetName(upstream),
+		Token:    "claude-switch-" + hex.EncodeToString(secret),
+		Routes:   routes,
+	}
+
+	clearProviderEnv(config)
+	config.Env["ANTHROPIC_BASE_URL"] = state.URL()
+	config.Env["ANTHROPIC_AUTH_TOKEN"] = state.Token
+	if err := app.saveConfigAtomic(app.settingsFile, config); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	if err := app.saveProxyState(state); err != nil {
+		return err
+	}
+
+	app.green.Printf("✅ settings.json now points at the proxy at %s\n", state.URL())
+	app.cyan.Printf("   Upstream: %s\n", state.describe())
+	fmt.Println()
+	app.cyan.Println("Start the proxy and keep it running:")
+	fmt.Println("  claude-switch proxy serve")
+	app.cyan.Println("Switch upstreams without restarting Claude Code:")
+	fmt.Println("  claude-switch use z_ai")
+	return nil
+}
+
+// disableProxy switches settings.json back to the proxy's upstream
+func (app *Application) disableProxy() error {
+	state, err := app.loadProxyState()
+	if err != nil {
+		return err
+	}
+	if state == nil || !state.Enabled {
+		app.yellow.Println("⚠️  The proxy is not enabled")
+		return nil
+	}
+
+	target, err := parseAutoTarget(state.Upstream)
+	if err != nil {
+		return err
+	}
+	// settings.json cannot point at an OpenAI upstream
+	if provider, ok := lookupProvider(app.targetProvider(target)); ok && requireAnthropicProtocol(provider) != nil {
+		return fmt.Errorf("the upstream %s speaks the OpenAI API; switch to another provider before disabling the proxy", state.Upstream)
+	}
+
+	state.Enabled = false
+	if err := app.saveProxyState(state); err != nil {
+		return err
+	}
+
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if config.Env["ANTHROPIC_BASE_URL"] == state.URL() {
+		clearProviderEnv(config)
+		if err := app.saveConfigAtomic(app.settingsFile, config); err != nil {
+			return fmt.Errorf("failed to save config: %w", err)
+		}
+	}
+	app.green.Printf("✅ Proxy disabled; switching settings.json to %s\n", state.Upstream)
+
+	if target.Profile != "" {
+		return app.useProfile(target.Profile)
+	}
+	provider, err := app.resolveProvider(target.Provider)
+	if err != nil {
+		return err
+	}
+	return app.switchTo(provider)
+}
+
+// listProxyRoutes prints where each model tier is forwarded
+func (app *Application) listProxyRoutes() error {
+	state, err := app.loadProxyState()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &ProxyState{}
+	}
+
+	upstream := state.Upstream
+	if upstream == "" {
+		upstream = "the upstream"
+	}
+	app.cyan.Println("Model tier routes:")
+	for _, tier := range proxyTiers {
+		if route := state.Routes[tier]; route != "" {
+			app.green.Printf("  %-8s → %s\n", tier, route)
+		} else {
+			fmt.Printf("  %-8s → %s\n", tier, upstream)
+		}
+	}
+	return nil
+}
+
+// routeProxyTier forwards a model tier to a provider or profile other than
+// the upstream, or back to the upstream when route is empty. Routes are kept
+// in proxy.json, so they can be set before the proxy is enabled.
+func (app *Application) routeProxyTier(tier, route string) error {
+	tier = strings.ToLower(tier)
+	if modelTier(tier) != tier {
+		return usageErrorf("unknown model tier %q (available: %s)", tier, strings.Join(proxyTiers, ", "))
+	}
+
+	state, err := app.loadProxyState()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &ProxyState{Port: defaultProxyPort}
+	}
+
+	if route == "" {
+		if state.Routes[tier] == "" {
+			app.yellow.Printf("⚠️  %s is not routed\n", tier)
+			return nil
+		}
+		delete(state.Routes, tier)
+		if err := app.saveProxyState(state); err != nil {
+			return err
+		}
+		app.green.Printf("✅ %s requests go to the upstream again\n", tier)
+		return nil
+	}
+
+	target, err := parseAutoTarget(route)
+	if err != nil {
+		return &usageError{msg: fmt.Sprintf("invalid route: %v", err)}
+	}
+	if err := app.checkProxyTarget(target); err != nil {
+		return err
+	}
+	name := targetName(target)
+	if state.Routes[tier] == name {
+		app.yellow.Printf("⚠️  %s requests already go to %s\n", tier, name)
+		return errAlreadyActive
+	}
+
+	// Resolve the route once, storing a token it prompts for
+	env, _, err := app.execEnv(strings.TrimPrefix(name, "profile:"), false)
+	if err != nil {
+		return err
+	}
+	if env["ANTHROPIC_AUTH_TOKEN"] == "" && env["ANTHROPIC_API_KEY"] == "" {
+		app.yellow.Printf("⚠️  %s has no token or API key; the proxy cannot use Claude Code's own login\n", name)
+	}
+
+	if state.Routes == nil {
+		state.Routes = map[string]string{}
+	}
+	state.Routes[tier] = name
+	if err := app.saveProxyState(state); err != nil {
+		return err
+	}
+	app.green.Printf("✅ %s requests now go to %s\n", tier, name)
+	if model := env["ANTHROPIC_DEFAULT_"+strings.ToUpper(tier)+"_MODEL"]; model != "" {
+		app.cyan.Printf("   Model: %s\n", model)
+	}
+	if !state.Enabled {
+		app.cyan.Println("   Takes effect once the proxy is enabled: claude-switch proxy enable")
+	}
+	return nil
+}
+
+// proxyUpstream is the resolved upstream of the proxy
+type proxyUpstream struct {
+	name     string
+	provider string // provider the env belongs to, for prices
+	base     *url.URL
+	env      map[string]string
+	protocol string
+}
+
+// mapModel replaces a Claude model name with the upstream's model for the
+// same tier. Names without a tier, or tiers the upstream does not map, are
+// kept.
+func (u *proxyUpstream) mapModel(model string) string {
+	if tier := modelTier(model); tier != "" {
+		if mapped := u.env["ANTHROPIC_DEFAULT_"+strings.ToUpper(tier)+"_MODEL"]; mapped != "" {
+			return mapped
+		}
+	}
+	return model
+}
+
+// proxyRoutes are the resolved upstream and per-tier routes of the proxy
+type proxyRoutes struct {
+	summary  string
+	upstream *proxyUpstream
+	tiers    map[string]*proxyUpstream
+}
+
+// forModel returns the upstream a model is forwarded to
+func (r *proxyRoutes) forModel(model string) *proxyUpstream {
+	if upstream := r.tiers[modelTier(model)]; upstream != nil {
+		return upstream
+	}
+	return r.upstream
+}
+
+// proxyServer forwards Anthropic Messages API requests to the upstream
+// selected in proxy.json
+type proxyServer struct {
+	app    *Application
+	token  string
+	proxy  *httputil.ReverseProxy
+	client *http.Client // sends translated requests to OpenAI upstreams
+
+	mu      sync.Mutex
+	modTime time.Time
+	routes  *proxyRoutes
+	budgets *budgetTracker
+	actions chan func() // budget hooks, see budgetAction
+
+	switchMu  sync.Mutex
+	switching map[string]bool // profiles being switched to their fallback
+	switches  sync.WaitGroup
+}
+
+// upstreamKey is the request context key of the resolved upstream
+type upstreamKey struct{}
+
+// serveProxy runs the proxy until interrupted
+func (app *Application) serveProxy(port int) error {
+	state, err := app.loadProxyState()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("the proxy is not configured; run 'claude-switch proxy enable' first")
+	}
+	if port == 0 {
+		port = state.Port
+	}
+
+	// Tokens are resolved without prompting while serving
+	app.nonInteractive = true
+
+	s, err := app.newProxyServer(state.Token)
+	if err != nil {
+		return err
+	}
+	s.actions = make(chan func(), 16)
+	go s.runBudgetActions()
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	server := &http.Server{Handler: s}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	app.logf(app.cyan, "🔀 Proxy listening on http://%s, forwarding to %s", listener.Addr(), s.routes.summary)
+	if !state.Enabled {
+		app.logf(app.yellow, "⚠️  settings.json does not point at the proxy; run 'claude-switch proxy enable'")
+	}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// Let the last requests finish and their budget actions run
+	<-stopped
+	s.drainBudgetActions()
+	app.logf(app.cyan, "👋 Proxy stopped")
+	return nil
+}
+
+// newProxyServer creates the handler forwarding requests authenticated with
+// token to the upstreams in proxy.json
+func (app *Application) newProxyServer(token string) (*proxyServer, error) {
+	s := &proxyServer{app: app, token: token, client: &http.Client{}}
+	s.proxy = &httputil.ReverseProxy{
+		Rewrite:       s.rewrite,
+		FlushInterval: -1, // stream SSE events as they arrive
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			writeAPIError(w, http.StatusBadGateway, "api_error", fmt.Sprintf("upstream request failed: %v", err))
+		},
+	}
+	if _, err := s.currentRoutes(); err != nil {
+		return nil, err
+	}
+	budgets, err := app.newBudgetTracker()
+	if err != nil {
+		return nil, err
+	}
+	s.budgets = budgets
+	return s, nil
+}
+
+// currentRoutes returns the upstream and routes of proxy.json, resolving
+// them again whenever the file changes
+func (s *proxyServer) currentRoutes() (*proxyRoutes, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.app.proxyFile)
+	if err != nil {
+		return nil, err
+	}
+	if s.routes != nil && info.ModTime().Equal(s.modTime) {
+		return s.routes, nil
+	}
+
+	state, err := s.app.loadProxyState()
+	if err != nil || state == nil {
+		return nil, fmt.Errorf("failed to read proxy state: %v", err)
+	}
+
+	// Tiers routed to the upstream share its resolution
+	resolved := map[string]*proxyUpstream{}
+	resolve := func(name string) (*proxyUpstream, error) {
+		if upstream := resolved[name]; upstream != nil {
+			return upstream, nil
+		}
+		upstream, err := s.app.resolveProxyUpstream(name)
+		if err != nil {
+			return nil, err
+		}
+		resolved[name] = upstream
+		return upstream, nil
+	}
+
+	routes := &proxyRoutes{summary: state.describe(), tiers: map[string]*proxyUpstream{}}
+	if routes.upstream, err = resolve(state.Upstream); err != nil {
+		return nil, err
+	}
+	for tier, name := range state.Routes {
+		if routes.tiers[tier], err = resolve(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.routes != nil && s.routes.summary != routes.summary {
+		s.app.logf(s.app.green, "🔀 Now forwarding to %s (was %s)", routes.summary, s.routes.summary)
+	}
+	s.routes = routes
+	s.modTime = info.ModTime()
+	return s.routes, nil
+}
+
+// resolveProxyUpstream resolves a provider or "profile:<name>" to forward to
+func (app *Application) resolveProxyUpstream(name string) (*proxyUpstream, error) {
+	target, err := parseAutoTarget(name)
+	if err != nil {
+		return nil, err
+	}
+	lookup := target.Provider
+	if target.Profile != "" {
+		lookup = target.Profile
+	}
+
+	// Runs on request goroutines; must not print or prompt
+	env, _, err := app.execEnv(lookup, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve upstream %s: %w", name, err)
+	}
+
+	baseURL := env["ANTHROPIC_BASE_URL"]
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL of %s: %w", name, err)
+	}
+	return &proxyUpstream{
+		name:     name,
+		provider: app.detectProvider(&Config{Env: env}),
+		base:     base,
+		env:      env,
+		protocol: providerProtocol(env),
+	}, nil
+}
+
+// ServeHTTP authenticates the request, maps its model and forwards it
+func (s *proxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeAPIError(w, http.StatusUnauthorized, "authentication_error", "invalid proxy token")
+		return
+	}
+
+	routes, err := s.currentRoutes()
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "api_error", err.Error())
+		return
+	}
+
+	// The requested model picks the upstream, which maps it to its own model
+	upstream := routes.upstream
+	var body []byte
+	model, mapped := "", ""
+	if r.Body != nil && r.Method == http.MethodPost {
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+			return
+		}
+		downgrade := false
+		if upstream, downgrade = s.applyBudget(w, r, routes, body); upstream == nil {
+			return
+		}
+		body, model, mapped = rewriteModel(body, func(model string) string {
+			if downgrade {
+				return upstream.downgradeModel(model)
+			}
+			return upstream.mapModel(model)
+		})
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	// Only Messages requests are billed
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/messages" {
+		rec.meter = &usageMeter{record: &UsageRecord{
+			Upstream:       upstream.name,
+			Provider:       upstream.provider,
+			Model:          mapped,
+			RequestedModel: model,
+			Project:        r.Header.Get(projectHeader),
+		}}
+	}
+	if upstream.protocol == ProtocolOpenAI {
+		s.forwardOpenAI(rec, r, upstream, body)
+	} else {
+		s.proxy.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), upstreamKey{}, upstream)))
+	}
+	latency := time.Since(start)
+
+	route := upstream.name
+	if model != "" {
+		route = fmt.Sprintf("%s → %s %s", model, upstream.name, mapped)
+	}
+	tokens := ""
+	if meter := rec.meter; meter != nil {
+		meter.finish()
+		record := meter.record
+		record.Time = start
+		record.Status = rec.status
+		record.LatencyMS = latency.Milliseconds()
+		record.Stream = meter.stream
+		if err := s.app.recordUsage(record); err != nil {
+			s.app.logf(s.app.yellow, "⚠️  Failed to record usage: %v", err)
+		}
+		// Budget alerts are logged after the request
+		defer s.checkBudget(record)
+		tokens = fmt.Sprintf(" %d in, %d out", record.InputTokens+record.CacheCreationTokens+record.CacheReadTokens, record.OutputTokens)
+	}
+	s.app.logf(s.app.cyan, "%s %s %s %d %s%s", r.Method, r.URL.Path, route, rec.status, latency.Round(time.Millisecond), tokens)
+}
+
+// authorized checks the placeholder token Claude Code sends
+func (s *proxyServer) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	return r.Header.Get("Authorization") == "Bearer "+s.token || r.Header.Get("X-Api-Key") == s.token
+}
+
+// rewrite points the outgoing request at the upstream with its credentials
+func (s *proxyServer) rewrite(pr *httputil.ProxyRequest) {
+	upstream := pr.In.Context().Value(upstreamKey{}).(*proxyUpstream)
+	pr.SetURL(upstream.base)
+
+	target := probeTargetFromEnv(upstream.env)
+	pr.Out.Header.Del("Authorization")
+	pr.Out.Header.Del("X-Api-Key")
+	pr.Out.Header.Del(projectHeader)
+	// Let the transport negotiate compression, so the usage of the
+	// response can be read
+	pr.Out.Header.Del("Accept-Encoding")
+	for name, values := range target.Headers {
+		pr.Out.Header[name] = values
+	}
+	if target.Token != "" {
+		pr.Out.Header.Set("Authorization", "Bearer "+target.Token)
+	}
+	if target.APIKey != "" {
+		pr.Out.Header.Set("X-Api-Key", target.APIKey)
+	}
+}
+
+// rewriteModel maps the model field of a JSON request body, leaving every
+// other field untouched. Bodies without a model are returned as is.
+func rewriteModel(body []byte, mapModel func(string) string) ([]byte, string, string) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return body, "", ""
+	}
+	var model string
+	if raw, ok := fields["model"]; !ok || json.Unmarshal(raw, &model) != nil {
+		return body, "", ""
+	}
+
+	mapped := mapModel(model)
+	if mapped == model {
+		return body, model, mapped
+	}
+	fields["model"], _ = json.Marshal(mapped)
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return body, model, model
+	}
+	return out, model, mapped
+}
+
+// writeAPIError writes an error in the Anthropic API format
+func writeAPIError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":  "error",
+		"error": map[string]string{"type": errType, "message": message},
+	})
+}
+
+// statusRecorder records the status code of a response and feeds the body
+// to the usage meter. Unwrap lets the reverse proxy flush streamed
+// responses through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	meter       *usageMeter
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+		r.status = status
+		if r.meter != nil {
+			r.meter.stream = strings.HasPrefix(r.Header().Get("Content-Type"), "text/event-stream")
+		}
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.meter != nil {
+		r.meter.write(p)
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAnthropic is a Messages API endpoint recording the requests it gets
+type fakeAnthropic struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	models   []string
+
+	// release, when set, holds a streamed response after its first event
+	release chan struct{}
+}
+
+func (f *fakeAnthropic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	f.models = append(f.models, request.Model)
+	f.mu.Unlock()
+
+	if !request.Stream {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"type":"message","model":"`+request.Model+`","usage":{"input_tokens":10,"output_tokens":5}}`)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	io.WriteString(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":10,\"output_tokens\":1}}}\n\n")
+	w.(http.Flusher).Flush()
+	if f.release != nil {
+		select {
+		case <-f.release:
+		case <-time.After(5 * time.Second):
+		}
+	}
+	io.WriteString(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":5}}\n\n")
+}
+
+// last returns the last request and the model it asked for
+func (f *fakeAnthropic) last(t *testing.T) (*http.Request, string) {
+	t.Helper()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		t.Fatal("the upstream got no request")
+	}
+	return f.requests[len(f.requests)-1], f.models[len(f.models)-1]
+}
+
+// count returns the number of requests the upstream got
+func (f *fakeAnthropic) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+// startTestProxy saves state to proxy.json and serves the proxy for it
+func startTestProxy(t *testing.T, app *Application, state *ProxyState) *httptest.Server {
+	t.Helper()
+
+	if err := app.saveProxyState(state); err != nil {
+		t.Fatal(err)
+	}
+	s, err := app.newProxyServer(state.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(func() {
+		server.Close()
+		s.drainBudgetActions()
+	})
+	return server
+}
+
+// postMessages sends a Messages API request with the given headers
+func postMessages(t *testing.T, url, body string, headers map[string]string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, url+"/v1/messages", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestProxyForwards(t *testing.T) {
+	app := newTestApp(t)
+	upstream := &fakeAnthropic{}
+	endpoint := httptest.NewServer(upstream)
+	defer endpoint.Close()
+	useTestProvider(t, "gateway", endpoint.URL)
+	proxy := startTestProxy(t, app, &ProxyState{Upstream: "gateway", Token: "proxy-token"})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{name: "bearer token", headers: map[string]string{"Authorization": "Bearer proxy-token"}, want: http.StatusOK},
+		{name: "api key", headers: map[string]string{"X-Api-Key": "proxy-token"}, want: http.StatusOK},
+		{name: "wrong token", headers: map[string]string{"Authorization": "Bearer sk-ant-leaked"}, want: http.StatusUnauthorized},
+		{name: "no token", headers: map[string]string{}, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := upstream.count()
+			tt.headers[projectHeader] = "/work/app"
+			resp := postMessages(t, proxy.URL, `{"model":"claude-sonnet-4-5","max_tokens":1}`, tt.headers)
+			if resp.StatusCode != tt.want {
+				body, _ := io.ReadAll(resp.Body)
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.want, body)
+			}
+
+			if tt.want != http.StatusOK {
+				if upstream.count() != before {
+					t.Error("an unauthorized request reached the upstream")
+				}
+				return
+			}
+			// The proxy token and project are replaced by the upstream's token
+			req, model := upstream.last(t)
+			if got := req.Header.Get("Authorization"); got != "Bearer sk-gateway" {
+				t.Errorf("Authorization = %q, want the upstream token", got)
+			}
+			if got := req.Header.Get("X-Api-Key"); got != "" {
+				t.Errorf("X-Api-Key = %q, want none", got)
+			}
+			if got := req.Header.Get(projectHeader); got != "" {
+				t.Errorf("%s = %q, want none", projectHeader, got)
+			}
+			if req.URL.Path != "/v1/messages" || model != "claude-sonnet-4-5" {
+				t.Errorf("upstream got %s for %q", req.URL.Path, model)
+			}
+		})
+	}
+
+	// Closing waits for the handlers recording the usage
+	proxy.Close()
+	records, err := app.loadUsage(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("recorded %d requests, want the 2 authorized ones", len(records))
+	}
+	if r := records[0]; r.Upstream != "gateway" || r.Project != "/work/app" || r.InputTokens != 10 || r.OutputTokens != 5 {
+		t.Errorf("usage record = %+v", r)
+	}
+}
+
+func TestProxyStreams(t *testing.T) {
+	app := newTestApp(t)
+	upstream := &fakeAnthropic{release: make(chan struct{})}
+	endpoint := httptest.NewServer(upstream)
+	defer endpoint.Close()
+	useTestProvider(t, "gateway", endpoint.URL)
+	proxy := startTestProxy(t, app, &ProxyState{Upstream: "gateway", Token: "proxy-token"})
+
+	resp := postMessages(t, proxy.URL, `{"model":"claude-sonnet-4-5","stream":true}`, map[string]string{"X-Api-Key": "proxy-token"})
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	// The first event arrives while the upstream still holds the stream
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "event: message_start\n" {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+	close(upstream.release)
+
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rest), "event: message_delta") {
+		t.Errorf("stream ended without the last event:\n%s", rest)
+	}
+}
+
+// useTestModels registers a provider like useTestProvider that maps the
+// model tiers to its own models
+func useTestModels(t *testing.T, name, baseURL string, models map[string]string) {
+	t.Helper()
+
+	useTestProvider(t, name, baseURL)
+	env := map[string]string{"ANTHROPIC_BASE_URL": baseURL}
+	for tier, model := range models {
+		env["ANTHROPIC_DEFAULT_"+strings.ToUpper(tier)+"_MODEL"] = model
+	}
+	registerProvider(&ProviderSpec{
+		ID:      name,
+		Display: name,
+		Env:     env,
+		Token:   TokenSource{EnvVar: "TEST_" + name + "_TOKEN"},
+	})
+}
+
+func TestProxyRoutesForModel(t *testing.T) {
+	upstream := &proxyUpstream{name: "main"}
+	fast := &proxyUpstream{name: "fast"}
+	routes := &proxyRoutes{upstream: upstream, tiers: map[string]*proxyUpstream{"haiku": fast}}
+
+	tests := []struct {
+		model string
+		want  *proxyUpstream
+	}{
+		{"claude-haiku-4-5", fast},
+		{"claude-3-5-Haiku-latest", fast},
+		{"claude-opus-4-1", upstream},
+		{"claude-sonnet-4-5", upstream},
+		{"glm-4.6", upstream},
+		{"", upstream},
+	}
+	for _, tt := range tests {
+		if got := routes.forModel(tt.model); got != tt.want {
+			t.Errorf("forModel(%q) = %s, want %s", tt.model, got.name, tt.want.name)
+		}
+	}
+}
+
+func TestProxyRoutesTiers(t *testing.T) {
+	app := newTestApp(t)
+	primary, fast := &fakeAnthropic{}, &fakeAnthropic{}
+	mainEndpoint := httptest.NewServer(primary)
+	defer mainEndpoint.Close()
+	fastEndpoint := httptest.NewServer(fast)
+	defer fastEndpoint.Close()
+	useTestModels(t, "main", mainEndpoint.URL, map[string]string{"opus": "main-large"})
+	useTestModels(t, "fast", fastEndpoint.URL, map[string]string{"haiku": "fast-small"})
+	proxy := startTestProxy(t, app, &ProxyState{Upstream: "main", Routes: map[string]string{"haiku": "fast"}})
+
+	tests := []struct {
+		model    string
+		upstream *fakeAnthropic
+		token    string
+		want     string // model the upstream gets
+	}{
+		{model: "claude-opus-4-1", upstream: primary, token: "Bearer sk-main", want: "main-large"},
+		{model: "claude-haiku-4-5", upstream: fast, token: "Bearer sk-fast", want: "fast-small"},
+		// main maps no sonnet model
+		{model: "claude-sonnet-4-5", upstream: primary, token: "Bearer sk-main", want: "claude-sonnet-4-5"},
+	}
+	for _, tt := range tests {
+		before := tt.upstream.count()
+		resp := postMessages(t, proxy.URL, `{"model":"`+tt.model+`","max_tokens":1}`, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status = %d", tt.model, resp.StatusCode)
+		}
+		if tt.upstream.count() != before+1 {
+			t.Errorf("%s went to the wrong upstream", tt.model)
+			continue
+		}
+		req, model := tt.upstream.last(t)
+		if got := req.Header.Get("Authorization"); got != tt.token || model != tt.want {
+			t.Errorf("%s forwarded as %q with %q, want %q with %q", tt.model, model, got, tt.want, tt.token)
+		}
+	}
+
+	// Removing the route sends haiku back to the upstream, which keeps the
+	// model it has no mapping for
+	if err := app.routeProxyTier("haiku", ""); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(app.proxyFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	before := fast.count()
+	postMessages(t, proxy.URL, `{"model":"claude-haiku-4-5","max_tokens":1}`, nil)
+	if _, model := primary.last(t); fast.count() != before || model != "claude-haiku-4-5" {
+		t.Errorf("unrouted haiku went to fast (%d requests) or was mapped to %q", fast.count()-before, model)
+	}
+
+	proxy.Close()
+	records, err := app.loadUsage(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("recorded %d requests, want 4", len(records))
+	}
+	if r := records[1]; r.Upstream != "fast" || r.RequestedModel != "claude-haiku-4-5" || r.Model != "fast-small" {
+		t.Errorf("routed usage record = %+v", r)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// errConflict is returned when settings were changed on disk in a way that
+// cannot be combined with the pending change
+var errConflict = errors.New("settings were changed by another process")
+
+// configOrigin records a settings file as it was when loaded, so a save can
+// detect edits made in the meantime by Claude Code or the user
+type configOrigin struct {
+	exists bool
+	hash   [sha256.Size]byte
+	env    map[string]string
+}
+
+// newConfigOrigin records the loaded content and env of a settings file
+func newConfigOrigin(data []byte, exists bool, env map[string]string) *configOrigin {
+	origin := &configOrigin{exists: exists, hash: sha256.Sum256(data), env: make(map[string]string, len(env))}
+	for key, value := range env {
+		origin.env[key] = value
+	}
+	return origin
+}
+
+// matches reports whether the file content is still the loaded one
+func (o *configOrigin) matches(data []byte, exists bool) bool {
+	return o.exists == exists && (!exists || o.hash == sha256.Sum256(data))
+}
+
+// envConflict is an env key changed both by this process and on disk
+type envConflict struct {
+	key    string
+	base   *string
+	ours   *string
+	theirs *string
+}
+
+// rebaseOnDisk re-reads a settings file before it is overwritten. If it
+// changed since config was loaded, the env changes made to config are
+// re-applied on top of the fresh content; conflicting edits abort the save.
+// It returns the on-disk state config now builds on, or nil for a config
+// that was not loaded from a file.
+func (app *Application) rebaseOnDisk(filename string, config *Config) (*configOrigin, error) {
+	if config.origin == nil {
+		return nil, nil
+	}
+
+	data, err := app.readFile(filename)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	disk := newConfigOrigin(data, exists, nil)
+	if config.origin.matches(data, exists) {
+		return disk, nil
+	}
+
+	fresh := newConfig()
+	if exists && len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, fresh); err != nil {
+			return nil, fmt.Errorf("%w: %s is no longer valid JSON: %v", errConflict, filename, err)
+		}
+		if fresh.Env == nil {
+			fresh.Env = make(map[string]string)
+		}
+	}
+
+	conflicts := rebaseEnv(config.origin.env, config.Env, fresh)
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("%w; nothing was written:\n%s", errConflict, formatConflicts(conflicts))
+	}
+
+	app.yellow.Printf("⚠️  %s changed since it was read; applying changes on top of it\n", filename)
+	origin := config.origin
+	*config = *fresh
+	config.origin = origin
+	return disk, nil
+}
+
+// checkOnDisk fails when a settings file no longer holds the content that
+// rebaseOnDisk saw, right before it is overwritten
+func (app *Application) checkOnDisk(filename string, disk *configOrigin) error {
+	if disk == nil {
+		return nil
+	}
+
+	data, err := app.readFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !disk.matches(data, err == nil) {
+		return fmt.Errorf("%w while saving; nothing was written, run the command again", errConflict)
+	}
+	return nil
+}
+
+// rebaseEnv applies the env changes between base and ours onto theirs and
+// returns the keys that were changed differently on both sides
+func rebaseEnv(base, ours map[string]string, theirs *Config) []envConflict {
+	keys := map[string]bool{}
+	for key := range base {
+		keys[key] = true
+	}
+	for key := range ours {
+		keys[key] = true
+	}
+
+	var conflicts []envConflict
+	for key := range keys {
+		b, inBase := base[key]
+		o, inOurs := ours[key]
+		if inBase == inOurs && b == o {
+			continue // not changed by us
+		}
+
+		t, inTheirs := theirs.Env[key]
+		unchanged := inTheirs == inBase && t == b
+		same := inTheirs == inOurs && t == o
+		if !unchanged && !same {
+			c := envConflict{key: key}
+			if inBase {
+				c.base = &b
+			}
+			if inOurs {
+				c.ours = &o
+			}
+			if inTheirs {
+				c.theirs = &t
+			}
+			conflicts = append(conflicts, c)
+			continue
+		}
+
+		if inOurs {
+			theirs.Env[key] = o
+		} else {
+			theirs.deleteEnv(key)
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].key < conflicts[j].key })
+	return conflicts
+}
+
+// formatConflicts renders conflicting keys with secrets masked
+func formatConflicts(conflicts []envConflict) string {
+	show := func(key string, value *string) string {
+		if value == nil {
+			return "(unset)"
+		}
+		if isSecretKey(key) {
+			return maskToken(*value)
+		}
+		return *value
+	}
+
+	var b strings.Builder
+	for _, c := range conflicts {
+		fmt.Fprintf(&b, "  %s\n", c.key)
+		fmt.Fprintf(&b, "    was:       %s\n", show(c.key, c.base))
+		fmt.Fprintf(&b, "    on disk:   %s\n", show(c.key, c.theirs))
+		fmt.Fprintf(&b, "    switching: %s\n", show(c.key, c.ours))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
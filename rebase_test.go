@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRebaseOnDisk(t *testing.T) {
+	tests := []struct {
+		name     string
+		loaded   string
+		edit     func(c *Config)
+		onDisk   string // written between load and save, "" for no change
+		want     map[string]string
+		wantKeys []string // other top-level keys expected after the save
+		conflict bool
+	}{
+		{
+			name:   "unchanged on disk",
+			loaded: `{"env":{"ANTHROPIC_AUTH_TOKEN":"a"}}`,
+			edit:   func(c *Config) { c.Env["ANTHROPIC_AUTH_TOKEN"] = "b" },
+			want:   map[string]string{"ANTHROPIC_AUTH_TOKEN": "b"},
+		},
+		{
+			name:     "unrelated edits are kept",
+			loaded:   `{"env":{"ANTHROPIC_AUTH_TOKEN":"a"}}`,
+			edit:     func(c *Config) { c.Env["ANTHROPIC_AUTH_TOKEN"] = "b" },
+			onDisk:   `{"env":{"ANTHROPIC_AUTH_TOKEN":"a","DEBUG":"1"},"model":"opus"}`,
+			want:     map[string]string{"ANTHROPIC_AUTH_TOKEN": "b", "DEBUG": "1"},
+			wantKeys: []string{"env", "model"},
+		},
+		{
+			name:   "removals are re-applied",
+			loaded: `{"env":{"ANTHROPIC_BASE_URL":"https://api.z.ai/api/anthropic","API_TIMEOUT_MS":"3000000"}}`,
+			edit: func(c *Config) {
+				c.deleteEnv("ANTHROPIC_BASE_URL")
+				c.deleteEnv("API_TIMEOUT_MS")
+			},
+			onDisk: `{"env":{"ANTHROPIC_BASE_URL":"https://api.z.ai/api/anthropic","API_TIMEOUT_MS":"3000000","DEBUG":"1"}}`,
+			want:   map[string]string{"DEBUG": "1"},
+		},
+		{
+			name:   "the same change on both sides",
+			loaded: `{"env":{"ANTHROPIC_AUTH_TOKEN":"a"}}`,
+			edit:   func(c *Config) { c.Env["ANTHROPIC_AUTH_TOKEN"] = "b" },
+			onDisk: `{"env":{"ANTHROPIC_AUTH_TOKEN":"b"}}`,
+			want:   map[string]string{"ANTHROPIC_AUTH_TOKEN": "b"},
+		},
+		{
+			name:     "conflicting changes",
+			loaded:   `{"env":{"ANTHROPIC_AUTH_TOKEN":"a"}}`,
+			edit:     func(c *Config) { c.Env["ANTHROPIC_AUTH_TOKEN"] = "b" },
+			onDisk:   `{"env":{"ANTHROPIC_AUTH_TOKEN":"c"}}`,
+			conflict: true,
+		},
+		{
+			name:     "removed on disk while changed here",
+			loaded:   `{"env":{"ANTHROPIC_AUTH_TOKEN":"a"}}`,
+			edit:     func(c *Config) { c.Env["ANTHROPIC_AUTH_TOKEN"] = "b" },
+			onDisk:   `{"env":{}}`,
+			conflict: true,
+		},
+		{
+			name:     "no longer valid JSON",
+			loaded:   `{"env":{"ANTHROPIC_AUTH_TOKEN":"a"}}`,
+			edit:     func(c *Config) { c.Env["ANTHROPIC_AUTH_TOKEN"] = "b" },
+			onDisk:   `{"env":`,
+			conflict: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			writeTestFile(t, app.settingsFile, tt.loaded)
+
+			config, err := app.loadConfig(app.settingsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.edit(config)
+			if tt.onDisk != "" {
+				writeTestFile(t, app.settingsFile, tt.onDisk)
+			}
+
+			err = app.saveConfigAtomic(app.settingsFile, config)
+			if tt.conflict {
+				if !errors.Is(err, errConflict) {
+					t.Fatalf("saveConfigAtomic() error = %v, want errConflict", err)
+				}
+				data, _ := app.readFile(app.settingsFile)
+				if string(data) != tt.onDisk {
+					t.Errorf("settings were overwritten on conflict: %s", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("saveConfigAtomic() error = %v", err)
+			}
+
+			saved, err := app.loadConfig(app.settingsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved.Env) != len(tt.want) {
+				t.Errorf("env = %v, want %v", saved.Env, tt.want)
+			}
+			for key, value := range tt.want {
+				if saved.Env[key] != value {
+					t.Errorf("env[%s] = %q, want %q", key, saved.Env[key], value)
+				}
+			}
+			if tt.wantKeys != nil && len(saved.keys) != len(tt.wantKeys) {
+				t.Errorf("top-level keys = %v, want %v", saved.keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestRebaseOnDiskRunsTwice(t *testing.T) {
+	app := newTestApp(t)
+	writeTestFile(t, app.settingsFile, `{"env":{"A":"1"}}`)
+
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Env["B"] = "2"
+	writeTestFile(t, app.settingsFile, `{"env":{"A":"1","C":"3"}}`)
+	if err := app.saveConfigAtomic(app.settingsFile, config); err != nil {
+		t.Fatal(err)
+	}
+
+	// The saved content is the new origin; a second save is not a conflict
+	config.Env["B"] = "4"
+	if err := app.saveConfigAtomic(app.settingsFile, config); err != nil {
+		t.Fatalf("second saveConfigAtomic() error = %v", err)
+	}
+	saved, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Env["A"] != "1" || saved.Env["B"] != "4" || saved.Env["C"] != "3" {
+		t.Errorf("env = %v", saved.Env)
+	}
+}
+
+func TestSaveKeepsEditsDuringSnapshot(t *testing.T) {
+	app := newTestApp(t)
+	useFakeKeyring(t)
+	t.Setenv("CLAUDE_SWITCH_SECRET_STORE", SecretStoreKeyring)
+	writeTestFile(t, app.settingsFile, `{"env":{"ANTHROPIC_AUTH_TOKEN":"sk-first"}}`)
+
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Env["ANTHROPIC_AUTH_TOKEN"] = "sk-second"
+
+	// Claude Code edits the settings while the snapshot stores the token
+	tool := filepath.Join(t.TempDir(), "secret-tool")
+	writeTestFile(t, tool, fmt.Sprintf("#!/bin/sh\n[ \"$1\" = store ] && printf '%%s' '%s' > '%s'\nexec '%s' \"$@\"\n",
+		`{"env":{"ANTHROPIC_AUTH_TOKEN":"sk-first","DEBUG":"1"}}`, app.settingsFile, os.Getenv("CLAUDE_SWITCH_SECRET_TOOL")))
+	if err := os.Chmod(tool, 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLAUDE_SWITCH_SECRET_TOOL", tool)
+
+	if err := app.saveConfigAtomic(app.settingsFile, config); err != nil {
+		t.Fatalf("saveConfigAtomic() error = %v", err)
+	}
+	saved, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Env["ANTHROPIC_AUTH_TOKEN"] != "sk-second" || saved.Env["DEBUG"] != "1" {
+		t.Errorf("env = %v, want the new token and the edit made during the snapshot", saved.Env)
+	}
+}
+
+func TestFormatConflictsMasksSecrets(t *testing.T) {
+	base, ours, theirs := "sk-ant-api-original", "sk-ant-api-switched", "https://api.z.ai/api/anthropic"
+	out := formatConflicts([]envConflict{
+		{key: "ANTHROPIC_API_KEY", base: &base, ours: &ours},
+		{key: "ANTHROPIC_AUTH_TOKEN", ours: &ours},
+		{key: "ANTHROPIC_BASE_URL", theirs: &theirs},
+	})
+
+	for _, secret := range []string{base, ours} {
+		if strings.Contains(out, secret) {
+			t.Errorf("conflicts show %q in clear:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, theirs) {
+		t.Errorf("conflicts hide the base URL:\n%s", out)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Settings scopes, from lowest to highest precedence
+const (
+	ScopeUser    = "user"
+	ScopeProject = "project"
+	ScopeLocal   = "local"
+)
+
+// scopeNames lists the scopes in the order Claude Code merges them
+var scopeNames = []string{ScopeUser, ScopeProject, ScopeLocal}
+
+// SettingsLayer is one settings file taking part in the merged configuration
+type SettingsLayer struct {
+	Scope    string `json:"scope"`
+	File     string `json:"file"`
+	Exists   bool   `json:"exists"`
+	Provider string `json:"provider"`
+}
+
+// MergedKey is an env key of the merged configuration and the layer that set it
+type MergedKey struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Scope string `json:"scope"`
+}
+
+// MergedStatus is the effective configuration of the current directory
+type MergedStatus struct {
+	SchemaVersion int              `json:"schema_version"`
+	Provider      string           `json:"provider"`
+	DisplayName   string           `json:"display_name"`
+	ProjectRoot   string           `json:"project_root"`
+	Layers        []*SettingsLayer `json:"layers"`
+	Keys          []MergedKey      `json:"keys"`
+}
+
+// findProjectRoot returns the nearest directory above the working directory
+// that contains a .claude directory or a .git entry, or the working
+// directory itself. The home directory is skipped since ~/.claude holds the
+// user settings.
+func findProjectRoot() (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+	homeDir, _ := os.UserHomeDir()
+
+	for dir := cwd; ; dir = filepath.Dir(dir) {
+		if dir != homeDir {
+			for _, marker := range []string{".claude", ".git"} {
+				if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+					return dir, nil
+				}
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return cwd, nil
+		}
+	}
+}
+
+// scopeFile returns the settings file of a scope
+func (app *Application) scopeFile(scope string) (string, error) {
+	switch scope {
+	case "", ScopeUser:
+		return app.settingsFile, nil
+	case ScopeProject, ScopeLocal:
+		root, err := findProjectRoot()
+		if err != nil {
+			return "", err
+		}
+		name := "settings.json"
+		if scope == ScopeLocal {
+			name = "settings.local.json"
+		}
+		filename := filepath.Join(root, ".claude", name)
+		if filepath.Dir(filename) == filepath.Dir(app.settingsFile) {
+			return "", fmt.Errorf("no project found; run from inside a project directory to use the %s scope", scope)
+		}
+		return filename, nil
+	default:
+		return "", usageErrorf("unknown scope %q (available: %s)", scope, strings.Join(scopeNames, ", "))
+	}
+}
+
+// switchScopeTo writes a provider into a project or local settings file.
+// Only provider keys are touched, so the layer overrides the user settings
+// for the provider and inherits everything else. Per-provider stashes are
+// kept for the user scope only. A token goes into the usually committed
+// project settings only when allowProjectToken is set.
+func (app *Application) switchScopeTo(provider Provider, scope string, allowProjectToken bool) error {
+	if scope == ScopeProject && !provider.TokenSource().FromBackup && !allowProjectToken {
+		return usageErrorf("refusing to store the %s token in .claude/settings.json, which is usually committed; use --scope local for the git-ignored settings.local.json, or pass --allow-project-token", provider.DisplayName())
+	}
+
+	filename, err := app.scopeFile(scope)
+	if err != nil {
+		return err
+	}
+
+	app.green.Printf("🔄 Switching %s scope to %s API...\n", scope, provider.DisplayName())
+	app.cyan.Printf("   %s\n", filename)
+
+	config, err := app.loadConfig(filename)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if len(config.Env) > 0 && app.detectProvider(config) == provider.Name() {
+		app.yellow.Printf("⚠️  %s scope already uses %s configuration\n", scope, provider.DisplayName())
+		return errAlreadyActive
+	}
+
+	clearProviderEnv(config)
+
+	if provider.TokenSource().FromBackup {
+		// The web login token lives in the user settings; dropping the
+		// layer's provider keys lets it through
+		if err := app.saveConfigAtomic(filename, config); err != nil {
+			return fmt.Errorf("failed to save config: %w", err)
+		}
+		app.green.Printf("✅ Removed provider settings from %s scope\n", scope)
+
+		merged, err := app.mergedStatus()
+		if err == nil && merged.Provider != provider.Name() && merged.Provider != ProviderUnknown {
+			app.yellow.Printf("⚠️  Effective provider is still %s (set by another scope)\n", merged.DisplayName)
+		}
+		return nil
+	}
+
+	token, err := app.promptForToken(provider)
+	if err != nil {
+		return err
+	}
+	app.validateTokenForProvider(token, provider)
+
+	setProviderEnv(config, provider.DefaultEnv())
+	config.Env["ANTHROPIC_AUTH_TOKEN"] = token
+
+	if err := app.saveConfigAtomic(filename, config); err != nil {
+		return fmt.Errorf("failed to save %s configuration: %w", provider.DisplayName(), err)
+	}
+
+	app.green.Printf("✅ %s configuration applied to %s scope\n", provider.DisplayName(), scope)
+	if scope == ScopeProject {
+		app.yellow.Println("⚠️  The token is stored in .claude/settings.json; do not commit it")
+	}
+	return nil
+}
+
+// mergedStatus merges the user, project and local settings the way Claude
+// Code does, recording which layer set each env key. Outside a project only
+// the user layer is shown.
+func (app *Application) mergedStatus() (*MergedStatus, error) {
+	status := &MergedStatus{
+		SchemaVersion: statusSchemaVersion,
+		Layers:        []*SettingsLayer{},
+		Keys:          []MergedKey{},
+	}
+
+	merged := newConfig()
+	setBy := map[string]string{}
+	for _, scope := range scopeNames {
+		filename, err := app.scopeFile(scope)
+		if err != nil {
+			continue
+		}
+		if scope != ScopeUser {
+			status.ProjectRoot = filepath.Dir(filepath.Dir(filename))
+		}
+
+		layer := &SettingsLayer{Scope: scope, File: filename, Provider: ProviderUnknown}
+		if _, err := os.Stat(filename); err == nil {
+			layer.Exists = true
+		}
+		config, err := app.loadConfig(filename)
+		if err != nil {
+			return nil, err
+		}
+		layer.Provider = app.detectProvider(config)
+		status.Layers = append(status.Layers, layer)
+
+		for key, value := range config.Env {
+			merged.Env[key] = value
+			setBy[key] = scope
+		}
+	}
+
+	status.Provider = app.detectProvider(merged)
+	status.DisplayName = app.providerLabel(status.Provider)
+	if status.Provider == ProviderUnknown {
+		status.DisplayName = "None"
+	}
+
+	keys := make([]string, 0, len(merged.Env))
+	for key := range merged.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := merged.Env[key]
+		if isSecretKey(key) {
+			value = maskToken(value)
+		}
+		status.Keys = append(status.Keys, MergedKey{Key: key, Value: value, Scope: setBy[key]})
+	}
+
+	return status, nil
+}
+
+// showMergedStatus prints the effective configuration and the layer of each key
+func (app *Application) showMergedStatus(status *MergedStatus) {
+	app.cyan.Println("📊 Effective Configuration")
+	fmt.Println()
+
+	app.green.Println("┌─────────────────────────────────────┐")
+	app.green.Printf("│  🔗 Provider: %-22s│\n", status.DisplayName)
+	app.green.Println("└─────────────────────────────────────┘")
+	fmt.Println()
+
+	if status.ProjectRoot == "" {
+		app.cyan.Println("  Project: none")
+	} else {
+		app.cyan.Printf("  Project: %s\n", status.ProjectRoot)
+	}
+	for _, layer := range status.Layers {
+		if !layer.Exists {
+			fmt.Printf("  %-8s %s (not found)\n", layer.Scope, layer.File)
+			continue
+		}
+		label := app.providerLabel(layer.Provider)
+		if layer.Provider == ProviderUnknown {
+			label = "no env"
+		}
+		fmt.Printf("  %-8s %s (%s)\n", layer.Scope, layer.File, label)
+	}
+
+	if len(status.Keys) == 0 {
+		return
+	}
+
+	fmt.Println()
+	for _, key := range status.Keys {
+		fmt.Printf("  [%-7s] %s=%s\n", key.Scope, key.Key, key.Value)
+	}
+}
+
+// effectiveEnv merges the env of the user, project and local settings.
+// Scopes without a project are skipped.
+func (app *Application) effectiveEnv() (map[string]string, error) {
+	env := map[string]string{}
+	for _, scope := range scopeNames {
+		filename, err := app.scopeFile(scope)
+		if err != nil {
+			continue
+		}
+		config, err := app.loadConfig(filename)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range config.Env {
+			env[key] = value
+		}
+	}
+	return env, nil
+}
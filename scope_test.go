@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScopeFile(t *testing.T) {
+	app := newTestApp(t)
+	project := filepath.Join(t.TempDir(), "project")
+	writeTestFile(t, filepath.Join(project, ".git", "HEAD"), "ref: refs/heads/main\n")
+	nested := filepath.Join(project, "cmd", "tool")
+	if err := os.MkdirAll(nested, 0700); err != nil {
+		t.Fatal(err)
+	}
+	home, _ := os.UserHomeDir()
+
+	tests := []struct {
+		name    string
+		dir     string
+		scope   string
+		want    string
+		wantErr bool
+	}{
+		{name: "user", dir: nested, scope: ScopeUser, want: app.settingsFile},
+		{name: "default", dir: nested, scope: "", want: app.settingsFile},
+		{name: "project", dir: nested, scope: ScopeProject, want: filepath.Join(project, ".claude", "settings.json")},
+		{name: "local", dir: project, scope: ScopeLocal, want: filepath.Join(project, ".claude", "settings.local.json")},
+		{name: "project from home", dir: home, scope: ScopeProject, wantErr: true},
+		{name: "unknown", dir: project, scope: "team", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTest(t, tt.dir)
+			got, err := app.scopeFile(tt.scope)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scopeFile(%q) error = %v, wantErr %v", tt.scope, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("scopeFile(%q) = %q, want %q", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergedStatus(t *testing.T) {
+	app := newTestApp(t)
+	writeTestFile(t, app.settingsFile, `{"env":{"ANTHROPIC_BASE_URL":"https://api.z.ai/api/anthropic","ANTHROPIC_AUTH_TOKEN":"sk-user-token-1234","DEBUG":"1"}}`)
+	project := filepath.Join(t.TempDir(), "project")
+	writeTestFile(t, filepath.Join(project, ".claude", "settings.json"), `{"env":{"ANTHROPIC_BASE_URL":"https://llm.example.com","DEBUG":"2"}}`)
+	writeTestFile(t, filepath.Join(project, ".claude", "settings.local.json"), `{"env":{"ANTHROPIC_API_KEY":"sk-ant-local-key-5678"}}`)
+	home, _ := os.UserHomeDir()
+
+	tests := []struct {
+		name     string
+		dir      string
+		wantRoot string
+		want     []MergedKey
+	}{
+		{
+			name:     "project",
+			dir:      project,
+			wantRoot: project,
+			want: []MergedKey{
+				{Key: "ANTHROPIC_API_KEY", Value: "sk-a...5678", Scope: ScopeLocal},
+				{Key: "ANTHROPIC_AUTH_TOKEN", Value: "sk-u...1234", Scope: ScopeUser},
+				{Key: "ANTHROPIC_BASE_URL", Value: "https://llm.example.com", Scope: ScopeProject},
+				{Key: "DEBUG", Value: "2", Scope: ScopeProject},
+			},
+		},
+		{
+			name: "home",
+			dir:  home,
+			want: []MergedKey{
+				{Key: "ANTHROPIC_AUTH_TOKEN", Value: "sk-u...1234", Scope: ScopeUser},
+				{Key: "ANTHROPIC_BASE_URL", Value: "https://api.z.ai/api/anthropic", Scope: ScopeUser},
+				{Key: "DEBUG", Value: "1", Scope: ScopeUser},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTest(t, tt.dir)
+			status, err := app.mergedStatus()
+			if err != nil {
+				t.Fatalf("mergedStatus() error = %v", err)
+			}
+			if status.ProjectRoot != tt.wantRoot {
+				t.Errorf("ProjectRoot = %q, want %q", status.ProjectRoot, tt.wantRoot)
+			}
+			if !reflect.DeepEqual(status.Keys, tt.want) {
+				t.Errorf("Keys = %+v, want %+v", status.Keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestSwitchScopeProjectToken(t *testing.T) {
+	app := newTestApp(t)
+	t.Setenv("Z_AI_AUTH_TOKEN", "sk-zai")
+	project := filepath.Join(t.TempDir(), "project")
+	writeTestFile(t, filepath.Join(project, ".git", "HEAD"), "ref: refs/heads/main\n")
+	chdirTest(t, project)
+	provider, err := app.resolveProvider(ProviderZAI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(project, ".claude", "settings.json")
+
+	var usageErr *usageError
+	if err := app.switchScopeTo(provider, ScopeProject, false); !errors.As(err, &usageErr) {
+		t.Fatalf("switchScopeTo() error = %v, want a usage error", err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("project settings were written without --allow-project-token: %v", err)
+	}
+
+	if err := app.switchScopeTo(provider, ScopeProject, true); err != nil {
+		t.Fatalf("switchScopeTo() with the opt-in error = %v", err)
+	}
+	config, err := app.loadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Env["ANTHROPIC_AUTH_TOKEN"] != "sk-zai" {
+		t.Errorf("project env = %v", config.Env)
+	}
+}
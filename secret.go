@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"golang.org/x/term"
+)
+
+// Secret store backends
+const (
+	SecretStoreFile    = "file"
+	SecretStoreKeyring = "keyring"
+	SecretStoreVault   = "vault"
+)
+
+// errSecretNotFound is returned when a secret does not exist in a store
+var errSecretNotFound = errors.New("secret not found")
+
+// SecretStore keeps API tokens outside of the plaintext settings files.
+// Keys are namespaced: "provider/<name>", "profile/<name>" and "backup/<provider>".
+type SecretStore interface {
+	Name() string
+	Get(key string) (string, error)
+	Set(key, secret string) error
+	Delete(key string) error
+}
+
+// secretStoreNames lists the available backends
+var secretStoreNames = []string{SecretStoreFile, SecretStoreKeyring, SecretStoreVault}
+
+// secretStore returns the backend with the given name. An empty name selects
+// the default from CLAUDE_SWITCH_SECRET_STORE, falling back to plaintext files.
+func (app *Application) secretStore(name string) (SecretStore, error) {
+	store, err := app.openSecretStore(name)
+	if err != nil || app.plan == nil {
+		return store, err
+	}
+	return &plannedSecretStore{SecretStore: store, plan: app.plan}, nil
+}
+
+// openSecretStore returns the backend with the given name
+func (app *Application) openSecretStore(name string) (SecretStore, error) {
+	if name == "" {
+		name = app.defaultSecretStore()
+	}
+
+	switch name {
+	case SecretStoreFile:
+		return &fileSecretStore{resolve: app.secretFilePath}, nil
+	case SecretStoreKeyring:
+		command := os.Getenv("CLAUDE_SWITCH_SECRET_TOOL")
+		if command == "" {
+			command = "secret-tool"
+		}
+		return &keyringSecretStore{command: command, service: "claude-switch"}, nil
+	case SecretStoreVault:
+		return &vaultSecretStore{path: app.vaultFile, passphrase: app.vaultPassphrase}, nil
+	default:
+		return nil, fmt.Errorf("unknown secret store %q (available: %s)", name, strings.Join(secretStoreNames, ", "))
+	}
+}
+
+// tokenStores returns the default secret store followed by the plaintext file
+// store, which always holds tokens saved before a secret store was selected
+func (app *Application) tokenStores() ([]SecretStore, error) {
+	store, err := app.secretStore("")
+	if err != nil {
+		return nil, err
+	}
+	stores := []SecretStore{store}
+	if store.Name() != SecretStoreFile {
+		stores = append(stores, &fileSecretStore{resolve: app.secretFilePath})
+	}
+	return stores, nil
+}
+
+// defaultSecretStore returns the secret store selected for this machine
+func (app *Application) defaultSecretStore() string {
+	if name := os.Getenv("CLAUDE_SWITCH_SECRET_STORE"); name != "" {
+		return name
+	}
+	return SecretStoreFile
+}
+
+// secretFilePath maps a secret key to a plaintext file. Provider tokens keep
+// their historical token file location.
+func (app *Application) secretFilePath(key string) string {
+	if name, ok := strings.CutPrefix(key, "provider/"); ok {
+		if provider, found := lookupProvider(name); found {
+			if path := app.tokenFilePath(provider.TokenSource()); path != "" {
+				return path
+			}
+		}
+	}
+	return filepath.Join(app.configDir, "secrets", strings.ReplaceAll(key, "/", "-"))
+}
+
+// vaultPassphrase returns the vault passphrase from CLAUDE_SWITCH_VAULT_PASSPHRASE
+// or asks for it once per run
+func (app *Application) vaultPassphrase() (string, error) {
+	if app.passphrase != "" {
+		return app.passphrase, nil
+	}
+
+	if passphrase := os.Getenv("CLAUDE_SWITCH_VAULT_PASSPHRASE"); passphrase != "" {
+		app.passphrase = passphrase
+		return passphrase, nil
+	}
+
+	if app.nonInteractive {
+		return "", fmt.Errorf("vault is locked; set CLAUDE_SWITCH_VAULT_PASSPHRASE")
+	}
+
+	app.cyan.Fprintln(os.Stderr, "Please enter the vault passphrase:")
+	fmt.Fprint(os.Stderr, "> ")
+
+	// Read without echo from a terminal; piped input is read as a line
+	var passphrase string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		data, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		passphrase = string(data)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		passphrase = strings.TrimRight(line, "\r\n")
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase cannot be empty")
+	}
+
+	app.passphrase = passphrase
+	return passphrase, nil
+}
+
+// fileSecretStore keeps each secret in its own 0600 plaintext file
+type fileSecretStore struct {
+	resolve func(key string) string
+}
+
+func (s *fileSecretStore) Name() string { return SecretStoreFile }
+
+func (s *fileSecretStore) Get(key string) (string, error) {
+	data, err := os.ReadFile(s.resolve(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errSecretNotFound
+		}
+		return "", err
+	}
+
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", errSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s *fileSecretStore) Set(key, secret string) error {
+	path := s.resolve(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return writeFileAtomic(path, []byte(secret), 0600)
+}
+
+func (s *fileSecretStore) Delete(key string) error {
+	err := os.Remove(s.resolve(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// keyringSecretStore uses the freedesktop Secret Service through the
+// libsecret secret-tool command, which talks to whatever service owns
+// org.freedesktop.secrets on the session bus
+type keyringSecretStore struct {
+	command string
+	service string
+}
+
+func (s *keyringSecretStore) Name() string { return SecretStoreKeyring }
+
+func (s *keyringSecretStore) run(stdin string, args ...string) (string, error) {
+	cmd := exec.Command(s.command, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// secret-tool lookup exits 1 without a message for a missing secret
+		var exitErr *exec.ExitError
+		if args[0] == "lookup" && errors.As(err, &exitErr) && stderr.Len() == 0 {
+			return "", errSecretNotFound
+		}
+		return "", fmt.Errorf("%s %s failed: %w: %s", s.command, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+func (s *keyringSecretStore) Get(key string) (string, error) {
+	out, err := s.run("", "lookup", "service", s.service, "key", key)
+	if err != nil {
+		return "", err
+	}
+
+	secret := strings.TrimRight(out, "\r\n")
+	if secret == "" {
+		return "", errSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s *keyringSecretStore) Set(key, secret string) error {
+	_, err := s.run(secret, "store", "--label", "claude-switch: "+key, "service", s.service, "key", key)
+	return err
+}
+
+func (s *keyringSecretStore) Delete(key string) error {
+	// secret-tool clear fails silently for a missing secret, which would
+	// hide a real failure, so look the secret up first
+	if _, err := s.Get(key); err != nil {
+		if errors.Is(err, errSecretNotFound) {
+			return nil
+		}
+		return err
+	}
+	_, err := s.run("", "clear", "service", s.service, "key", key)
+	return err
+}
+
+// vaultSecretStore keeps all secrets in a single age passphrase-encrypted file
+type vaultSecretStore struct {
+	path       string
+	passphrase func() (string, error)
+}
+
+func (s *vaultSecretStore) Name() string { return SecretStoreVault }
+
+// load decrypts the vault into a key/secret map
+func (s *vaultSecretStore) load() (map[string]string, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+
+	passphrase, err := s.passphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(data), identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt vault: %w", err)
+	}
+
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt vault: %w", err)
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse vault: %w", err)
+	}
+	return secrets, nil
+}
+
+// save encrypts the key/secret map and writes it atomically
+func (s *vaultSecretStore) save(secrets map[string]string) error {
+	passphrase, err := s.passphrase()
+	if err != nil {
+		return err
+	}
+
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return err
+	}
+
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal vault: %w", err)
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipient)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt vault: %w", err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		return fmt.Errorf("failed to encrypt vault: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt vault: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := writeFileAtomic(s.path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to save vault: %w", err)
+	}
+	return nil
+}
+
+func (s *vaultSecretStore) Get(key string) (string, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[key]
+	if !ok || secret == "" {
+		return "", errSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s *vaultSecretStore) Set(key, secret string) error {
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	secrets[key] = secret
+	return s.save(secrets)
+}
+
+func (s *vaultSecretStore) Delete(key string) error {
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[key]; !ok {
+		return nil
+	}
+	delete(secrets, key)
+	return s.save(secrets)
+}
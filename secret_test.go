@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeSecretTool is a secret-tool stand-in keeping each secret in a file of
+// $FAKE_KEYRING. Like secret-tool, lookup and clear of a missing secret exit
+// 1 without output. Setting $FAKE_KEYRING_LOCKED makes store fail.
+const fakeSecretTool = `#!/bin/sh
+cmd=$1; shift
+[ "$cmd" = store ] && shift 2
+[ "$1" = service ] && [ "$3" = key ] || { echo "bad attributes: $*" >&2; exit 2; }
+file="$FAKE_KEYRING/$2-$(printf '%s' "$4" | tr / _)"
+case $cmd in
+store) [ -z "$FAKE_KEYRING_LOCKED" ] || { echo "Cannot unlock the collection" >&2; exit 1; }; cat > "$file" ;;
+lookup) [ -f "$file" ] || exit 1; cat "$file" ;;
+clear) [ -f "$file" ] || exit 1; rm "$file" ;;
+*) echo "unknown command $cmd" >&2; exit 2 ;;
+esac
+`
+
+// useFakeKeyring points the keyring store at fakeSecretTool
+func useFakeKeyring(t *testing.T) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake secret-tool is a shell script")
+	}
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "secret-tool")
+	writeTestFile(t, tool, fakeSecretTool)
+	if err := os.Chmod(tool, 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLAUDE_SWITCH_SECRET_TOOL", tool)
+	t.Setenv("FAKE_KEYRING", dir)
+}
+
+// testSecretStores returns each secret store backend, the keyring backed by
+// fakeSecretTool
+func testSecretStores(t *testing.T) map[string]SecretStore {
+	t.Helper()
+
+	app := newTestApp(t)
+	app.passphrase = "correct horse"
+	useFakeKeyring(t)
+
+	stores := map[string]SecretStore{}
+	for _, name := range secretStoreNames {
+
+		store, err := app.secretStore(name)
+		if err != nil {
+			t.Fatalf("secretStore(%q) error = %v", name, err)
+		}
+		stores[name] = store
+	}
+	return stores
+}
+
+func TestSecretStores(t *testing.T) {
+	for name, store := range testSecretStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if store.Name() != name {
+				t.Errorf("Name() = %q, want %q", store.Name(), name)
+			}
+
+			if _, err := store.Get("profile/work"); !errors.Is(err, errSecretNotFound) {
+				t.Fatalf("Get() of a missing secret error = %v, want errSecretNotFound", err)
+			}
+
+			if err := store.Set("profile/work", "sk-first"); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := store.Set("backup/anthropic", "web-token"); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := store.Set("profile/work", "sk-second"); err != nil {
+				t.Fatalf("Set() to replace error = %v", err)
+			}
+
+			tests := []struct {
+				key  string
+				want string
+			}{
+				{"profile/work", "sk-second"},
+				{"backup/anthropic", "web-token"},
+			}
+			for _, tt := range tests {
+				got, err := store.Get(tt.key)
+				if err != nil || got != tt.want {
+					t.Errorf("Get(%q) = %q, %v; want %q", tt.key, got, err, tt.want)
+				}
+			}
+
+			if err := store.Delete("profile/work"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if err := store.Delete("profile/work"); err != nil {
+				t.Fatalf("Delete() of a missing secret error = %v", err)
+			}
+			if _, err := store.Get("profile/work"); !errors.Is(err, errSecretNotFound) {
+				t.Errorf("Get() after Delete() error = %v, want errSecretNotFound", err)
+			}
+			if got, err := store.Get("backup/anthropic"); err != nil || got != "web-token" {
+				t.Errorf("Get() of the other secret = %q, %v; want %q", got, err, "web-token")
+			}
+		})
+	}
+}
+
+func TestKeyringSecretStoreFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake secret-tool is a shell script")
+	}
+
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "secret-tool")
+	writeTestFile(t, tool, "#!/bin/sh\necho 'Cannot autolaunch D-Bus without X11 $DISPLAY' >&2\nexit 1\n")
+	if err := os.Chmod(tool, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &keyringSecretStore{command: tool, service: "claude-switch"}
+	if _, err := store.Get("profile/work"); err == nil || errors.Is(err, errSecretNotFound) {
+		t.Errorf("Get() error = %v, want the secret-tool failure", err)
+	}
+	if err := store.Delete("profile/work"); err == nil {
+		t.Error("Delete() error = nil, want the secret-tool failure")
+	}
+
+	// A store that fails without a message is not a missing secret
+	writeTestFile(t, tool, "#!/bin/sh\nexit 1\n")
+	if err := store.Set("profile/work", "sk-work"); err == nil || errors.Is(err, errSecretNotFound) {
+		t.Errorf("Set() error = %v, want the secret-tool failure", err)
+	}
+}
+
+func TestVaultSecretStoreWrongPassphrase(t *testing.T) {
+	app := newTestApp(t)
+	app.passphrase = "right"
+
+	store, err := app.secretStore(SecretStoreVault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("profile/work", "sk-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	app.passphrase = "wrong"
+	if _, err := store.Get("profile/work"); err == nil || errors.Is(err, errSecretNotFound) {
+		t.Errorf("Get() with the wrong passphrase error = %v, want a decryption error", err)
+	}
+}
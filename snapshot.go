@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotTimeFormat is used for snapshot IDs so that they sort chronologically
+const snapshotTimeFormat = "20060102-150405.000"
+
+// defaultSnapshotLimit is the number of snapshots kept unless
+// CLAUDE_SWITCH_BACKUP_LIMIT says otherwise; older ones are pruned
+const defaultSnapshotLimit = 100
+
+// snapshotSecretKeys are the env keys kept out of snapshots while a secret
+// store is selected
+var snapshotSecretKeys = []string{"ANTHROPIC_AUTH_TOKEN", "ANTHROPIC_API_KEY"}
+
+// Snapshot is a versioned copy of a settings file kept in the backup directory
+type Snapshot struct {
+	Metadata BackupMetadata  `json:"_metadata"`
+	Settings json.RawMessage `json:"settings"`
+}
+
+// snapshotFile returns the path of a snapshot by ID
+func (app *Application) snapshotFile(id string) string {
+	return filepath.Join(app.backupDir, id+".json")
+}
+
+// takeSnapshot stores the current content of a settings file in the backup
+// directory. Missing files and content identical to the latest snapshot are
+// skipped.
+func (app *Application) takeSnapshot(filename string) (*Snapshot, error) {
+	data, err := app.readFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return nil, fmt.Errorf("current settings are not valid JSON: %w", err)
+	}
+
+	settings, tokens, err := app.stripSnapshotSecrets(compact.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	secrets := snapshotSecretRefs(tokens)
+
+	snapshots, err := app.listSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Metadata.File != filename {
+			continue
+		}
+		var latest bytes.Buffer
+		if json.Compact(&latest, snapshots[i].Settings) == nil && bytes.Equal(latest.Bytes(), settings) &&
+			sameSecrets(snapshots[i].Metadata.Secrets, secrets) {
+			return nil, nil
+		}
+		break
+	}
+
+	provider := ProviderUnknown
+	var config Config
+	if json.Unmarshal(data, &config) == nil {
+		provider = app.detectProvider(&config)
+	}
+
+	now := time.Now()
+	snapshot := &Snapshot{
+		Metadata: BackupMetadata{
+			ID:        app.newSnapshotID(now, provider),
+			Provider:  provider,
+			CreatedAt: now.Format(time.RFC3339),
+			Version:   Version,
+			File:      filename,
+		},
+		Settings: json.RawMessage(settings),
+	}
+
+	// Keep tokens in the secret store, like the provider backups
+	if len(secrets) > 0 {
+		store, err := app.secretStore("")
+		if err != nil {
+			return nil, err
+		}
+		for envKey, key := range secrets {
+			if err := store.Set(key, tokens[envKey]); err != nil {
+				return nil, fmt.Errorf("failed to save snapshot token to %s store: %w", store.Name(), err)
+			}
+		}
+		snapshot.Metadata.SecretStore = store.Name()
+		snapshot.Metadata.Secrets = secrets
+	}
+
+	out, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	if err := app.writeFile(app.snapshotFile(snapshot.Metadata.ID), out, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	// Keep the history bounded
+	if limit := snapshotLimit(); limit > 0 {
+		if _, _, err := app.pruneSnapshots(limit); err != nil {
+			app.yellow.Printf("⚠️  Failed to prune old backups: %v\n", err)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// newSnapshotID returns an unused snapshot ID. Snapshots taken within the
+// same millisecond, e.g. of the backup and the settings on one switch, get a
+// sequence number that keeps them in order.
+func (app *Application) newSnapshotID(now time.Time, provider string) string {
+	stamp := now.UTC().Format(snapshotTimeFormat)
+	id := stamp + "-" + provider
+	for seq := 1; ; seq++ {
+		if _, err := app.readFile(app.snapshotFile(id)); os.IsNotExist(err) {
+			return id
+		}
+		id = fmt.Sprintf("%s.%02d-%s", stamp, seq, provider)
+	}
+}
+
+// snapshotLimit returns the maximum number of snapshots to keep, 0 for no
+// limit
+func snapshotLimit() int {
+	if value := os.Getenv("CLAUDE_SWITCH_BACKUP_LIMIT"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			return limit
+		}
+	}
+	return defaultSnapshotLimit
+}
+
+// stripSnapshotSecrets removes the tokens from settings content while a
+// secret store is selected and returns them by env key
+func (app *Application) stripSnapshotSecrets(data []byte) ([]byte, map[string]string, error) {
+	if app.defaultSecretStore() == SecretStoreFile {
+		return data, nil, nil
+	}
+
+	var config Config
+	if json.Unmarshal(data, &config) != nil {
+		return data, nil, nil
+	}
+
+	tokens := make(map[string]string)
+	for _, key := range snapshotSecretKeys {
+		if token := config.Env[key]; token != "" {
+			tokens[key] = token
+			config.deleteEnv(key)
+		}
+	}
+	if len(tokens) == 0 {
+		return data, nil, nil
+	}
+
+	stripped, err := json.Marshal(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	return stripped, tokens, nil
+}
+
+// snapshotSecretRefs maps env keys to the secret store keys of their tokens.
+// Keys derive from the token, so snapshots of the same token share a secret.
+func snapshotSecretRefs(tokens map[string]string) map[string]string {
+	if len(tokens) == 0 {
+		return nil
+	}
+	refs := make(map[string]string, len(tokens))
+	for envKey, token := range tokens {
+		sum := sha256.Sum256([]byte(token))
+		refs[envKey] = "snapshot/" + hex.EncodeToString(sum[:8])
+	}
+	return refs
+}
+
+// sameSecrets reports whether two snapshots refer to the same secrets
+func sameSecrets(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if b[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// snapshotSettings returns the settings of a snapshot with the tokens kept
+// in the secret store put back
+func (app *Application) snapshotSettings(snapshot *Snapshot) (*Config, error) {
+	var config Config
+	if err := json.Unmarshal(snapshot.Settings, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse backup: %w", err)
+	}
+	if len(snapshot.Metadata.Secrets) == 0 {
+		return &config, nil
+	}
+
+	store, err := app.secretStore(snapshot.Metadata.SecretStore)
+	if err != nil {
+		return nil, err
+	}
+	for envKey, key := range snapshot.Metadata.Secrets {
+		token, err := store.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s of backup %s from %s store: %w", envKey, snapshot.Metadata.ID, store.Name(), err)
+		}
+		config.Env[envKey] = token
+	}
+	return &config, nil
+}
+
+// forgetSnapshotSecrets deletes the secrets of removed snapshots that no
+// remaining snapshot refers to
+func (app *Application) forgetSnapshotSecrets(removed, kept []*Snapshot) error {
+	inUse := make(map[string]bool)
+	for _, snapshot := range kept {
+		for _, key := range snapshot.Metadata.Secrets {
+			inUse[snapshot.Metadata.SecretStore+"/"+key] = true
+		}
+	}
+
+	var errs []error
+	for _, snapshot := range removed {
+		for _, key := range snapshot.Metadata.Secrets {
+			ref := snapshot.Metadata.SecretStore + "/" + key
+			if inUse[ref] {
+				continue
+			}
+			inUse[ref] = true // delete once
+			store, err := app.secretStore(snapshot.Metadata.SecretStore)
+			if err == nil {
+				err = store.Delete(key)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// listSnapshots returns all snapshots, oldest first
+func (app *Application) listSnapshots() ([]*Snapshot, error) {
+	entries, err := os.ReadDir(app.backupDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var snapshots []*Snapshot
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(app.backupDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		var snapshot Snapshot
+		if json.Unmarshal(data, &snapshot) != nil {
+			continue
+		}
+		if snapshot.Metadata.ID == "" {
+			snapshot.Metadata.ID = strings.TrimSuffix(entry.Name(), ".json")
+		}
+		snapshots = append(snapshots, &snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Metadata.ID < snapshots[j].Metadata.ID
+	})
+
+	return snapshots, nil
+}
+
+// findSnapshot finds a snapshot by ID or unique ID prefix
+func (app *Application) findSnapshot(id string) (*Snapshot, error) {
+	if id == "" {
+		return nil, usageErrorf("backup id cannot be empty")
+	}
+
+	snapshots, err := app.listSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*Snapshot
+	for _, snapshot := range snapshots {
+		if snapshot.Metadata.ID == id {
+			return snapshot, nil
+		}
+		if strings.HasPrefix(snapshot.Metadata.ID, id) {
+			matches = append(matches, snapshot)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", errNoBackup, id)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("backup id %q is ambiguous (%d matches)", id, len(matches))
+	}
+}
+
+// runBackupCommand dispatches the backup subcommands
+func (app *Application) runBackupCommand(args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		return app.listBackups()
+	case "show":
+		if len(args) != 2 {
+			return usageErrorf("usage: claude-switch backup show <id>")
+		}
+		return app.showBackup(args[1])
+	case "restore":
+		if len(args) != 2 {
+			return usageErrorf("usage: claude-switch backup restore <id>")
+		}
+		return app.restoreBackup(args[1])
+	case "prune":
+		return app.pruneBackups(args[1:])
+	default:
+		return usageErrorf("unknown backup command %q (available: list, show, restore, prune)", args[0])
+	}
+}
+
+// listBackups prints all snapshots, newest first
+func (app *Application) listBackups() error {
+	snapshots, err := app.listSnapshots()
+	if err != nil {
+		return err
+	}
+
+	if len(snapshots) == 0 {
+		app.yellow.Println("⚠️  No backups found")
+		return nil
+	}
+
+	app.cyan.Println("💾 Backups")
+	fmt.Println()
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		meta := snapshots[i].Metadata
+		fmt.Printf("  %-36s %-12s %s\n", meta.ID, app.providerLabel(meta.Provider), meta.CreatedAt)
+	}
+
+	return nil
+}
+
+// showBackup prints a snapshot with tokens masked
+func (app *Application) showBackup(id string) error {
+	snapshot, err := app.findSnapshot(id)
+	if err != nil {
+		return err
+	}
+
+	meta := snapshot.Metadata
+	app.cyan.Printf("💾 Backup: %s\n", meta.ID)
+	fmt.Println()
+	app.cyan.Printf("  Provider: %s\n", app.providerLabel(meta.Provider))
+	app.cyan.Printf("  Created: %s\n", meta.CreatedAt)
+	app.cyan.Printf("  File: %s\n", meta.File)
+	if meta.Version != "" {
+		app.cyan.Printf("  Version: %s\n", meta.Version)
+	}
+
+	var config Config
+	if err := json.Unmarshal(snapshot.Settings, &config); err != nil {
+		return fmt.Errorf("failed to parse backup: %w", err)
+	}
+	if meta.SecretStore != "" {
+		app.cyan.Printf("  Secret store: %s\n", meta.SecretStore)
+	}
+
+	if len(config.fields) > 0 {
+		var names []string
+		for _, key := range config.keys {
+			if key != "env" {
+				names = append(names, key)
+			}
+		}
+		app.cyan.Printf("  Other settings: %s\n", strings.Join(names, ", "))
+	}
+
+	keys := make([]string, 0, len(config.Env)+len(meta.Secrets))
+	for key := range config.Env {
+		keys = append(keys, key)
+	}
+	for key := range meta.Secrets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Println()
+	for _, key := range keys {
+		value := config.Env[key]
+		if _, stored := meta.Secrets[key]; stored {
+			value = "(in " + meta.SecretStore + " store)"
+		} else if key == "ANTHROPIC_AUTH_TOKEN" {
+			value = maskToken(value)
+		}
+		fmt.Printf("  %s=%s\n", key, value)
+	}
+
+	return nil
+}
+
+// restoreBackup writes a snapshot back to the file it was taken from
+func (app *Application) restoreBackup(id string) error {
+	snapshot, err := app.findSnapshot(id)
+	if err != nil {
+		return err
+	}
+
+	config, err := app.snapshotSettings(snapshot)
+	if err != nil {
+		return err
+	}
+
+	target := snapshot.Metadata.File
+	if target == "" {
+		target = app.settingsFile
+	}
+
+	if err := app.saveConfigAtomic(target, config); err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	app.green.Printf("✅ Restored backup %s (%s)\n", snapshot.Metadata.ID, app.providerLabel(snapshot.Metadata.Provider))
+	return nil
+}
+
+// pruneBackups deletes all but the newest N snapshots
+func (app *Application) pruneBackups(args []string) error {
+	fs := flag.NewFlagSet("backup prune", flag.ContinueOnError)
+	keep := fs.Int("keep", 10, "Number of backups to keep")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *keep < 0 {
+		return usageErrorf("--keep must not be negative")
+	}
+
+	removed, kept, err := app.pruneSnapshots(*keep)
+	if err != nil && removed == 0 && kept == 0 {
+		return err
+	}
+	if err != nil {
+		app.yellow.Printf("⚠️  %v\n", err)
+	}
+
+	app.green.Printf("✅ Removed %d backup(s), kept %d\n", removed, kept)
+	return nil
+}
+
+// pruneSnapshots deletes all but the newest keep snapshots and the tokens
+// only they referred to. It returns how many were removed and kept.
+func (app *Application) pruneSnapshots(keep int) (int, int, error) {
+	snapshots, err := app.listSnapshots()
+	if err != nil || len(snapshots) <= keep {
+		return 0, len(snapshots), err
+	}
+
+	var removed, kept []*Snapshot
+	var errs []error
+	for i, snapshot := range snapshots {
+		if i >= len(snapshots)-keep {
+			kept = append(kept, snapshot)
+			continue
+		}
+		if err := app.removeFile(app.snapshotFile(snapshot.Metadata.ID)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove %s: %w", snapshot.Metadata.ID, err))
+			kept = append(kept, snapshot)
+			continue
+		}
+		removed = append(removed, snapshot)
+	}
+	if err := app.forgetSnapshotSecrets(removed, kept); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete backup tokens: %w", err))
+	}
+
+	return len(removed), len(kept), errors.Join(errs...)
+}
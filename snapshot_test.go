@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotKeepsTokensInSecretStore(t *testing.T) {
+	app := newTestApp(t)
+	useFakeKeyring(t)
+	t.Setenv("CLAUDE_SWITCH_SECRET_STORE", SecretStoreKeyring)
+
+	const token = "sk-ant-oat01-secret"
+	writeTestFile(t, app.settingsFile, `{"model":"opus","env":{"ANTHROPIC_AUTH_TOKEN":"`+token+`","DISABLE_TELEMETRY":"1"}}`)
+
+	snapshot, err := app.takeSnapshot(app.settingsFile)
+	if err != nil || snapshot == nil {
+		t.Fatalf("takeSnapshot() = %v, %v", snapshot, err)
+	}
+	data, err := os.ReadFile(filepath.Join(app.backupDir, snapshot.Metadata.ID+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Fatalf("snapshot file contains the token:\n%s", data)
+	}
+
+	// The same token is not snapshotted twice
+	if again, err := app.takeSnapshot(app.settingsFile); err != nil || again != nil {
+		t.Errorf("takeSnapshot() of unchanged settings = %v, %v; want nil", again, err)
+	}
+
+	writeTestFile(t, app.settingsFile, `{"env":{}}`)
+	if err := app.restoreBackup(snapshot.Metadata.ID); err != nil {
+		t.Fatalf("restoreBackup() error = %v", err)
+	}
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Env["ANTHROPIC_AUTH_TOKEN"] != token || config.Env["DISABLE_TELEMETRY"] != "1" {
+		t.Errorf("restored env = %v", config.Env)
+	}
+}
+
+func TestSnapshotIDsWithinOneMillisecond(t *testing.T) {
+	app := newTestApp(t)
+	app.plan = &writePlan{} // staged snapshots must not overwrite each other either
+
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id := app.newSnapshotID(now, ProviderZAI)
+		if err := app.writeFile(app.snapshotFile(id), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	want := []string{
+		"20251001-120000.000-z_ai",
+		"20251001-120000.000.01-z_ai",
+		"20251001-120000.000.02-z_ai",
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("id %d = %q, want %q", i, ids[i], want[i])
+		}
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("ids %v do not sort in the order they were taken", ids)
+	}
+}
+
+func TestFindSnapshot(t *testing.T) {
+	app := newTestApp(t)
+	for _, id := range []string{"20251001-120000.000-z_ai", "20251001-130000.000-anthropic", "20251002-090000.000-z_ai"} {
+		writeTestFile(t, app.snapshotFile(id), `{"_metadata":{"id":"`+id+`","provider":"z_ai"},"settings":{}}`)
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{name: "exact", id: "20251001-120000.000-z_ai", want: "20251001-120000.000-z_ai"},
+		{name: "unique prefix", id: "20251002", want: "20251002-090000.000-z_ai"},
+		{name: "ambiguous prefix", id: "20251001", wantErr: true},
+		{name: "unknown", id: "2024", wantErr: true},
+		{name: "empty", id: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := app.findSnapshot(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findSnapshot(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+			}
+			if err == nil && got.Metadata.ID != tt.want {
+				t.Errorf("findSnapshot(%q) = %q, want %q", tt.id, got.Metadata.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotLimit(t *testing.T) {
+	app := newTestApp(t)
+	t.Setenv("CLAUDE_SWITCH_BACKUP_LIMIT", "3")
+
+	for i := 0; i < 5; i++ {
+		writeTestFile(t, app.settingsFile, fmt.Sprintf(`{"env":{"API_TIMEOUT_MS":"%d"}}`, i))
+		if _, err := app.takeSnapshot(app.settingsFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := app.listSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 {
+		t.Fatalf("kept %d snapshots, want 3", len(snapshots))
+	}
+	config, err := app.snapshotSettings(snapshots[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Env["API_TIMEOUT_MS"]; got != "4" {
+		t.Errorf("newest snapshot has API_TIMEOUT_MS=%q, want 4", got)
+	}
+}
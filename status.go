@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// statusSchemaVersion is bumped whenever a field of StatusReport changes
+// meaning or is removed. New fields may be added without a bump.
+const statusSchemaVersion = 1
+
+// StatusReport is the machine-readable form of the status command
+type StatusReport struct {
+	SchemaVersion int            `json:"schema_version"`
+	Provider      string         `json:"provider"`
+	DisplayName   string         `json:"display_name"`
+	BaseURL       string         `json:"base_url"`
+	Models        ModelTiers     `json:"models"`
+	TimeoutMS     int64          `json:"timeout_ms"`
+	Token         StatusToken    `json:"token"`
+	Profile       string         `json:"profile"`
+	OtherEnvCount int            `json:"other_env_count"`
+	Backups       []StatusBackup `json:"backups"`
+	SavedTokens   []string       `json:"saved_tokens"`
+	SecretStore   string         `json:"secret_store"`
+	SettingsFile  string         `json:"settings_file"`
+	Proxy         *StatusProxy   `json:"proxy,omitempty"`
+	Budgets       []StatusBudget `json:"budgets,omitempty"`
+}
+
+// StatusBudget describes the use of a profile's budget
+type StatusBudget struct {
+	Profile  string         `json:"profile"`
+	Action   string         `json:"action"`
+	Fallback string         `json:"fallback,omitempty"`
+	Alerts   []int          `json:"alerts"`
+	Limits   []*BudgetLimit `json:"limits"`
+}
+
+// StatusProxy describes the enabled local proxy
+type StatusProxy struct {
+	URL      string            `json:"url"`
+	Upstream string            `json:"upstream"`
+	Routes   map[string]string `json:"routes,omitempty"`
+}
+
+// StatusToken describes the active auth token without revealing it
+type StatusToken struct {
+	Present bool      `json:"present"`
+	Masked  string    `json:"masked"`
+	Type    TokenType `json:"type"`
+}
+
+// StatusBackup describes a per-provider settings backup
+type StatusBackup struct {
+	Provider    string    `json:"provider"`
+	CreatedAt   string    `json:"created_at"`
+	Version     string    `json:"version"`
+	TokenType   TokenType `json:"token_type"`
+	SecretStore string    `json:"secret_store"`
+}
+
+// collectStatus gathers the current configuration into a StatusReport
+func (app *Application) collectStatus() (*StatusReport, error) {
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	name := app.currentProvider(config)
+	report := &StatusReport{
+		SchemaVersion: statusSchemaVersion,
+		Provider:      name,
+		DisplayName:   app.providerLabel(name),
+		BaseURL:       config.Env["ANTHROPIC_BASE_URL"],
+		Models: ModelTiers{
+			Opus:   config.Env["ANTHROPIC_DEFAULT_OPUS_MODEL"],
+			Sonnet: config.Env["ANTHROPIC_DEFAULT_SONNET_MODEL"],
+			Haiku:  config.Env["ANTHROPIC_DEFAULT_HAIKU_MODEL"],
+		},
+		Token:        StatusToken{Type: TokenTypeUnknown},
+		Backups:      []StatusBackup{},
+		SavedTokens:  []string{},
+		SecretStore:  app.defaultSecretStore(),
+		SettingsFile: app.settingsFile,
+	}
+	if name == ProviderUnknown {
+		report.DisplayName = "None"
+	}
+
+	if timeout := config.Env["API_TIMEOUT_MS"]; timeout != "" {
+		if ms, err := strconv.ParseInt(timeout, 10, 64); err == nil {
+			report.TimeoutMS = ms
+		}
+	}
+
+	if token := config.Env["ANTHROPIC_AUTH_TOKEN"]; token != "" {
+		report.Token = StatusToken{
+			Present: true,
+			Masked:  maskToken(token),
+			Type:    detectTokenType(token),
+		}
+	}
+
+	if profiles, err := app.loadProfiles(); err == nil {
+		if active := profiles.find(profiles.Active); active != nil && active.Provider == name {
+			report.Profile = active.Name
+		}
+	}
+
+	for key := range config.Env {
+		if !isProviderKey(key) {
+			report.OtherEnvCount++
+		}
+	}
+
+	for _, provider := range append(providerNames(), ProviderCustom) {
+		ok, backup, _ := app.readBackup(provider)
+		if !ok || backup == nil {
+			continue
+		}
+		report.Backups = append(report.Backups, StatusBackup{
+			Provider:    provider,
+			CreatedAt:   backup.Metadata.CreatedAt,
+			Version:     backup.Metadata.Version,
+			TokenType:   detectTokenType(backup.Env["ANTHROPIC_AUTH_TOKEN"]),
+			SecretStore: backup.Metadata.SecretStore,
+		})
+	}
+
+	for _, p := range providerRegistry {
+		file := app.tokenFilePath(p.TokenSource())
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err == nil {
+			report.SavedTokens = append(report.SavedTokens, p.Name())
+		}
+	}
+
+	if state, err := app.loadProxyState(); err == nil && state != nil && state.Enabled {
+		report.Proxy = &StatusProxy{URL: state.URL(), Upstream: state.Upstream, Routes: state.Routes}
+	}
+
+	if profiles, err := app.loadProfiles(); err == nil {
+		now := time.Now()
+		var spend map[string]*budgetSpend
+		for _, profile := range profiles.Profiles {
+			if profile.Budget == nil {
+				continue
+			}
+			if spend == nil {
+				// Spend is best-effort; an unreadable ledger leaves budgets out
+				if spend, err = app.loadSpend(now); err != nil {
+					break
+				}
+			}
+			report.Budgets = append(report.Budgets, StatusBudget{
+				Profile:  profile.Name,
+				Action:   profile.Budget.action(),
+				Fallback: profile.Budget.Fallback,
+				Alerts:   profile.Budget.alerts(),
+				Limits:   app.budgetStatus(profile, spend, now),
+			})
+		}
+	}
+
+	return report, nil
+}
+
+// runStatusCommand shows the status as text, JSON, a Go template or just
+// the provider name
+func (app *Application) runStatusCommand(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the status as JSON")
+	format := fs.String("format", "", "Format the status with a Go template, e.g. '{{.Provider}}'")
+	short := fs.Bool("short", false, "Print only the provider name")
+	merged := fs.Bool("merged", false, "Show the effective configuration of all scopes")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("usage: claude-switch status [--merged] [--json | --format <template> | --short]")
+	}
+
+	selected := 0
+	for _, set := range []bool{*asJSON, *format != "", *short} {
+		if set {
+			selected++
+		}
+	}
+	if selected > 1 {
+		return usageErrorf("--json, --format and --short cannot be combined")
+	}
+
+	// Status runs from shell prompts and scripts; never ask for a passphrase
+	app.nonInteractive = true
+
+	// Prompts call --short on every render; read nothing but the settings
+	// and, through the proxy, its upstream
+	if *short && !*merged {
+		config, err := app.loadConfig(app.settingsFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		fmt.Println(app.currentProvider(config))
+		return nil
+	}
+	var report interface{}
+	var provider string
+	if *merged {
+		status, err := app.mergedStatus()
+		if err != nil {
+			return err
+		}
+		if selected == 0 {
+			app.showMergedStatus(status)
+			return nil
+		}
+		report, provider = status, status.Provider
+	} else {
+		if selected == 0 {
+			return app.showStatus()
+		}
+		status, err := app.collectStatus()
+		if err != nil {
+			return err
+		}
+		report, provider = status, status.Provider
+	}
+
+	switch {
+	case *asJSON:
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal status: %w", err)
+		}
+		fmt.Println(string(data))
+	case *short:
+		fmt.Println(provider)
+	default:
+		tmpl, err := template.New("status").Parse(*format)
+		if err != nil {
+			return fmt.Errorf("invalid --format template: %w", err)
+		}
+		var out strings.Builder
+		if err := tmpl.Execute(&out, report); err != nil {
+			return fmt.Errorf("failed to format status: %w", err)
+		}
+		fmt.Println(strings.TrimRight(out.String(), "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// tokenCommandTimeout bounds how long a token command may run, leaving time
+// for password managers that ask to be unlocked
+const tokenCommandTimeout = 2 * time.Minute
+
+// tokenFromCommand runs a shell command and returns the first line of its
+// output as the token. Stdin and stderr stay attached to the terminal so
+// password managers can prompt for unlocking.
+func tokenFromCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenCommandTimeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, command)
+	var stdout bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("token command timed out after %s", tokenCommandTimeout)
+		}
+		return "", fmt.Errorf("token command failed: %w", err)
+	}
+
+	line, _, _ := strings.Cut(stdout.String(), "\n")
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", fmt.Errorf("token command produced no output")
+	}
+
+	return token, nil
+}
+
+// shellCommand runs a command line through the platform's shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// readEnvFile looks up a variable in a .env style file. Blank lines,
+// comments, "export" prefixes and surrounding quotes are handled.
+func readEnvFile(path, key string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(name) != key {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		return value, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// envFileKey returns the variable looked up in a provider's env file
+func envFileKey(source TokenSource) string {
+	if source.EnvVar != "" {
+		return source.EnvVar
+	}
+	return "ANTHROPIC_AUTH_TOKEN"
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	writeTestFile(t, path, `# provider tokens
+ZAI_KEY=plain
+
+export QUOTED="sk-double"
+SINGLE='sk-single'
+  SPACED = sk-spaced
+ANTHROPIC_AUTH_TOKEN=sk-default
+EMPTY=
+`)
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"ZAI_KEY", "plain"},
+		{"QUOTED", "sk-double"},
+		{"SINGLE", "sk-single"},
+		{"SPACED", "sk-spaced"},
+		{"ANTHROPIC_AUTH_TOKEN", "sk-default"},
+		{"EMPTY", ""},
+		{"MISSING", ""},
+	}
+	for _, tt := range tests {
+		got, err := readEnvFile(path, tt.key)
+		if err != nil || got != tt.want {
+			t.Errorf("readEnvFile(%q) = %q, %v; want %q", tt.key, got, err, tt.want)
+		}
+	}
+
+	if _, err := readEnvFile(filepath.Join(t.TempDir(), "missing.env"), "ZAI_KEY"); err == nil {
+		t.Error("readEnvFile() of a missing file error = nil")
+	}
+}
+
+func TestTokenFromCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands use sh syntax")
+	}
+
+	tests := []struct {
+		command string
+		want    string
+		wantErr bool
+	}{
+		{command: `printf '  sk-cmd  \nsecond line\n'`, want: "sk-cmd"},
+		{command: `echo sk-cmd; echo note >&2`, want: "sk-cmd"},
+		{command: `true`, wantErr: true},
+		{command: `echo sk-cmd; exit 3`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := tokenFromCommand(tt.command)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("tokenFromCommand(%q) = %q, %v; want %q, error %v", tt.command, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLookupTokenSources(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the token command uses sh syntax")
+	}
+
+	envFile := filepath.Join(t.TempDir(), ".env")
+	writeTestFile(t, envFile, "TEST_SOURCES_TOKEN=sk-env-file\n")
+
+	tests := []struct {
+		name   string
+		env    string // value of TEST_SOURCES_TOKEN
+		source TokenSource
+		want   string
+	}{
+		{
+			name:   "environment first",
+			env:    "sk-environment",
+			source: TokenSource{EnvVar: "TEST_SOURCES_TOKEN", Command: "echo sk-command", EnvFile: envFile},
+			want:   "sk-environment",
+		},
+		{
+			name:   "command before env file",
+			source: TokenSource{EnvVar: "TEST_SOURCES_TOKEN", Command: "echo sk-command", EnvFile: envFile},
+			want:   "sk-command",
+		},
+		{
+			name:   "env file",
+			source: TokenSource{EnvVar: "TEST_SOURCES_TOKEN", EnvFile: envFile},
+			want:   "sk-env-file",
+		},
+		{
+			name:   "secret store",
+			source: TokenSource{EnvVar: "TEST_SOURCES_TOKEN", EnvFile: filepath.Join(t.TempDir(), "missing.env")},
+			want:   "sk-stored",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			t.Setenv("TEST_SOURCES_TOKEN", tt.env)
+			store, err := app.secretStore(SecretStoreFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Set("provider/sources", "sk-stored"); err != nil {
+				t.Fatal(err)
+			}
+
+			provider := &ProviderSpec{ID: "sources", Display: "Sources", Token: tt.source}
+			got, err := app.lookupToken(provider, true)
+			if err != nil || got != tt.want {
+				t.Errorf("lookupToken() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
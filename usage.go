@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// projectHeader lets a client name the project its requests are recorded
+// under; the proxy strips it before forwarding
+const projectHeader = "X-Claude-Switch-Project"
+
+// projectHeaderLine returns the projectHeader line of ANTHROPIC_CUSTOM_HEADERS
+func projectHeaderLine(headers string) string {
+	for _, line := range strings.Split(headers, "\n") {
+		if name, _, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(name), projectHeader) {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// withProjectHeader replaces the projectHeader line of a provider's
+// ANTHROPIC_CUSTOM_HEADERS with the one of the current settings. The project
+// belongs to the settings file, not to a provider, so it survives switches.
+func withProjectHeader(headers, current string) string {
+	var lines []string
+	for _, line := range strings.Split(headers, "\n") {
+		if strings.TrimSpace(line) != "" && projectHeaderLine(line) == "" {
+			lines = append(lines, line)
+		}
+	}
+	if project := projectHeaderLine(current); project != "" {
+		lines = append(lines, project)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// UsageRecord is one proxied request in the usage ledger
+type UsageRecord struct {
+	Time                time.Time `json:"time"`
+	Upstream            string    `json:"upstream"` // provider or "profile:<name>"
+	Provider            string    `json:"provider"`
+	Model               string    `json:"model"` // model sent to the upstream
+	RequestedModel      string    `json:"requested_model,omitempty"`
+	Project             string    `json:"project,omitempty"`
+	Status              int       `json:"status"`
+	LatencyMS           int64     `json:"latency_ms"`
+	Stream              bool      `json:"stream,omitempty"`
+	InputTokens         int       `json:"input_tokens"`
+	OutputTokens        int       `json:"output_tokens"`
+	CacheCreationTokens int       `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadTokens     int       `json:"cache_read_input_tokens,omitempty"`
+}
+
+// ModelPrice is the price of a model in USD per million tokens. Unset cache
+// prices fall back to the input price.
+type ModelPrice struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheWrite float64 `json:"cache_write,omitempty"`
+	CacheRead  float64 `json:"cache_read,omitempty"`
+}
+
+// defaultPrices are list prices per provider, keyed by model name prefix.
+// providers.json "prices" overrides or extends them.
+var defaultPrices = map[string]map[string]ModelPrice{
+	ProviderAnthropic: {
+		"claude-opus-4-5":   {Input: 5, Output: 25, CacheWrite: 6.25, CacheRead: 0.5},
+		"claude-opus-4":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.5},
+		"claude-sonnet-4":   {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3},
+		"claude-haiku-4-5":  {Input: 1, Output: 5, CacheWrite: 1.25, CacheRead: 0.1},
+		"claude-3-7-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3},
+		"claude-3-5-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3},
+		"claude-3-5-haiku":  {Input: 0.8, Output: 4, CacheWrite: 1, CacheRead: 0.08},
+		"claude-3-opus":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.5},
+		"claude-3-haiku":    {Input: 0.25, Output: 1.25, CacheWrite: 0.3, CacheRead: 0.03},
+	},
+	ProviderZAI: {
+		"glm-4.6":     {Input: 0.6, Output: 2.2, CacheRead: 0.11},
+		"glm-4.5":     {Input: 0.6, Output: 2.2, CacheRead: 0.11},
+		"glm-4.5-air": {Input: 0.2, Output: 1.1, CacheRead: 0.03},
+	},
+}
+
+// modelPrice finds the price of a provider's model, preferring configured
+// prices and the longest matching model name prefix
+func (app *Application) modelPrice(provider, model string) (ModelPrice, bool) {
+	model = strings.ToLower(model)
+	for _, table := range []map[string]ModelPrice{app.prices[provider], defaultPrices[provider]} {
+		best := -1
+		var price ModelPrice
+		for prefix, p := range table {
+			if strings.HasPrefix(model, strings.ToLower(prefix)) && len(prefix) > best {
+				best, price = len(prefix), p
+			}
+		}
+		if best >= 0 {
+			return price, true
+		}
+	}
+	return ModelPrice{}, false
+}
+
+// cost estimates the cost of a request in USD
+func (p ModelPrice) cost(r *UsageRecord) float64 {
+	cacheWrite, cacheRead := p.CacheWrite, p.CacheRead
+	if cacheWrite == 0 {
+		cacheWrite = p.Input
+	}
+	if cacheRead == 0 {
+		cacheRead = p.Input
+	}
+	return (float64(r.InputTokens)*p.Input +
+		float64(r.OutputTokens)*p.Output +
+		float64(r.CacheCreationTokens)*cacheWrite +
+		float64(r.CacheReadTokens)*cacheRead) / 1e6
+}
+
+// usageMu serializes appends to the ledger from concurrent requests
+var usageMu sync.Mutex
+
+// recordUsage appends a request to the usage ledger
+func (app *Application) recordUsage(record *UsageRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	if err := app.rotateUsage(time.Now()); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(app.usageFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// usageArchive returns the ledger file of a past month
+func (app *Application) usageArchive(month time.Time) string {
+	return strings.TrimSuffix(app.usageFile, ".jsonl") + month.Format("-2006-01") + ".jsonl"
+}
+
+// rotateUsage moves the ledger to the archive of the month it was last
+// written in once that month is over, so the ledger only holds the current
+// month
+func (app *Application) rotateUsage(now time.Time) error {
+	info, err := os.Stat(app.usageFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	_, month := periodStarts(now)
+	if !info.ModTime().Before(month) {
+		return nil
+	}
+
+	archive := app.usageArchive(info.ModTime().In(now.Location()))
+	if _, err := os.Stat(archive); os.IsNotExist(err) {
+		return os.Rename(app.usageFile, archive)
+	}
+	// The clock went back since the archive was written; append to it
+	data, err := os.ReadFile(app.usageFile)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(archive, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(app.usageFile)
+}
+
+// loadUsage reads the ledger records since a time, from the archives of the
+// months it covers and the current ledger. Lines that cannot be parsed, e.g.
+// one cut off by a crash, are skipped.
+func (app *Application) loadUsage(since time.Time) ([]*UsageRecord, error) {
+	archives, err := filepath.Glob(strings.TrimSuffix(app.usageFile, ".jsonl") + "-*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(archives)
+
+	var records []*UsageRecord
+	prefix := strings.TrimSuffix(app.usageFile, ".jsonl") + "-"
+	for _, archive := range archives {
+		month, err := time.ParseInLocation("2006-01", strings.TrimSuffix(strings.TrimPrefix(archive, prefix), ".jsonl"), time.Local)
+		if err != nil || !month.AddDate(0, 1, 0).After(since) {
+			continue
+		}
+		if records, err = readUsage(archive, since, records); err != nil {
+			return nil, err
+		}
+	}
+	return readUsage(app.usageFile, since, records)
+}
+
+// readUsage appends the records of a ledger file since a time
+func readUsage(filename string, since time.Time, records []*UsageRecord) ([]*UsageRecord, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record UsageRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			continue
+		}
+		if !record.Time.Before(since) {
+			records = append(records, &record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// messageUsage is the usage block of a Messages API response or event
+type messageUsage struct {
+	InputTokens              *int `json:"input_tokens"`
+	OutputTokens             *int `json:"output_tokens"`
+	CacheCreationInputTokens *int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     *int `json:"cache_read_input_tokens"`
+}
+
+// usageMeter collects the token usage of a Messages API response as it is
+// written to the client, from the JSON body or the streamed events
+type usageMeter struct {
+	stream bool
+	buf    []byte
+	record *UsageRecord
+}
+
+// maxMeteredBody bounds the non-streamed body kept for reading its usage
+const maxMeteredBody = 8 * 1024 * 1024
+
+// write feeds response bytes to the meter
+func (m *usageMeter) write(p []byte) {
+	if !m.stream {
+		if len(m.buf)+len(p) <= maxMeteredBody {
+			m.buf = append(m.buf, p...)
+		}
+		return
+	}
+
+	m.buf = append(m.buf, p...)
+	for {
+		i := bytes.IndexByte(m.buf, '\n')
+		if i < 0 {
+			return
+		}
+		line := m.buf[:i]
+		m.buf = m.buf[i+1:]
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			var event struct {
+				Message struct {
+					Usage *messageUsage `json:"usage"`
+				} `json:"message"`
+				Usage *messageUsage `json:"usage"`
+			}
+			if json.Unmarshal(bytes.TrimSpace(data), &event) == nil {
+				// message_start carries the input usage, message_delta
+				// the output tokens so far
+				m.add(event.Message.Usage)
+				m.add(event.Usage)
+			}
+		}
+	}
+}
+
+// finish reads the usage of a non-streamed body
+func (m *usageMeter) finish() {
+	if m.stream {
+		return
+	}
+	var body struct {
+		Usage *messageUsage `json:"usage"`
+	}
+	if json.Unmarshal(m.buf, &body) == nil {
+		m.add(body.Usage)
+	}
+	m.buf = nil
+}
+
+// add takes the counts a usage block reports; later events report totals
+func (m *usageMeter) add(usage *messageUsage) {
+	if usage == nil {
+		return
+	}
+	for _, field := range []struct {
+		value *int
+		dest  *int
+	}{
+		{usage.InputTokens, &m.record.InputTokens},
+		{usage.OutputTokens, &m.record.OutputTokens},
+		{usage.CacheCreationInputTokens, &m.record.CacheCreationTokens},
+		{usage.CacheReadInputTokens, &m.record.CacheReadTokens},
+	} {
+		if field.value != nil && *field.value > 0 {
+			*field.dest = *field.value
+		}
+	}
+}
+
+// parseSince parses a --since value: a duration such as 7d or 12h, or a
+// date
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (use e.g. 7d, 12h or 2006-01-02)", value)
+}
+
+// UsageGroup is the usage of one provider, model or project
+type UsageGroup struct {
+	Key                 string  `json:"key"`
+	Requests            int     `json:"requests"`
+	Errors              int     `json:"errors"`
+	InputTokens         int     `json:"input_tokens"`
+	OutputTokens        int     `json:"output_tokens"`
+	CacheCreationTokens int     `json:"cache_creation_input_tokens"`
+	CacheReadTokens     int     `json:"cache_read_input_tokens"`
+	CostUSD             float64 `json:"cost_usd"`
+	Unpriced            int     `json:"unpriced_requests"` // requests missing from the cost
+}
+
+// UsageReport is the usage since a time, grouped by one dimension
+type UsageReport struct {
+	Since    time.Time     `json:"since"`
+	By       string        `json:"by"`
+	Groups   []*UsageGroup `json:"groups"`
+	Total    *UsageGroup   `json:"total"`
+	Unpriced []string      `json:"unpriced_models,omitempty"` // provider/model without a price
+}
+
+// runUsageCommand reports the requests recorded by the proxy
+func (app *Application) runUsageCommand(args []string) error {
+	fs := flag.NewFlagSet("usage", flag.ContinueOnError)
+	sinceFlag := fs.String("since", "30d", "Report usage since a duration ago or a date")
+	by := fs.String("by", "provider", "Group by provider, upstream, model or project")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("usage: claude-switch usage [--since <7d|12h|date>] [--by provider|upstream|model|project] [--json]")
+	}
+	since, err := parseSince(*sinceFlag, time.Now())
+	if err != nil {
+		return &usageError{msg: err.Error()}
+	}
+
+	key, err := usageGroupKey(*by)
+	if err != nil {
+		return err
+	}
+
+	records, err := app.loadUsage(since)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", app.usageFile, err)
+	}
+	report := app.usageReport(records, since, *by, key)
+
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal usage: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	app.showUsageReport(report)
+	return nil
+}
+
+// usageGroupKey returns the grouping of a usage report. Provider groups
+// all profiles of a provider, upstream keeps each profile apart.
+func usageGroupKey(by string) (func(*UsageRecord) string, error) {
+	switch by {
+	case "provider":
+		return func(r *UsageRecord) string { return r.Provider }, nil
+	case "upstream":
+		return func(r *UsageRecord) string { return r.Upstream }, nil
+	case "model":
+		return func(r *UsageRecord) string { return r.Provider + "/" + r.Model }, nil
+	case "project":
+		return func(r *UsageRecord) string {
+			if r.Project == "" {
+				return "(none)"
+			}
+			return r.Project
+		}, nil
+	default:
+		return nil, usageErrorf("unknown --by %q (available: provider, upstream, model, project)", by)
+	}
+}
+
+// usageReport groups records and estimates their cost
+func (app *Application) usageReport(records []*UsageRecord, since time.Time, by string, key func(*UsageRecord) string) *UsageReport {
+	report := &UsageReport{Since: since, By: by, Groups: []*UsageGroup{}, Total: &UsageGroup{Key: "total"}}
+	groups := map[string]*UsageGroup{}
+	unpriced := map[string]bool{}
+	for _, r := range records {
+		k := key(r)
+		group := groups[k]
+		if group == nil {
+			group = &UsageGroup{Key: k}
+			groups[k] = group
+			report.Groups = append(report.Groups, group)
+		}
+
+		for _, g := range []*UsageGroup{group, report.Total} {
+			g.Requests++
+			if r.Status >= 400 {
+				g.Errors++
+			}
+			g.InputTokens += r.InputTokens
+			g.OutputTokens += r.OutputTokens
+			g.CacheCreationTokens += r.CacheCreationTokens
+			g.CacheReadTokens += r.CacheReadTokens
+		}
+
+		// Failed requests without tokens cost nothing
+		if r.InputTokens+r.OutputTokens+r.CacheCreationTokens+r.CacheReadTokens == 0 {
+			continue
+		}
+		price, ok := app.modelPrice(r.Provider, r.Model)
+		if !ok {
+			group.Unpriced++
+			report.Total.Unpriced++
+			unpriced[r.Provider+"/"+r.Model] = true
+			continue
+		}
+		cost := price.cost(r)
+		group.CostUSD += cost
+		report.Total.CostUSD += cost
+	}
+
+	sort.SliceStable(report.Groups, func(i, j int) bool {
+		if report.Groups[i].CostUSD != report.Groups[j].CostUSD {
+			return report.Groups[i].CostUSD > report.Groups[j].CostUSD
+		}
+		return report.Groups[i].Key < report.Groups[j].Key
+	})
+	for model := range unpriced {
+		report.Unpriced = append(report.Unpriced, model)
+	}
+	sort.Strings(report.Unpriced)
+	return report
+}
+
+// showUsageReport prints a usage report as a table
+func (app *Application) showUsageReport(report *UsageReport) {
+	app.cyan.Printf("📈 Usage since %s by %s\n", report.Since.Format("2006-01-02 15:04"), report.By)
+	fmt.Println()
+	if len(report.Groups) == 0 {
+		app.yellow.Println("No requests recorded; usage is recorded by 'claude-switch proxy serve'")
+		return
+	}
+
+	row := func(g *UsageGroup) string {
+		cost := formatCost(g.CostUSD)
+		if g.Unpriced > 0 {
+			cost += "*"
+		}
+		return fmt.Sprintf("%-32s %8d %7d %12s %12s %12s %12s %10s", g.Key, g.Requests, g.Errors,
+			formatTokens(g.InputTokens), formatTokens(g.OutputTokens),
+			formatTokens(g.CacheCreationTokens), formatTokens(g.CacheReadTokens), cost)
+	}
+	fmt.Printf("%-32s %8s %7s %12s %12s %12s %12s %10s\n", strings.ToUpper(report.By), "REQUESTS", "ERRORS", "INPUT", "OUTPUT", "CACHE WRITE", "CACHE READ", "COST")
+	for _, g := range report.Groups {
+		fmt.Println(row(g))
+	}
+	app.green.Println(row(report.Total))
+
+	if len(report.Unpriced) > 0 {
+		fmt.Println()
+		app.yellow.Printf("* Excludes %d request(s) without a price: %s\n", report.Total.Unpriced, strings.Join(report.Unpriced, ", "))
+		app.yellow.Println("  Add prices per million tokens to \"prices\" in providers.json")
+	}
+}
+
+// formatCost formats USD, keeping cents of a cent visible
+func formatCost(usd float64) string {
+	if usd > 0 && usd < 0.01 {
+		return fmt.Sprintf("$%.4f", usd)
+	}
+	return fmt.Sprintf("$%.2f", usd)
+}
+
+// formatTokens groups the digits of a token count
+func formatTokens(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithProjectHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+		current string
+		want    string
+	}{
+		{name: "nothing set", want: ""},
+		{
+			name:    "project kept",
+			current: "X-Claude-Switch-Project: billing",
+			want:    "X-Claude-Switch-Project: billing",
+		},
+		{
+			name:    "provider headers and project",
+			headers: "X-Org: acme",
+			current: "X-Org: other\nx-claude-switch-project: billing",
+			want:    "X-Org: acme\nx-claude-switch-project: billing",
+		},
+		{
+			name:    "stale project of the provider dropped",
+			headers: "X-Claude-Switch-Project: old\nX-Org: acme",
+			current: "X-Claude-Switch-Project: billing",
+			want:    "X-Org: acme\nX-Claude-Switch-Project: billing",
+		},
+		{
+			name:    "project removed from the settings",
+			headers: "X-Claude-Switch-Project: old",
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withProjectHeader(tt.headers, tt.current); got != tt.want {
+				t.Errorf("withProjectHeader(%q, %q) = %q, want %q", tt.headers, tt.current, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSwitchKeepsProjectHeader(t *testing.T) {
+	app := newTestApp(t)
+	app.assumeYes = true
+	t.Setenv("Z_AI_AUTH_TOKEN", "sk-zai")
+	writeTestFile(t, app.settingsFile, `{"env":{"ANTHROPIC_CUSTOM_HEADERS":"X-Claude-Switch-Project: billing"}}`)
+
+	for _, name := range []string{ProviderZAI, ProviderAnthropic} {
+		provider, err := app.resolveProvider(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := app.switchTo(provider); err != nil && err != errNoBackup {
+			t.Fatalf("switchTo(%s) error = %v", name, err)
+		}
+
+		config, err := app.loadConfig(app.settingsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := config.Env["ANTHROPIC_CUSTOM_HEADERS"]; got != "X-Claude-Switch-Project: billing" {
+			t.Errorf("after switching to %s ANTHROPIC_CUSTOM_HEADERS = %q", name, got)
+		}
+	}
+}
+
+func TestUsageRotation(t *testing.T) {
+	app := newTestApp(t)
+
+	now := time.Now()
+	_, month := periodStarts(now)
+	lastMonth := month.AddDate(0, -1, 3)
+	old := &UsageRecord{Time: lastMonth, Upstream: ProviderZAI, Model: "glm-4.6", InputTokens: 100}
+	if err := app.recordUsage(old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(app.usageFile, lastMonth, lastMonth); err != nil {
+		t.Fatal(err)
+	}
+
+	current := &UsageRecord{Time: now, Upstream: ProviderZAI, Model: "glm-4.6", InputTokens: 5}
+	if err := app.recordUsage(current); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := app.usageArchive(lastMonth)
+	if _, err := os.Stat(archive); err != nil {
+		t.Fatalf("last month's ledger was not archived: %v", err)
+	}
+	tests := []struct {
+		name  string
+		since time.Time
+		want  []int // input tokens of the records
+	}{
+		{name: "everything", want: []int{100, 5}},
+		{name: "last month", since: month.AddDate(0, -1, 0), want: []int{100, 5}},
+		{name: "this month", since: month, want: []int{5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := app.loadUsage(tt.since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("loadUsage() returned %d record(s), want %d", len(records), len(tt.want))
+			}
+			for i, record := range records {
+				if record.InputTokens != tt.want[i] {
+					t.Errorf("record %d has %d input tokens, want %d", i, record.InputTokens, tt.want[i])
+				}
+			}
+		})
+	}
+
+	spend, err := app.loadSpend(now)
+	if err != nil {
+		t.Fatalf("loadSpend() error = %v", err)
+	}
+	if got := spend[ProviderZAI].monthTokens; got != 5 {
+		t.Errorf("month tokens = %d, want 5", got)
+	}
+}
+
+func TestUsageReportGroups(t *testing.T) {
+	app := newTestApp(t)
+	records := []*UsageRecord{
+		{Upstream: "profile:work", Provider: ProviderZAI, Model: "glm-4.6", InputTokens: 10},
+		{Upstream: "profile:home", Provider: ProviderZAI, Model: "glm-4.6", InputTokens: 20},
+		{Upstream: ProviderZAI, Provider: ProviderZAI, Model: "glm-4.5-air", InputTokens: 30},
+		{Upstream: ProviderAnthropic, Provider: ProviderAnthropic, Model: "claude-sonnet-4-5", InputTokens: 40, Project: "billing"},
+	}
+
+	tests := []struct {
+		by   string
+		want map[string]int // input tokens per group
+	}{
+		{by: "provider", want: map[string]int{ProviderZAI: 60, ProviderAnthropic: 40}},
+		{by: "upstream", want: map[string]int{"profile:work": 10, "profile:home": 20, ProviderZAI: 30, ProviderAnthropic: 40}},
+		{by: "model", want: map[string]int{"z_ai/glm-4.6": 30, "z_ai/glm-4.5-air": 30, "anthropic/claude-sonnet-4-5": 40}},
+		{by: "project", want: map[string]int{"(none)": 60, "billing": 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			key, err := usageGroupKey(tt.by)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := app.usageReport(records, time.Time{}, tt.by, key)
+			got := map[string]int{}
+			for _, group := range report.Groups {
+				got[group.Key] = group.InputTokens
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groups = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := usageGroupKey("profile"); err == nil {
+		t.Error("usageGroupKey(\"profile\") error = nil, want a usage error")
+	}
+}
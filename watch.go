@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Watcher decisions
+const (
+	watchStay = iota
+	watchFailover
+	watchRecover
+)
+
+// watcher decides when to fail over from the primary to the fallback and
+// back, from the outcomes of consecutive probes of the primary
+type watcher struct {
+	failures   int  // failed probes in a row that trigger a failover
+	recover    int  // healthy probes in a row that trigger switching back
+	onFallback bool // the fallback is active
+	streak     int  // failed probes on the primary, or healthy ones on the fallback
+}
+
+// observe records a probe of the primary and returns the decision
+func (w *watcher) observe(healthy bool) int {
+	if w.onFallback == healthy {
+		w.streak++
+	} else {
+		w.streak = 0
+	}
+
+	switch {
+	case !w.onFallback && w.streak >= w.failures:
+		w.onFallback, w.streak = true, 0
+		return watchFailover
+	case w.onFallback && w.streak >= w.recover:
+		w.onFallback, w.streak = false, 0
+		return watchRecover
+	default:
+		return watchStay
+	}
+}
+
+// targetName formats a target the way parseAutoTarget reads it
+func targetName(t *autoTarget) string {
+	if t.Profile != "" {
+		return "profile:" + t.Profile
+	}
+	return t.Provider
+}
+
+// sameTarget reports whether two targets select the same provider or profile
+func sameTarget(a, b *autoTarget) bool {
+	return a.Profile == b.Profile && normalizeProviderName(a.Provider) == normalizeProviderName(b.Provider)
+}
+
+// runWatchCommand probes the active provider periodically and fails over to
+// a fallback while it is unhealthy
+func (app *Application) runWatchCommand(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", time.Minute, "Time between probes")
+	failures := fs.Int("failures", 3, "Failed probes in a row before failing over")
+	recoverAfter := fs.Int("recover", 3, "Healthy probes in a row before switching back")
+	fallbackFlag := fs.String("fallback", "", "Provider or profile:<name> to fail over to")
+	timeoutFlag := fs.Duration("timeout", 0, "Timeout per probe (default: API_TIMEOUT_MS, at most 30s)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("watch takes no arguments")
+	}
+	if *interval <= 0 || *failures < 1 || *recoverAfter < 1 {
+		return usageErrorf("--interval, --failures and --recover must be positive")
+	}
+
+	// Runs unattended; tokens must come from the secret store or env
+	app.nonInteractive = true
+	app.assumeYes = true
+
+	primary, err := app.currentTarget()
+	if err != nil {
+		return err
+	}
+	fallbackName := *fallbackFlag
+	if fallbackName == "" {
+		fallbackName = app.fallbacks[targetName(primary)]
+	}
+	if fallbackName == "" {
+		return usageErrorf("no fallback for %s; pass --fallback or add it to \"fallbacks\" in %s", targetName(primary), app.providersFile)
+	}
+	fallback, err := parseAutoTarget(fallbackName)
+	if err != nil {
+		return &usageError{msg: fmt.Sprintf("invalid fallback: %v", err)}
+	}
+	if sameTarget(primary, fallback) {
+		return usageErrorf("the fallback %s is already active", fallbackName)
+	}
+
+	// Fail early when the primary cannot be probed at all
+	if _, _, err := app.primaryEnv(primary, false); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := &watcher{failures: *failures, recover: *recoverAfter}
+	app.logf(app.cyan, "👀 Watching %s every %s; failing over to %s after %d failed probe(s)",
+		targetName(primary), *interval, targetName(fallback), *failures)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		if primary, err = app.watchStep(w, primary, fallback, *timeoutFlag); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			app.logf(app.cyan, "👋 Stopped watching %s", targetName(primary))
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchStep probes the primary once and fails over or switches back when
+// the watcher decides so. It returns the primary, which follows switches
+// made outside watch.
+func (app *Application) watchStep(w *watcher, primary, fallback *autoTarget, timeout time.Duration) (*autoTarget, error) {
+	// Follow switches made outside watch
+	if current, err := app.currentTarget(); err == nil {
+		onFallback := w.onFallback
+		switch {
+		case sameTarget(current, primary):
+			onFallback = false
+		case sameTarget(current, fallback):
+			onFallback = true
+		default:
+			app.logf(app.yellow, "⚠️  %s was selected outside watch; watching it instead", targetName(current))
+			if _, _, err := app.primaryEnv(current, false); err != nil {
+				return nil, err
+			}
+			primary, onFallback = current, false
+		}
+		if onFallback != w.onFallback {
+			w.onFallback, w.streak = onFallback, 0
+		}
+	}
+
+	healthy, detail := app.probePrimary(primary, w.onFallback, timeout)
+	if !healthy {
+		app.logf(app.yellow, "⚠️  %s probe failed: %s", targetName(primary), detail)
+	}
+
+	switch w.observe(healthy) {
+	case watchFailover:
+		app.logf(app.red, "🔀 %s failed %d probe(s) in a row; switching to %s", targetName(primary), w.failures, targetName(fallback))
+		if err := app.applyWatchTarget(fallback); err != nil {
+			app.logf(app.red, "❌ Failed to switch to %s: %v", targetName(fallback), err)
+			w.onFallback = false
+		}
+	case watchRecover:
+		app.logf(app.green, "🔀 %s healthy for %d probe(s); switching back", targetName(primary), w.recover)
+		if err := app.applyWatchTarget(primary); err != nil {
+			app.logf(app.red, "❌ Failed to switch back to %s: %v", targetName(primary), err)
+			w.onFallback = true
+		}
+	}
+	return primary, nil
+}
+
+// currentTarget returns the active profile, or the active provider when no
+// profile of it is active. With the proxy enabled it is the proxy's upstream.
+func (app *Application) currentTarget() (*autoTarget, error) {
+	if state, err := app.loadProxyState(); err != nil {
+		return nil, err
+	} else if state != nil && state.Enabled {
+		return parseAutoTarget(state.Upstream)
+	}
+
+	config, err := app.loadConfig(app.settingsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	current := app.detectProvider(config)
+	if current == ProviderUnknown {
+		current = ProviderAnthropic
+	}
+
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if active := profiles.find(profiles.Active); active != nil && active.Provider == current {
+		return &autoTarget{Profile: active.Name}, nil
+	}
+	return &autoTarget{Provider: current}, nil
+}
+
+// primaryEnv returns the env to probe the primary with: the settings while
+// it is active, its stored configuration while the fallback is or while
+// settings.json points at the proxy
+func (app *Application) primaryEnv(primary *autoTarget, onFallback bool) (map[string]string, string, error) {
+	var env map[string]string
+	var err error
+	if state, _ := app.loadProxyState(); onFallback || (state != nil && state.Enabled) {
+		name := primary.Provider
+		if primary.Profile != "" {
+			name = primary.Profile
+		}
+		env, _, err = app.execEnv(name, true)
+	} else {
+		var config *Config
+		config, err = app.loadConfig(app.settingsFile)
+		if config != nil {
+			env = config.Env
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if env["ANTHROPIC_AUTH_TOKEN"] == "" && env["ANTHROPIC_API_KEY"] == "" {
+		return nil, "", fmt.Errorf("%s has no token to probe; Claude Code's own login cannot be monitored", targetName(primary))
+	}
+	return env, probeModels(env)[0][1], nil
+}
+
+// probePrimary sends one probe to the primary. Rate limiting counts as a
+// failure since Claude Code cannot work through it either.
+func (app *Application) probePrimary(primary *autoTarget, onFallback bool, timeout time.Duration) (bool, string) {
+	env, model, err := app.primaryEnv(primary, onFallback)
+	if err != nil {
+		return false, err.Error()
+	}
+	if timeout == 0 {
+		if timeout, err = probeTimeout(env); err != nil {
+			return false, err.Error()
+		}
+		if timeout > defaultProbeTimeout {
+			timeout = defaultProbeTimeout
+		}
+	}
+
+	result := probeModel(&http.Client{Timeout: timeout}, probeTargetFromEnv(env), model)
+	if result.Outcome == ProbeOK {
+		return true, ""
+	}
+	detail := result.Outcome
+	if result.Message != "" {
+		detail += ": " + result.Message
+	}
+	return false, detail
+}
+
+// applyWatchTarget switches to a provider or profile through the regular
+// switch, holding the settings lock and discarding its output
+func (app *Application) applyWatchTarget(target *autoTarget) error {
+	lock, err := app.acquireLock()
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	return app.quietly(func() error {
+		if target.Profile != "" {
+			return app.useProfile(target.Profile)
+		}
+		provider, err := app.resolveProvider(target.Provider)
+		if err != nil {
+			return err
+		}
+		return app.switchTo(provider)
+	})
+}
+
+// quietly runs fn with stdout discarded. Already-active targets and a
+// missing Anthropic backup still leave the target applied. It swaps the
+// process-wide writers, so only single-goroutine commands like watch may use
+// it; the proxy uses quiet lookups instead.
+func (app *Application) quietly(fn func() error) error {
+	stdout, output := os.Stdout, color.Output
+	if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+		defer devNull.Close()
+	}
+	color.Output = io.Discard
+	defer func() { os.Stdout, color.Output = stdout, output }()
+
+	if err := fn(); err != nil && err != errAlreadyActive && err != errNoBackup {
+		return err
+	}
+	return nil
+}
+
+// logf prints a timestamped watch log line
+func (app *Application) logf(c *color.Color, format string, args ...interface{}) {
+	c.Printf("%s %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatcherObserve(t *testing.T) {
+	tests := []struct {
+		name   string
+		probes []bool
+		want   []int
+	}{
+		{
+			name:   "healthy",
+			probes: []bool{true, true, true},
+			want:   []int{watchStay, watchStay, watchStay},
+		},
+		{
+			name:   "fails over after the failure streak",
+			probes: []bool{false, false},
+			want:   []int{watchStay, watchFailover},
+		},
+		{
+			name:   "a healthy probe resets the failure streak",
+			probes: []bool{false, true, false, true, false},
+			want:   []int{watchStay, watchStay, watchStay, watchStay, watchStay},
+		},
+		{
+			name:   "recovers after the healthy streak",
+			probes: []bool{false, false, true, false, true, true},
+			want:   []int{watchStay, watchFailover, watchStay, watchStay, watchStay, watchRecover},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &watcher{failures: 2, recover: 2}
+			for i, healthy := range tt.probes {
+				if got := w.observe(healthy); got != tt.want[i] {
+					t.Fatalf("observe() #%d = %d, want %d", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// flakyServer answers Messages API requests with the next status of a
+// script, then with 200
+type flakyServer struct {
+	mu       sync.Mutex
+	statuses []int
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	s.mu.Unlock()
+
+	w.WriteHeader(status)
+	if status == http.StatusOK {
+		w.Write([]byte(`{"type":"message","content":[]}`))
+	} else {
+		w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+	}
+}
+
+// useTestProvider registers a provider for the endpoint at baseURL for the
+// duration of the test
+func useTestProvider(t *testing.T, name, baseURL string) {
+	t.Helper()
+
+	registry := append([]Provider(nil), providerRegistry...)
+	t.Cleanup(func() { providerRegistry = registry })
+
+	envVar := "TEST_" + name + "_TOKEN"
+	t.Setenv(envVar, "sk-"+name)
+	registerProvider(&ProviderSpec{
+		ID:      name,
+		Display: name,
+		Env:     map[string]string{"ANTHROPIC_BASE_URL": baseURL},
+		Token:   TokenSource{EnvVar: envVar},
+	})
+}
+
+func TestWatchFailsOverAndBack(t *testing.T) {
+	app := newTestApp(t)
+	app.assumeYes = true
+
+	primaryServer := &flakyServer{}
+	primaryEndpoint := httptest.NewServer(primaryServer)
+	defer primaryEndpoint.Close()
+	fallbackEndpoint := httptest.NewServer(&flakyServer{})
+	defer fallbackEndpoint.Close()
+	useTestProvider(t, "flaky", primaryEndpoint.URL)
+	useTestProvider(t, "steady", fallbackEndpoint.URL)
+
+	provider, err := app.resolveProvider("flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.switchTo(provider); err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary flip-flops, then stays down, then comes back
+	primaryServer.statuses = []int{500, 200, 529, 200, 500, 529, 500, 200, 500, 200, 200}
+	want := []string{
+		"flaky", "flaky", "flaky", "flaky", // no failure streak
+		"flaky", "steady", // two failures in a row
+		"steady", "steady", "steady", "steady", // no healthy streak
+		"flaky", // two healthy probes in a row
+	}
+
+	w := &watcher{failures: 2, recover: 2}
+	primary, fallback := &autoTarget{Provider: "flaky"}, &autoTarget{Provider: "steady"}
+	for i, wantActive := range want {
+		primary, err = app.watchStep(w, primary, fallback, time.Second)
+		if err != nil {
+			t.Fatalf("watchStep() #%d error = %v", i+1, err)
+		}
+
+		current, err := app.currentTarget()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if targetName(current) != wantActive {
+			t.Fatalf("after probe #%d the active provider is %s, want %s", i+1, targetName(current), wantActive)
+		}
+	}
+	if len(primaryServer.statuses) != 0 {
+		t.Errorf("%d probe(s) of the primary were not sent", len(primaryServer.statuses))
+	}
+}